@@ -18,10 +18,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/driver"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
 
 func init() {
@@ -31,10 +37,29 @@ func init() {
 var (
 	endpoint = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
 	nodeID   = flag.String("nodeid", "", "node id")
+	// Every volume removal runs up to 16 requests at once, so bursts of
+	// DeleteVolume calls could otherwise overwhelm the endpoint
+	maxRequests = flag.Int64("max-concurrent-requests", 100, "maximum number of concurrent S3 requests, 0 for no limit")
+	diagnose    = flag.String("diagnose", "", "check the connection to S3 with the secret mounted at this directory and exit")
+	// Cluster-wide defaults for secrets and storage classes that don't set them
+	defaultEndpoint = flag.String("default-endpoint", "", "S3 endpoint for secrets without endpoint")
+	defaultRegion   = flag.String("default-region", "", "S3 region for secrets without region")
+	defaultMounter  = flag.String("default-mounter", "", "mounter for storage classes without mounter, geesefs if empty")
+	// Should be shorter than the termination grace period of the pod
+	shutdownTimeout = flag.Duration("shutdown-timeout", 25*time.Second, "how long to wait for S3 operations in flight, like volume removals, on termination")
 )
 
 func main() {
 	flag.Parse()
+	s3.SetRequestLimit(*maxRequests)
+	driver.SetShutdownTimeout(*shutdownTimeout)
+	err := s3.SetDefaults(s3.Defaults{Endpoint: *defaultEndpoint, Region: *defaultRegion, Mounter: *defaultMounter})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *diagnose != "" {
+		os.Exit(runDiagnose(*diagnose))
+	}
 
 	driver, err := driver.New(*nodeID, *endpoint)
 	if err != nil {
@@ -43,3 +68,41 @@ func main() {
 	driver.Run()
 	os.Exit(0)
 }
+
+// runDiagnose runs the diagnostics of the S3 client with the secret keys in
+// dir, one file per key like a mounted Kubernetes secret, and returns the
+// exit code
+func runDiagnose(dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	secret := make(map[string]string)
+	for _, file := range files {
+		// Skip the ..data links of mounted secrets
+		if file.IsDir() || strings.HasPrefix(file.Name(), "..") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			log.Print(err)
+			return 2
+		}
+		secret[file.Name()] = strings.TrimSpace(string(data))
+	}
+	client, err := s3.NewClientFromSecret(secret)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	results, err := client.Diagnose()
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
@@ -19,10 +19,12 @@ package driver
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
@@ -35,6 +37,38 @@ import (
 	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
+const (
+	reusePrefixKey        = "reusePrefix"
+	noPlaceholderKey      = "noPlaceholder"
+	policyPrincipalKey    = "policyPrincipal"
+	bucketTagsKey         = "bucketTags"
+	bucketVersioningKey   = "bucketVersioning"
+	objectMetadataKey     = "objectMetadata"
+	objectTagsKey         = "objectTags"
+	objectContentTypeKey  = "objectContentType"
+	objectCacheControlKey = "objectCacheControl"
+	s3StorageClassKey     = "s3StorageClass"
+	capacityFromUsageKey  = "capacityFromUsage"
+	restoreDaysKey        = "restoreDays"
+	warmupMaxObjectsKey   = "warmupMaxObjects"
+	enforceCapacityKey    = "enforceCapacity"
+	prefixEncodingKey     = "prefixEncoding"
+	compressMetadataKey   = "compressMetadata"
+	// Replication of the buckets created by the driver
+	replicationRoleKey         = "replicationRole"
+	replicationDestinationKey  = "replicationDestination"
+	replicationStorageClassKey = "replicationStorageClass"
+	// Notification of the events of the buckets created by the driver
+	notificationTargetKey = "notificationTarget"
+	notificationEventsKey = "notificationEvents"
+	// Passed by the external-provisioner with --extra-create-metadata
+	pvcNameKey      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	// volumeLockTTL is how long a volume lock is honoured if its owner
+	// doesn't release it, e.g. because the controller crashed
+	volumeLockTTL = 10 * time.Minute
+)
+
 type controllerServer struct {
 	*csicommon.DefaultControllerServer
 }
@@ -49,8 +83,14 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	// check if bucket name is overridden
 	if params[mounter.BucketKey] != "" {
 		bucketName = params[mounter.BucketKey]
-		prefix = volumeID
-		volumeID = path.Join(bucketName, prefix)
+		// The volume ID holds the encoded prefix, so that all later
+		// operations and the mounters use the same keys
+		encoded, err := s3.EncodePrefix(volumeID, params[prefixEncodingKey])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		prefix = encoded
+		volumeID = s3.FormatVolumeID(bucketName, prefix)
 	}
 
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
@@ -65,36 +105,178 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if req.GetVolumeCapabilities() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
-
-	glog.V(4).Infof("Got a request to create volume %s", volumeID)
-
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	if err := s3.CheckAccessPointBucket(bucketName); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s3.CheckDirectoryBucket(bucketName); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s3.ValidatePrefix(prefix); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	replication := replicationConfig(params)
+	if replication != nil {
+		if err := s3.ValidateReplication(*replication); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	tags, err := bucketTags(params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", bucketTagsKey, err))
 	}
-
-	exists, err := client.BucketExists(bucketName)
+	notification := notificationConfig(params)
+	if notification != nil {
+		if err := s3.ValidateNotification(*notification); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if _, err := s3.ParseMounters(params[mounter.FallbackKey]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", mounter.FallbackKey, err))
+	}
+	for _, key := range []string{mounter.UploadLimitKey, mounter.DownloadLimitKey} {
+		if _, err := s3.ParseBandwidthLimit(params[key]); err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", key, err))
+		}
+	}
+	objectMetadata, err := s3.ParseObjectMetadata(params[objectMetadataKey])
 	if err != nil {
-		return nil, fmt.Errorf("failed to check if bucket %s exists: %v", volumeID, err)
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", objectMetadataKey, err))
 	}
-
-	if !exists {
-		if err = client.CreateBucket(bucketName); err != nil {
-			return nil, fmt.Errorf("failed to create bucket %s: %v", bucketName, err)
+	if err := s3.ValidateStorageClass(params[s3StorageClassKey]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", s3StorageClassKey, err))
+	}
+	objTags, err := objectTags(params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %v", objectTagsKey, err))
+	}
+	if days := params[restoreDaysKey]; days != "" {
+		if n, err := strconv.Atoi(days); err != nil || n < 1 {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %q is not a positive number of days", restoreDaysKey, days))
+		}
+	}
+	if max := params[warmupMaxObjectsKey]; max != "" {
+		if n, err := strconv.Atoi(max); err != nil || n < 1 {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s: %q is not a positive number of objects", warmupMaxObjectsKey, max))
 		}
 	}
+	// Refuse absurd mount options before creating anything
+	if err := s3.ValidateMeta(getMeta(bucketName, prefix, params)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	if err = client.CreatePrefix(bucketName, prefix); err != nil {
-		return nil, fmt.Errorf("failed to create prefix %s: %v", prefix, err)
+	// The log lines of the S3 client start with the operation ID
+	opID := s3.NewOperationID()
+	glog.V(4).Infof("Got a request to create volume %s, operation %s", volumeID, opID)
+
+	client, err := s3.NewClientFromSecret(s3.WithStorageClass(req.GetSecrets(), params))
+	if err != nil {
+		if errors.Is(err, s3.ErrInvalidConfig) {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to initialize S3 client: %v", err))
+		}
+		return nil, s3Error(err, "failed to initialize S3 client")
 	}
+	client.SetOperationID(opID)
 
-	glog.V(4).Infof("create volume %s", volumeID)
 	// DeleteVolume lacks VolumeContext, but publish&unpublish requests have it,
-	// so we don't need to store additional metadata anywhere
+	// so the metadata object is only kept for bookkeeping
 	context := make(map[string]string)
 	for k, v := range params {
 		context[k] = v
 	}
+
+	if client.Config.Anonymous {
+		// Public buckets are mounted read-only as they are, nothing to create
+		exists, err := client.BucketExists(bucketName)
+		if err != nil {
+			return nil, s3Error(err, "failed to check if bucket %s exists", bucketName)
+		}
+		if !exists {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("bucket %s does not exist and cannot be created with anonymous access", bucketName))
+		}
+	} else {
+		// The capacity is filled in once the existing volume has been checked
+		meta := getMeta(bucketName, prefix, params)
+		locked := false
+		defer func() {
+			if locked {
+				unlockVolume(client, bucketName, prefix)
+			}
+		}()
+		hooks := s3.ProvisionHooks{
+			SetupBucket: func() error {
+				if params[bucketVersioningKey] == "true" {
+					if err := client.SetBucketVersioning(bucketName, true); err != nil {
+						return err
+					}
+				}
+				if replication != nil {
+					if err := client.SetBucketReplication(bucketName, *replication); err != nil {
+						if errors.Is(err, s3.ErrVersioningRequired) {
+							return status.Error(codes.FailedPrecondition, fmt.Sprintf("%v, set %s: \"true\" to replicate buckets", err, bucketVersioningKey))
+						}
+						return s3Error(err, "failed to set replication of bucket %s", bucketName)
+					}
+				}
+				if notification != nil {
+					if err := client.SetBucketNotification(bucketName, *notification); err != nil {
+						return s3Error(err, "failed to set notification of bucket %s", bucketName)
+					}
+				}
+				return nil
+			},
+			BeforePrefix: func(created bool) error {
+				tagBucket(client, bucketName, prefix, created, tags, params)
+
+				if prefix != "" {
+					if err := lockVolume(client, bucketName, prefix); err != nil {
+						return err
+					}
+					locked = true
+				}
+				capacity, existing, err := checkExistingVolume(client, bucketName, prefix, capacityBytes, params)
+				if err != nil {
+					return err
+				}
+				capacityBytes, meta.CapacityBytes = capacity, capacity
+
+				// The data of an identical existing volume belongs to this volume
+				client.Config.ReusePrefix = params[reusePrefixKey] == "true" || existing
+				if client.Config.ReusePrefix && params[enforceCapacityKey] == "true" {
+					if err := checkCapacity(client, bucketName, prefix, capacityBytes); err != nil {
+						return err
+					}
+				}
+				client.Config.NoPlaceholder = params[noPlaceholderKey] == "true"
+				client.Config.CompressMetadata = params[compressMetadataKey] == "true"
+				client.Config.ObjectMetadata = objectMetadata
+				client.Config.ObjectTags = objTags
+				client.Config.ObjectContentType = params[objectContentTypeKey]
+				client.Config.ObjectCacheControl = params[objectCacheControlKey]
+				client.Config.ObjectStorageClass = params[s3StorageClassKey]
+				return nil
+			},
+			BeforeMeta: func() error {
+				// Fail now rather than when the first pod tries to write
+				if err := client.CheckWritable(bucketName, prefix); err != nil {
+					if errors.Is(err, s3.ErrNotWritable) {
+						return status.Error(codes.PermissionDenied, err.Error())
+					}
+					return s3Error(err, "failed to check if volume %s is writable", volumeID)
+				}
+				return nil
+			},
+		}
+		if err = client.ProvisionVolume(meta, true, hooks); err != nil {
+			return nil, provisionError(err, volumeID)
+		}
+		if prefix != "" && params[policyPrincipalKey] != "" {
+			if err = client.SetPrefixPolicy(bucketName, prefix, params[policyPrincipalKey]); err != nil {
+				return nil, s3Error(err, "failed to set policy of volume %s", volumeID)
+			}
+		}
+	}
+
+	glog.V(4).Infof("create volume %s", volumeID)
 	context["capacity"] = fmt.Sprintf("%v", capacityBytes)
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -107,38 +289,100 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
-	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
 
 	// Check arguments
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
+	bucketName, prefix, err := s3.ParseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
 		glog.V(3).Infof("Invalid delete volume req: %v", req)
 		return nil, err
 	}
-	glog.V(4).Infof("Deleting volume %s", volumeID)
+	opID := s3.NewOperationID()
+	glog.V(4).Infof("Deleting volume %s, operation %s", volumeID, opID)
 
 	client, err := s3.NewClientFromSecret(req.GetSecrets())
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
+	client.SetOperationID(opID)
+
+	if client.Config.Anonymous {
+		// A secret without credentials may as well be a missing or mistyped
+		// one, only explicitly anonymous volumes are left alone
+		if req.GetSecrets()["anonymous"] != "true" {
+			return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf(
+				"refusing to delete volume %s without credentials: check the delete secret of its storage class, or set anonymous: \"true\" in it for public buckets",
+				volumeID,
+			))
+		}
+		// Data of public buckets is never owned by the driver
+		glog.V(4).Infof("Volume %s uses anonymous access, leaving its data in place", volumeID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err = client.CheckBucketAllowed(bucketName); err != nil {
+		return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("refusing to delete volume %s: %v", volumeID, err))
+	}
 
 	var deleteErr error
 	if prefix == "" {
 		// prefix is empty, we delete the whole bucket
-		if err := client.RemoveBucket(bucketName); err != nil && err.Error() != "The specified bucket does not exist" {
+		if err := checkVolumeMeta(client, bucketName, prefix, client.Config.SkipDeleteCheck); err != nil {
+			return nil, err
+		}
+		stats, err := client.RemoveBucket(bucketName)
+		if err != nil {
 			deleteErr = err
+		} else {
+			glog.Infof("Bucket %s removed, freed %d objects, %d bytes", bucketName, stats.Objects, stats.Bytes)
+			if stats.Failed > 0 {
+				glog.Warningf("Volume %s force-deleted, %d objects left in bucket %s", volumeID, stats.Failed, bucketName)
+			}
 		}
-		glog.V(4).Infof("Bucket %s removed", bucketName)
 	} else {
-		if err := client.RemovePrefix(bucketName, prefix); err != nil {
+		// Nothing to lock and remove if the bucket is already gone
+		exists, err := client.BucketExists(bucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if bucket %s exists: %v", bucketName, err)
+		}
+		if !exists {
+			glog.Warningf("Bucket %s of volume %s does not exist, nothing to delete", bucketName, volumeID)
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		if err := lockVolume(client, bucketName, prefix); err != nil {
+			return nil, err
+		}
+		if err := checkVolumeMeta(client, bucketName, prefix, client.Config.SkipDeleteCheck); err != nil {
+			unlockVolume(client, bucketName, prefix)
+			return nil, err
+		}
+		stats, err := client.RemovePrefix(bucketName, prefix)
+		if err != nil {
 			deleteErr = fmt.Errorf("unable to remove prefix: %w", err)
+		} else {
+			glog.Infof("Prefix %s of bucket %s removed, freed %d objects, %d bytes", prefix, bucketName, stats.Objects, stats.Bytes)
+			if stats.Failed > 0 {
+				glog.Warningf("Volume %s force-deleted, %d objects left in prefix %s of bucket %s", volumeID, stats.Failed, prefix, bucketName)
+			}
+			if err := client.RemovePrefixPolicy(bucketName, prefix); err != nil {
+				glog.Warningf("Failed to remove policy of prefix %s: %v", prefix, err)
+			}
 		}
-		glog.V(4).Infof("Prefix %s removed", prefix)
+		unlockVolume(client, bucketName, prefix)
 	}
 
+	if errors.Is(deleteErr, s3.ErrDeleteTooLarge) || errors.Is(deleteErr, s3.ErrBucketPreserved) {
+		return nil, status.Error(codes.FailedPrecondition, deleteErr.Error())
+	}
+	if errors.Is(deleteErr, s3.ErrClosed) || errors.Is(deleteErr, context.Canceled) {
+		// Cut short by the termination of the driver, retried after restart
+		return nil, status.Error(codes.Unavailable, deleteErr.Error())
+	}
 	if deleteErr != nil {
 		return nil, deleteErr
 	}
@@ -154,9 +398,12 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	if req.GetVolumeCapabilities() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities missing in request")
 	}
-	bucketName, _ := volumeIDToBucketPrefix(req.GetVolumeId())
+	bucketName, _, err := s3.ParseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := s3.NewClientFromSecret(s3.WithStorageClass(req.GetSecrets(), req.GetVolumeContext()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
@@ -195,6 +442,225 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.Unimplemented, "ControllerExpandVolume is not implemented")
 }
 
+type bucketTagger interface {
+	SetBucketTags(bucketName string, tags map[string]string) error
+}
+
+// bucketTags returns the tags of buckets set in the storage class, checked
+// before anything is created
+func bucketTags(params map[string]string) (map[string]string, error) {
+	tags, err := s3.ParseTags(params[bucketTagsKey])
+	if err != nil {
+		return nil, err
+	}
+	if err = s3.ValidateBucketTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// tagBucket applies the tags from the storage class to the bucket of a
+// volume. Buckets the driver just created are always tagged, existing buckets
+// of a single volume only if the storage class sets tags, and existing shared
+// buckets never. Buckets of a single volume are also tagged with the PVC
+// name. Failures are only logged, as the volume works without tags.
+func tagBucket(client bucketTagger, bucketName, prefix string, created bool, tags, params map[string]string) {
+	if !created && (prefix != "" || len(tags) == 0) {
+		return
+	}
+	merged := make(map[string]string, len(tags)+2)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	if prefix == "" {
+		if params[pvcNameKey] != "" {
+			merged["pvc-name"] = params[pvcNameKey]
+		}
+		if params[pvcNamespaceKey] != "" {
+			merged["pvc-namespace"] = params[pvcNamespaceKey]
+		}
+	}
+	if len(merged) == 0 {
+		return
+	}
+	if err := client.SetBucketTags(bucketName, merged); err != nil {
+		glog.Warningf("Failed to tag bucket %s: %v", bucketName, err)
+	}
+}
+
+// replicationConfig returns the replication of the buckets created by the
+// driver set in the storage class, if any
+func replicationConfig(params map[string]string) *s3.ReplicationConfig {
+	if params[replicationRoleKey] == "" && params[replicationDestinationKey] == "" {
+		return nil
+	}
+	return &s3.ReplicationConfig{
+		Role:              params[replicationRoleKey],
+		DestinationBucket: params[replicationDestinationKey],
+		StorageClass:      params[replicationStorageClassKey],
+	}
+}
+
+type existingVolumeChecker interface {
+	VolumeExists(bucketName, prefix string) (bool, *s3.FSMeta, error)
+	GetBucketUsage(bucketName, prefix string) (int64, error)
+}
+
+// checkExistingVolume returns the capacity of a volume about to be created
+// and whether it already exists. CreateVolume must only be idempotent for an
+// identical request, and adopted buckets can report their current usage as
+// capacity.
+func checkExistingVolume(client existingVolumeChecker, bucketName, prefix string, capacityBytes int64, params map[string]string) (int64, bool, error) {
+	volumeID := s3.FormatVolumeID(bucketName, prefix)
+	_, meta, err := client.VolumeExists(bucketName, prefix)
+	if err != nil {
+		return 0, false, s3Error(err, "failed to check if volume %s exists", volumeID)
+	}
+	capacityFromUsage := capacityBytes == 0 || params[capacityFromUsageKey] == "true"
+	if meta != nil {
+		if (!capacityFromUsage && meta.CapacityBytes != capacityBytes) || meta.Mounter != params[mounter.TypeKey] {
+			return 0, false, status.Error(codes.AlreadyExists, fmt.Sprintf(
+				"volume %s already exists with capacity %d and mounter %q",
+				volumeID, meta.CapacityBytes, meta.Mounter,
+			))
+		}
+		if capacityFromUsage && meta.CapacityBytes > capacityBytes {
+			capacityBytes = meta.CapacityBytes
+		}
+		return capacityBytes, true, nil
+	}
+	if capacityFromUsage {
+		usage, err := client.GetBucketUsage(bucketName, prefix)
+		if err != nil {
+			return 0, false, s3Error(err, "failed to get usage of volume %s", volumeID)
+		}
+		// The capacity must not be less than requested
+		if usage > capacityBytes {
+			capacityBytes = usage
+		}
+	}
+	return capacityBytes, false, nil
+}
+
+// provisionError maps the errors of ProvisionVolume to gRPC status codes.
+// Errors of the hooks of CreateVolume already carry one.
+func provisionError(err error, volumeID string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, s3.ErrBucketOwnedByOther), errors.Is(err, s3.ErrPrefixNotEmpty):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, s3.ErrBucketNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, s3.ErrInvalidBucketName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, s3.ErrBucketNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return s3Error(err, "failed to provision volume %s", volumeID)
+}
+
+type capacityChecker interface {
+	CapacityExceeded(meta *s3.FSMeta) (bool, int64, error)
+}
+
+// checkCapacity refuses volumes whose existing data takes more than their
+// capacity, for storage classes enforcing capacities
+func checkCapacity(client capacityChecker, bucketName, prefix string, capacityBytes int64) error {
+	exceeded, usage, err := client.CapacityExceeded(&s3.FSMeta{BucketName: bucketName, Prefix: prefix, CapacityBytes: capacityBytes})
+	if err != nil {
+		return s3Error(err, "failed to get usage of volume %s", s3.FormatVolumeID(bucketName, prefix))
+	}
+	if exceeded {
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("volume %s holds %d bytes, more than its capacity of %d bytes",
+			s3.FormatVolumeID(bucketName, prefix), usage, capacityBytes))
+	}
+	return nil
+}
+
+// notificationConfig returns the notification of the events of the buckets
+// created by the driver, nil if not configured
+func notificationConfig(params map[string]string) *s3.NotificationConfig {
+	if params[notificationTargetKey] == "" && params[notificationEventsKey] == "" {
+		return nil
+	}
+	cfg := &s3.NotificationConfig{Target: params[notificationTargetKey]}
+	for _, event := range strings.Split(params[notificationEventsKey], ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			cfg.Events = append(cfg.Events, event)
+		}
+	}
+	return cfg
+}
+
+// objectTags returns the tags of the objects the driver creates in a volume,
+// from the storage class and the PVC
+func objectTags(params map[string]string) (map[string]string, error) {
+	tags, err := s3.ParseTags(params[objectTagsKey])
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		// Only tagged if asked for, tagging needs s3:PutObjectTagging
+		return nil, nil
+	}
+	if params[pvcNameKey] != "" {
+		tags["pvc-name"] = params[pvcNameKey]
+	}
+	if params[pvcNamespaceKey] != "" {
+		tags["pvc-namespace"] = params[pvcNamespaceKey]
+	}
+	if err = s3.ValidateObjectTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+type volumeChecker interface {
+	CheckVolumeMeta(bucketName, prefix string) error
+}
+
+type volumeLocker interface {
+	AcquireLock(bucketName, prefix string, ttl time.Duration) error
+	ReleaseLock(bucketName, prefix string) error
+}
+
+// lockVolume serializes operations on a prefix volume across controller
+// replicas and retried requests
+func lockVolume(client volumeLocker, bucketName, prefix string) error {
+	if err := client.AcquireLock(bucketName, prefix, volumeLockTTL); err != nil {
+		if errors.Is(err, s3.ErrLocked) {
+			return status.Error(codes.Aborted, err.Error())
+		}
+		return fmt.Errorf("failed to lock volume %s/%s: %v", bucketName, prefix, err)
+	}
+	return nil
+}
+
+func unlockVolume(client volumeLocker, bucketName, prefix string) {
+	if err := client.ReleaseLock(bucketName, prefix); err != nil {
+		glog.Warningf("Failed to release lock of volume %s/%s: %v", bucketName, prefix, err)
+	}
+}
+
+// checkVolumeMeta refuses to delete a volume whose metadata names another
+// bucket or prefix, unless skipDeleteCheck is set in the secret
+func checkVolumeMeta(client volumeChecker, bucketName, prefix string, skip bool) error {
+	err := client.CheckVolumeMeta(bucketName, prefix)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, s3.ErrMetadataMismatch) {
+		return fmt.Errorf("failed to check metadata of volume %s/%s: %v", bucketName, prefix, err)
+	}
+	if skip {
+		glog.Warningf("Deleting volume %s/%s despite mismatching metadata: %v", bucketName, prefix, err)
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, fmt.Sprintf("refusing to delete volume: %v", err))
+}
+
 func sanitizeVolumeID(volumeID string) string {
 	volumeID = strings.ToLower(volumeID)
 	if len(volumeID) > 63 {
@@ -205,15 +671,19 @@ func sanitizeVolumeID(volumeID string) string {
 	return volumeID
 }
 
-// volumeIDToBucketPrefix returns the bucket name and prefix based on the volumeID.
-// Prefix is empty if volumeID does not have a slash in the name.
-func volumeIDToBucketPrefix(volumeID string) (string, string) {
-	// if the volumeID has a slash in it, this volume is
-	// stored under a certain prefix within the bucket.
-	splitVolumeID := strings.SplitN(volumeID, "/", 2)
-	if len(splitVolumeID) > 1 {
-		return splitVolumeID[0], splitVolumeID[1]
+// s3Error describes a failed S3 request of CreateVolume. The
+// external-provisioner records the message in an event on the PVC, so it
+// starts with the S3 error code and a hint for users, e.g. "AccessDenied:
+// check that the credentials in the secret are allowed to access the bucket".
+func s3Error(err error, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	code, hint, ok := s3.ErrorHint(err)
+	switch {
+	case !ok:
+		return fmt.Errorf("%s: %v", msg, err)
+	case code == "":
+		return fmt.Errorf("%s: %s: %v", hint, msg, err)
+	default:
+		return fmt.Errorf("%s: %s: %s: %v", code, hint, msg, err)
 	}
-
-	return volumeID, ""
 }
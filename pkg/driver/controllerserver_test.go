@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeTagger struct {
+	tags map[string]map[string]string
+	err  error
+}
+
+func (f *fakeTagger) SetBucketTags(bucketName string, tags map[string]string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.tags[bucketName] = tags
+	return nil
+}
+
+func TestTagBucket(t *testing.T) {
+	pvc := map[string]string{pvcNameKey: "data", pvcNamespaceKey: "default"}
+	withTags := map[string]string{bucketTagsKey: "team=data", pvcNameKey: "data", pvcNamespaceKey: "default"}
+	tests := []struct {
+		name    string
+		prefix  string
+		created bool
+		params  map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "created bucket of a volume",
+			created: true,
+			params:  pvc,
+			want:    map[string]string{"pvc-name": "data", "pvc-namespace": "default"},
+		},
+		{
+			name:   "adopted bucket without tags",
+			params: pvc,
+		},
+		{
+			name:   "adopted bucket with tags",
+			params: withTags,
+			want:   map[string]string{"team": "data", "pvc-name": "data", "pvc-namespace": "default"},
+		},
+		{
+			name:    "created shared bucket",
+			prefix:  "vol",
+			created: true,
+			params:  withTags,
+			want:    map[string]string{"team": "data"},
+		},
+		{
+			name:   "existing shared bucket",
+			prefix: "vol",
+			params: withTags,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := bucketTags(tt.params)
+			if err != nil {
+				t.Fatalf("bucketTags() error = %v", err)
+			}
+			client := &fakeTagger{tags: make(map[string]map[string]string)}
+			tagBucket(client, "bucket", tt.prefix, tt.created, tags, tt.params)
+			if got := client.tags["bucket"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Failures don't fail the provisioning
+	tagBucket(&fakeTagger{err: errors.New("AccessDenied")}, "bucket", "", true, nil, pvc)
+}
+
+func TestBucketTags(t *testing.T) {
+	for _, value := range []string{"team", "=data", "team=" + strings.Repeat("x", 257)} {
+		if _, err := bucketTags(map[string]string{bucketTagsKey: value}); err == nil {
+			t.Errorf("bucketTags(%q) succeeded", value)
+		}
+	}
+}
+
+type fakeVolumes struct {
+	meta  *s3.FSMeta
+	usage int64
+}
+
+func (f *fakeVolumes) VolumeExists(bucketName, prefix string) (bool, *s3.FSMeta, error) {
+	return f.meta != nil, f.meta, nil
+}
+
+func (f *fakeVolumes) GetBucketUsage(bucketName, prefix string) (int64, error) {
+	return f.usage, nil
+}
+
+func TestCheckExistingVolume(t *testing.T) {
+	geesefs := map[string]string{mounter.TypeKey: "geesefs"}
+	existing := &s3.FSMeta{CapacityBytes: 10, Mounter: "geesefs"}
+	tests := []struct {
+		name     string
+		client   *fakeVolumes
+		capacity int64
+		params   map[string]string
+		want     int64
+		exists   bool
+		code     codes.Code
+	}{
+		{name: "new volume", client: &fakeVolumes{usage: 5}, capacity: 10, params: geesefs, want: 10},
+		{name: "capacity from usage", client: &fakeVolumes{usage: 5}, params: geesefs, want: 5},
+		{name: "identical volume", client: &fakeVolumes{meta: existing}, capacity: 10, params: geesefs, want: 10, exists: true},
+		{name: "existing capacity", client: &fakeVolumes{meta: existing}, params: geesefs, want: 10, exists: true},
+		{name: "other capacity", client: &fakeVolumes{meta: existing}, capacity: 20, params: geesefs, code: codes.AlreadyExists},
+		{name: "other mounter", client: &fakeVolumes{meta: existing}, capacity: 10, params: map[string]string{mounter.TypeKey: "rclone"}, code: codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capacity, exists, err := checkExistingVolume(tt.client, "bucket", "vol", tt.capacity, tt.params)
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("checkExistingVolume() error = %v, want code %v", err, tt.code)
+			}
+			if capacity != tt.want || exists != tt.exists {
+				t.Errorf("checkExistingVolume() = %d, %v, want %d, %v", capacity, exists, tt.want, tt.exists)
+			}
+		})
+	}
+}
+
+func TestProvisionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{status.Error(codes.PermissionDenied, "not writable"), codes.PermissionDenied},
+		{fmt.Errorf("failed to create bucket b: %w", s3.ErrBucketOwnedByOther), codes.AlreadyExists},
+		{fmt.Errorf("failed to create prefix p: %w", s3.ErrPrefixNotEmpty), codes.AlreadyExists},
+		{fmt.Errorf("failed to create bucket b: %w", s3.ErrBucketNotAllowed), codes.PermissionDenied},
+		{fmt.Errorf("failed to create bucket b: %w", s3.ErrInvalidBucketName), codes.InvalidArgument},
+		{fmt.Errorf("%w: b", s3.ErrBucketNotFound), codes.NotFound},
+		{errors.New("connection reset"), codes.Unknown},
+	}
+	for _, tt := range tests {
+		if got := status.Code(provisionError(tt.err, "b/p")); got != tt.code {
+			t.Errorf("provisionError(%v) code = %v, want %v", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestDeleteVolumeWithoutCredentials(t *testing.T) {
+	d := csicommon.NewCSIDriver("test", "test", "node")
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	cs := &controllerServer{DefaultControllerServer: csicommon.NewDefaultControllerServer(d)}
+
+	// Nothing is sent to the endpoint either way
+	secret := map[string]string{"endpoint": "http://127.0.0.1:1"}
+	req := &csi.DeleteVolumeRequest{VolumeId: "bucket/vol", Secrets: secret}
+	if _, err := cs.DeleteVolume(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteVolume() without credentials error = %v, want FailedPrecondition", err)
+	}
+	secret["anonymous"] = "true"
+	if _, err := cs.DeleteVolume(context.Background(), req); err != nil {
+		t.Errorf("DeleteVolume() of anonymous volume error = %v", err)
+	}
+}
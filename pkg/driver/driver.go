@@ -17,10 +17,18 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
 
 	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
 
 type driver struct {
@@ -35,10 +43,22 @@ type driver struct {
 var (
 	vendorVersion = "v1.34.7"
 	driverName    = "ru.yandex.s3.csi"
+	// appName identifies the driver in the User-Agent of S3 requests
+	appName = "k8s-csi-s3"
+	// shutdownTimeout bounds the wait for S3 operations in flight when the
+	// driver is terminated
+	shutdownTimeout = 25 * time.Second
 )
 
+// SetShutdownTimeout sets how long the driver waits for S3 operations in
+// flight, like volume removals, when it is terminated
+func SetShutdownTimeout(timeout time.Duration) {
+	shutdownTimeout = timeout
+}
+
 // New initializes the driver
 func New(nodeID string, endpoint string) (*driver, error) {
+	s3.SetAppInfo(appName, vendorVersion)
 	d := csicommon.NewCSIDriver(driverName, vendorVersion, nodeID)
 	if d == nil {
 		glog.Fatalln("Failed to initialize CSI Driver.")
@@ -64,6 +84,11 @@ func (s3 *driver) newControllerServer(d *csicommon.CSIDriver) *controllerServer
 }
 
 func (s3 *driver) newNodeServer(d *csicommon.CSIDriver) *nodeServer {
+	for _, mounterType := range mounter.Types {
+		if err := mounter.CheckBinary(mounterType); err != nil {
+			glog.Warning(err)
+		}
+	}
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d),
 	}
@@ -84,5 +109,25 @@ func (s3 *driver) Run() {
 
 	s := csicommon.NewNonBlockingGRPCServer()
 	s.Start(s3.endpoint, s3.ids, s3.cs, s3.ns)
+	go stopOnSignal(s)
 	s.Wait()
 }
+
+// stopOnSignal stops the server when the driver is terminated, after the S3
+// operations in flight have finished or, after shutdownTimeout, have been
+// cancelled. Cancelled removals keep their checkpoint, so that DeleteVolume
+// resumes them once the driver is back.
+func stopOnSignal(server csicommon.NonBlockingGRPCServer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	glog.Infof("Received %v, waiting up to %v for S3 operations in flight", sig, shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s3.Shutdown(ctx); err != nil {
+		glog.Warningf("Cancelled S3 operations still in flight: %v", err)
+	}
+	// Lets the handlers of the cancelled operations return their errors
+	server.Stop()
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -56,12 +57,19 @@ func getMeta(bucketName, prefix string, context map[string]string) *s3.FSMeta {
 		}
 	}
 	capacity, _ := strconv.ParseInt(context["capacity"], 10, 64)
+	// Validated in CreateVolume
+	uploadLimit, _ := s3.ParseBandwidthLimit(context[mounter.UploadLimitKey])
+	downloadLimit, _ := s3.ParseBandwidthLimit(context[mounter.DownloadLimitKey])
+	fallback, _ := s3.ParseMounters(context[mounter.FallbackKey])
 	return &s3.FSMeta{
-		BucketName:    bucketName,
-		Prefix:        prefix,
-		Mounter:       context[mounter.TypeKey],
-		MountOptions:  mountOptions,
-		CapacityBytes: capacity,
+		BucketName:             bucketName,
+		Prefix:                 prefix,
+		Mounter:                context[mounter.TypeKey],
+		MountOptions:           mountOptions,
+		CapacityBytes:          capacity,
+		UploadBandwidthLimit:   uploadLimit,
+		DownloadBandwidthLimit: downloadLimit,
+		MounterFallback:        fallback,
 	}
 }
 
@@ -90,17 +98,23 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 	if notMnt {
 		// Staged mount is dead by some reason. Revive it
-		bucketName, prefix := volumeIDToBucketPrefix(volumeID)
-		s3, err := s3.NewClientFromSecret(req.GetSecrets())
+		bucketName, prefix, err := s3.ParseVolumeID(volumeID)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s3, err := s3.NewClientFromSecret(s3.WithStorageClass(req.GetSecrets(), req.GetVolumeContext()))
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 		}
+		if err := checkMounterCredentials(s3.Config); err != nil {
+			return nil, err
+		}
 		meta := getMeta(bucketName, prefix, req.VolumeContext)
-		mounter, err := mounter.New(meta, s3.Config)
+		types, err := mounter.Available(mounter.Candidates(meta, s3.Config))
 		if err != nil {
-			return nil, err
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
 		}
-		if err := mounter.Mount(stagingTargetPath, volumeID); err != nil {
+		if _, err := mounter.MountFirst(meta, s3.Config, types, stagingTargetPath, volumeID); err != nil {
 			return nil, err
 		}
 	}
@@ -157,12 +171,15 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	stagingTargetPath := req.GetStagingTargetPath()
-	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
 
 	// Check arguments
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
+	bucketName, prefix, err := s3.ParseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	if len(stagingTargetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
@@ -179,19 +196,48 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if !notMnt {
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
+	client, err := s3.NewClientFromSecret(s3.WithStorageClass(req.GetSecrets(), req.GetVolumeContext()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
+	if err = checkMounterCredentials(client.Config); err != nil {
+		return nil, err
+	}
 
+	client.Config.ObjectStorageClass = req.VolumeContext[s3StorageClassKey]
 	meta := getMeta(bucketName, prefix, req.VolumeContext)
-	mounter, err := mounter.New(meta, client.Config)
+	types, err := mounter.Available(mounter.Candidates(meta, client.Config))
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if req.VolumeContext[enforceCapacityKey] == "true" {
+		if err = checkCapacity(client, bucketName, prefix, meta.CapacityBytes); err != nil {
+			return nil, err
+		}
+	}
+	// The mounters can't read archived objects until they are restored
+	if restoreDays, _ := strconv.Atoi(req.VolumeContext[restoreDaysKey]); restoreDays > 0 {
+		if err = client.RestoreObjects(bucketName, prefix, restoreDays); err != nil {
+			if errors.Is(err, s3.ErrObjectArchived) {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+			return nil, fmt.Errorf("failed to restore archived objects of volume %s: %v", volumeID, err)
+		}
+	}
+	// Best effort, the mounter lists the volume anyway
+	if maxObjects, _ := strconv.Atoi(req.VolumeContext[warmupMaxObjectsKey]); maxObjects > 0 {
+		if err = client.Warmup(bucketName, prefix, maxObjects); err != nil {
+			glog.Warningf("Failed to warm up volume %s: %v", volumeID, err)
+		}
 	}
-	if err := mounter.Mount(stagingTargetPath, volumeID); err != nil {
+	mounterType, err := mounter.MountFirst(meta, client.Config, types, stagingTargetPath, volumeID)
+	if err != nil {
 		return nil, err
 	}
+	// Best effort, the node's credentials may be read-only
+	if err = client.SetMountedWith(bucketName, prefix, mounterType); err != nil {
+		glog.Warningf("Failed to record mounter %s of volume %s: %v", mounterType, volumeID, err)
+	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -223,6 +269,9 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		err = mounter.FuseUnmount(stagingTargetPath)
 	}
 	glog.V(4).Infof("s3: volume %s has been unmounted from stage path %v.", volumeID, stagingTargetPath)
+	if err := mounter.Cleanup(volumeID); err != nil {
+		glog.Warningf("Failed to clean up the mounter files of volume %s: %v", volumeID, err)
+	}
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -249,6 +298,17 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	return &csi.NodeExpandVolumeResponse{}, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
 }
 
+// checkMounterCredentials refuses to mount with a secret that assumes a role.
+// The mounters only get keys, and would use the base keys instead of the
+// role's, or none at all with a web identity token.
+func checkMounterCredentials(cfg *s3.Config) error {
+	if cfg.RoleARN == "" {
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, "the mounters can't assume roles, roleArn is only supported in the secrets of the controller: "+
+		"set csi.storage.k8s.io/node-stage-secret-name and node-publish-secret-name to a secret with keys of its own")
+}
+
 func checkMount(targetPath string) (bool, error) {
 	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
 	if err != nil {
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageVolumeRole(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secrets := []map[string]string{
+		{"endpoint": "http://127.0.0.1:1", "accessKeyID": "key", "secretAccessKey": "secret", "roleArn": "arn:aws:iam::1:role/csi"},
+		{"endpoint": "http://127.0.0.1:1", "roleArn": "arn:aws:iam::1:role/csi", "tokenFile": tokenFile},
+	}
+	ns := &nodeServer{}
+	for _, secret := range secrets {
+		_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "bucket",
+			StagingTargetPath: filepath.Join(dir, "staging"),
+			VolumeCapability:  &csi.VolumeCapability{},
+			Secrets:           secret,
+		})
+		// The mounters may as well be missing on the test machine
+		if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "roleArn") {
+			t.Errorf("NodeStageVolume() with roleArn and keys %v error = %v, want FailedPrecondition", secret["accessKeyID"] != "", err)
+		}
+	}
+}
@@ -24,15 +24,25 @@ type geesefsMounter struct {
 	region          string
 	accessKeyID     string
 	secretAccessKey string
+	anonymous       bool
+	acl             string
+	storageClass    string
+	partSize        uint64
+	concurrency     uint
 }
 
 func newGeeseFSMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &geesefsMounter{
 		meta:            meta,
 		endpoint:        cfg.Endpoint,
-		region:          cfg.Region,
+		region:          cfg.SigningRegion,
 		accessKeyID:     cfg.AccessKeyID,
 		secretAccessKey: cfg.SecretAccessKey,
+		anonymous:       cfg.Anonymous,
+		acl:             cfg.CannedACL,
+		storageClass:    cfg.ObjectStorageClass,
+		partSize:        cfg.PartSize,
+		concurrency:     cfg.UploadConcurrency,
 	}, nil
 }
 
@@ -99,6 +109,21 @@ func (geesefs *geesefsMounter) Mount(target, volumeID string) error {
 		"--setuid", "65534", // nobody. drop root privileges
 		"--setgid", "65534", // nogroup
 	)
+	if geesefs.anonymous {
+		args = append(args, "-o", "ro")
+	}
+	if geesefs.acl != "" {
+		args = append(args, "--acl", geesefs.acl)
+	}
+	if geesefs.storageClass != "" {
+		args = append(args, "--storage-class", geesefs.storageClass)
+	}
+	if geesefs.partSize > 0 {
+		args = append(args, "--part-sizes", fmt.Sprint(geesefs.partSize>>20))
+	}
+	if geesefs.concurrency > 0 {
+		args = append(args, "--max-parallel-parts", fmt.Sprint(geesefs.concurrency))
+	}
 	useSystemd := true
 	for i := 0; i < len(geesefs.meta.MountOptions); i++ {
 		opt := geesefs.meta.MountOptions[i]
@@ -30,8 +30,11 @@ const (
 	geesefsMounterType  = "geesefs"
 	rcloneMounterType   = "rclone"
 	TypeKey             = "mounter"
+	FallbackKey         = "mounterFallback"
 	BucketKey           = "bucket"
 	OptionsKey          = "options"
+	UploadLimitKey      = "uploadBandwidthLimit"
+	DownloadLimitKey    = "downloadBandwidthLimit"
 )
 
 // New returns a new mounter depending on the mounterType parameter
@@ -41,6 +44,9 @@ func New(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	if len(meta.Mounter) == 0 {
 		mounter = cfg.Mounter
 	}
+	if (meta.UploadBandwidthLimit > 0 || meta.DownloadBandwidthLimit > 0) && mounter != rcloneMounterType {
+		glog.Warningf("Only rclone supports bandwidth limits, ignoring those of volume %s/%s", meta.BucketName, meta.Prefix)
+	}
 	switch mounter {
 	case geesefsMounterType:
 		return newGeeseFSMounter(meta, cfg)
@@ -57,6 +63,107 @@ func New(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	}
 }
 
+// Types are the names of the available mounters
+var Types = []string{geesefsMounterType, s3fsMounterType, rcloneMounterType}
+
+// binaries are the executables the mounters run
+var binaries = map[string]string{
+	geesefsMounterType: geesefsCmd,
+	s3fsMounterType:    s3fsCmd,
+	rcloneMounterType:  rcloneCmd,
+}
+
+// CheckBinary returns an error if the executable of a mounter is not in
+// PATH, so that a node image missing it fails with a clear message instead
+// of at mount time. Like in New, other types stand for the default, GeeseFS.
+func CheckBinary(mounterType string) error {
+	if _, ok := binaries[mounterType]; !ok {
+		mounterType = geesefsMounterType
+	}
+	binary := binaries[mounterType]
+	if _, err := exec.LookPath(binary); err != nil {
+		return fmt.Errorf("mounter %s is not available on this node: %s not found in PATH (%s), "+
+			"install it in the node image or use another mounter", mounterType, binary, os.Getenv("PATH"))
+	}
+	return nil
+}
+
+// Candidates returns the mounters to try for a volume in order: its mounter,
+// or the one of cfg, then its fallbacks. Like in New, other types stand for
+// the default, GeeseFS.
+func Candidates(meta *s3.FSMeta, cfg *s3.Config) []string {
+	preferred := meta.Mounter
+	if preferred == "" {
+		preferred = cfg.Mounter
+	}
+	var types []string
+	seen := make(map[string]bool)
+	for _, mounterType := range append([]string{preferred}, meta.MounterFallback...) {
+		if _, ok := binaries[mounterType]; !ok {
+			mounterType = geesefsMounterType
+		}
+		if !seen[mounterType] {
+			seen[mounterType] = true
+			types = append(types, mounterType)
+		}
+	}
+	return types
+}
+
+// Available returns the mounters of types installed on the node, see
+// CheckBinary. If there are none, it returns the error of the first one.
+func Available(types []string) ([]string, error) {
+	var available []string
+	var firstErr error
+	for _, mounterType := range types {
+		if err := CheckBinary(mounterType); err != nil {
+			glog.Warning(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		available = append(available, mounterType)
+	}
+	if len(available) == 0 {
+		return nil, firstErr
+	}
+	return available, nil
+}
+
+// MountFirst mounts a volume at target with the first of types that
+// succeeds and returns its type. The error of every mounter that failed is
+// returned if none succeeds.
+func MountFirst(meta *s3.FSMeta, cfg *s3.Config, types []string, target, volumeID string) (string, error) {
+	var errs []string
+	for _, mounterType := range types {
+		m := *meta
+		m.Mounter = mounterType
+		mounter, err := New(&m, cfg)
+		if err == nil {
+			err = mounter.Mount(target, volumeID)
+		}
+		if err == nil {
+			if mounterType != types[0] {
+				glog.Warningf("Mounted volume %s with fallback mounter %s", volumeID, mounterType)
+			}
+			return mounterType, nil
+		}
+		glog.Warningf("Mounter %s failed to mount volume %s: %v", mounterType, volumeID, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", mounterType, err))
+	}
+	if len(errs) == 1 {
+		return "", fmt.Errorf("failed to mount volume %s with %s", volumeID, errs[0])
+	}
+	return "", fmt.Errorf("failed to mount volume %s with all %d mounters: %s", volumeID, len(errs), strings.Join(errs, "; "))
+}
+
+// Cleanup removes the files written by the mounters for a volume, e.g. the
+// rclone.conf holding its credentials, once it is unmounted
+func Cleanup(volumeID string) error {
+	return removeRcloneConfig(volumeID)
+}
+
 func fuseMount(path string, command string, args []string, envs []string) error {
 	cmd := exec.Command(command, args...)
 	cmd.Stderr = os.Stderr
@@ -0,0 +1,69 @@
+package mounter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+func TestCheckBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, s3fsCmd), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	if err := CheckBinary(s3fsMounterType); err != nil {
+		t.Errorf("CheckBinary(s3fs) error = %v", err)
+	}
+	if err := CheckBinary(rcloneMounterType); err == nil {
+		t.Error("CheckBinary(rclone) error = nil, want missing binary")
+	}
+	// Like New, an empty mounter means GeeseFS
+	if err := CheckBinary(""); err == nil {
+		t.Error("CheckBinary(\"\") error = nil, want missing geesefs")
+	}
+}
+
+func TestMounterFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, rcloneCmd), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	meta := &s3.FSMeta{MounterFallback: []string{"s3fs", "geesefs", "rclone"}}
+	cfg := &s3.Config{Mounter: "geesefs"}
+	types := Candidates(meta, cfg)
+	if want := []string{"geesefs", "s3fs", "rclone"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("Candidates() = %v, want %v", types, want)
+	}
+	available, err := Available(types)
+	if err != nil {
+		t.Fatalf("Available() error = %v", err)
+	}
+	if want := []string{"rclone"}; !reflect.DeepEqual(available, want) {
+		t.Errorf("Available() = %v, want %v", available, want)
+	}
+
+	// Without fallbacks, the missing preferred mounter is reported
+	meta = &s3.FSMeta{Mounter: "s3fs"}
+	if _, err = Available(Candidates(meta, cfg)); err == nil || !strings.Contains(err.Error(), "mounter s3fs") {
+		t.Errorf("Available() error = %v, want missing s3fs", err)
+	}
+}
@@ -2,53 +2,176 @@ package mounter
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
 
 // Implements Mounter
 type rcloneMounter struct {
-	meta            *s3.FSMeta
-	url             string
-	region          string
-	accessKeyID     string
-	secretAccessKey string
+	meta *s3.FSMeta
+	cfg  *s3.Config
 }
 
 const (
-	rcloneCmd = "rclone"
+	rcloneCmd    = "rclone"
+	rcloneRemote = "s3"
 )
 
+// rcloneConfigDir holds the rclone.conf of the volumes staged on the node.
+// It is only accessible by root, as the files contain the credentials.
+var rcloneConfigDir = filepath.Join(os.TempDir(), "csi-s3-rclone")
+
 func newRcloneMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &rcloneMounter{
-		meta:            meta,
-		url:             cfg.Endpoint,
-		region:          cfg.Region,
-		accessKeyID:     cfg.AccessKeyID,
-		secretAccessKey: cfg.SecretAccessKey,
+		meta: meta,
+		cfg:  cfg,
 	}, nil
 }
 
 func (rclone *rcloneMounter) Mount(target, volumeID string) error {
+	configFile, err := writeRcloneConfig(volumeID, rclone.cfg)
+	if err != nil {
+		return err
+	}
 	args := []string{
 		"mount",
-		fmt.Sprintf(":s3:%s", path.Join(rclone.meta.BucketName, rclone.meta.Prefix)),
+		fmt.Sprintf("%s:%s", rcloneRemote, path.Join(rclone.meta.BucketName, rclone.meta.Prefix)),
 		fmt.Sprintf("%s", target),
+		"--config", configFile,
 		"--daemon",
-		"--s3-provider=AWS",
-		"--s3-env-auth=true",
-		fmt.Sprintf("--s3-endpoint=%s", rclone.url),
 		"--allow-other",
 		"--vfs-cache-mode=writes",
 	}
-	if rclone.region != "" {
-		args = append(args, fmt.Sprintf("--s3-region=%s", rclone.region))
+	if rclone.cfg.Anonymous {
+		args = append(args, "--read-only")
+	}
+	if limit := rcloneBandwidthLimit(rclone.meta); limit != "" {
+		args = append(args, "--bwlimit", limit)
 	}
 	args = append(args, rclone.meta.MountOptions...)
-	envs := []string{
-		"AWS_ACCESS_KEY_ID=" + rclone.accessKeyID,
-		"AWS_SECRET_ACCESS_KEY=" + rclone.secretAccessKey,
+	if err = fuseMount(target, rcloneCmd, args, nil); err != nil {
+		removeRcloneConfig(volumeID)
+		return err
+	}
+	return nil
+}
+
+// rcloneConfigFile returns the path of the rclone.conf of a volume
+func rcloneConfigFile(volumeID string) string {
+	return filepath.Join(rcloneConfigDir, strings.ReplaceAll(volumeID, "/", "-")+".conf")
+}
+
+// writeRcloneConfig writes the rclone.conf of a volume into rcloneConfigDir,
+// which is created if needed. An existing rcloneConfigDir which isn't a
+// directory owned by root, e.g. a symlink planted in the shared temporary
+// directory, is refused.
+func writeRcloneConfig(volumeID string, cfg *s3.Config) (string, error) {
+	if err := os.MkdirAll(rcloneConfigDir, 0700); err != nil {
+		return "", fmt.Errorf("Error creating rclone config directory %s: %v", rcloneConfigDir, err)
+	}
+	info, err := os.Lstat(rcloneConfigDir)
+	if err != nil {
+		return "", err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() {
+		return "", fmt.Errorf("rclone config directory %s is not a directory owned by the driver", rcloneConfigDir)
+	}
+	if err = os.Chmod(rcloneConfigDir, 0700); err != nil {
+		return "", err
+	}
+	configFile := rcloneConfigFile(volumeID)
+	if err = ioutil.WriteFile(configFile, []byte(rcloneConfig(rcloneRemote, cfg)), 0600); err != nil {
+		return "", fmt.Errorf("Error writing rclone config %s: %v", configFile, err)
+	}
+	return configFile, nil
+}
+
+// removeRcloneConfig removes the rclone.conf of a volume, if any
+func removeRcloneConfig(volumeID string) error {
+	if err := os.Remove(rcloneConfigFile(volumeID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rcloneConfig generates an rclone.conf section defining an s3 remote with
+// the endpoint and credentials of cfg
+func rcloneConfig(remote string, cfg *s3.Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", remote)
+	fmt.Fprintf(&b, "type = s3\n")
+	fmt.Fprintf(&b, "provider = %s\n", rcloneProvider(cfg.Endpoint))
+	// env_auth takes the credentials of the node, e.g. of its instance role
+	fmt.Fprintf(&b, "env_auth = %v\n", cfg.UseIAM)
+	if !cfg.Anonymous && !cfg.UseIAM {
+		fmt.Fprintf(&b, "access_key_id = %s\n", cfg.AccessKeyID)
+		fmt.Fprintf(&b, "secret_access_key = %s\n", cfg.SecretAccessKey)
+	}
+	fmt.Fprintf(&b, "endpoint = %s\n", cfg.Endpoint)
+	if cfg.SigningRegion != "" {
+		fmt.Fprintf(&b, "region = %s\n", cfg.SigningRegion)
+	}
+	if cfg.ListObjectsV1 {
+		fmt.Fprintf(&b, "list_version = 1\n")
+	}
+	if cfg.ListMaxKeys > 0 {
+		fmt.Fprintf(&b, "list_chunk = %d\n", cfg.ListMaxKeys)
+	}
+	if cfg.CannedACL != "" {
+		fmt.Fprintf(&b, "acl = %s\n", cfg.CannedACL)
+	}
+	if cfg.RequesterPays {
+		fmt.Fprintf(&b, "requester_pays = true\n")
+	}
+	if cfg.ObjectStorageClass != "" {
+		fmt.Fprintf(&b, "storage_class = %s\n", cfg.ObjectStorageClass)
+	}
+	if cfg.PartSize > 0 {
+		fmt.Fprintf(&b, "chunk_size = %dM\n", cfg.PartSize>>20)
+	}
+	if cfg.UploadConcurrency > 0 {
+		fmt.Fprintf(&b, "upload_concurrency = %d\n", cfg.UploadConcurrency)
+	}
+	return b.String()
+}
+
+// rcloneBandwidthLimit returns the --bwlimit of a volume, as upload:download
+// in bytes per second, or "" if it has no limits
+func rcloneBandwidthLimit(meta *s3.FSMeta) string {
+	if meta.UploadBandwidthLimit == 0 && meta.DownloadBandwidthLimit == 0 {
+		return ""
+	}
+	limit := func(l int64) string {
+		if l == 0 {
+			return "off"
+		}
+		return fmt.Sprintf("%dB", l)
+	}
+	return limit(meta.UploadBandwidthLimit) + ":" + limit(meta.DownloadBandwidthLimit)
+}
+
+// rcloneProvider guesses the rclone s3 provider from the endpoint host
+func rcloneProvider(endpoint string) string {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	host = strings.ToLower(host)
+	switch {
+	case strings.HasSuffix(host, ".amazonaws.com"):
+		return "AWS"
+	case strings.Contains(host, "minio"):
+		return "Minio"
+	case strings.Contains(host, "ceph") || strings.Contains(host, "rgw"):
+		return "Ceph"
+	default:
+		return "Other"
 	}
-	return fuseMount(target, rcloneCmd, args, envs)
 }
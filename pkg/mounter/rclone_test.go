@@ -0,0 +1,171 @@
+package mounter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+func TestRcloneConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *s3.Config
+		want string
+	}{
+		{
+			name: "aws",
+			cfg: &s3.Config{
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				Region:          "eu-central-1",
+				SigningRegion:   "eu-central-1",
+				Endpoint:        "https://s3.eu-central-1.amazonaws.com",
+			},
+			want: "[s3]\ntype = s3\nprovider = AWS\nenv_auth = false\n" +
+				"access_key_id = key\nsecret_access_key = secret\n" +
+				"endpoint = https://s3.eu-central-1.amazonaws.com\nregion = eu-central-1\n",
+		},
+		{
+			name: "minio without region",
+			cfg: &s3.Config{
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				Endpoint:        "http://minio.local:9000",
+			},
+			want: "[s3]\ntype = s3\nprovider = Minio\nenv_auth = false\n" +
+				"access_key_id = key\nsecret_access_key = secret\n" +
+				"endpoint = http://minio.local:9000\n",
+		},
+		{
+			name: "requester pays",
+			cfg: &s3.Config{
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				Endpoint:        "https://s3.amazonaws.com",
+				RequesterPays:   true,
+			},
+			want: "[s3]\ntype = s3\nprovider = AWS\nenv_auth = false\n" +
+				"access_key_id = key\nsecret_access_key = secret\n" +
+				"endpoint = https://s3.amazonaws.com\nrequester_pays = true\n",
+		},
+		{
+			name: "multipart",
+			cfg: &s3.Config{
+				Endpoint:          "http://minio.local:9000",
+				Anonymous:         true,
+				PartSize:          16 << 20,
+				UploadConcurrency: 8,
+			},
+			want: "[s3]\ntype = s3\nprovider = Minio\nenv_auth = false\n" +
+				"endpoint = http://minio.local:9000\nchunk_size = 16M\nupload_concurrency = 8\n",
+		},
+		{
+			name: "iam",
+			cfg: &s3.Config{
+				Endpoint: "https://s3.eu-west-1.amazonaws.com",
+				UseIAM:   true,
+			},
+			want: "[s3]\ntype = s3\nprovider = AWS\nenv_auth = true\n" +
+				"endpoint = https://s3.eu-west-1.amazonaws.com\n",
+		},
+		{
+			name: "anonymous",
+			cfg: &s3.Config{
+				Endpoint:  "https://storage.yandexcloud.net",
+				Anonymous: true,
+			},
+			want: "[s3]\ntype = s3\nprovider = Other\nenv_auth = false\n" +
+				"endpoint = https://storage.yandexcloud.net\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rcloneConfig("s3", tt.cfg); got != tt.want {
+				t.Errorf("rcloneConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRcloneProvider(t *testing.T) {
+	tests := map[string]string{
+		"https://s3.amazonaws.com":           "AWS",
+		"https://s3.us-west-2.amazonaws.com": "AWS",
+		"http://minio:9000":                  "Minio",
+		"https://rgw.example.com":            "Ceph",
+		"https://ceph-gw.example.com:7480":   "Ceph",
+		"https://storage.yandexcloud.net":    "Other",
+		"s3.example.com:9000":                "Other",
+	}
+	for endpoint, want := range tests {
+		if got := rcloneProvider(endpoint); got != want {
+			t.Errorf("rcloneProvider(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+func TestRcloneBandwidthLimit(t *testing.T) {
+	tests := []struct {
+		meta s3.FSMeta
+		want string
+	}{
+		{meta: s3.FSMeta{}, want: ""},
+		{meta: s3.FSMeta{UploadBandwidthLimit: 1048576}, want: "1048576B:off"},
+		{meta: s3.FSMeta{UploadBandwidthLimit: 1000, DownloadBandwidthLimit: 2000}, want: "1000B:2000B"},
+	}
+	for _, tt := range tests {
+		if got := rcloneBandwidthLimit(&tt.meta); got != tt.want {
+			t.Errorf("rcloneBandwidthLimit(%+v) = %q, want %q", tt.meta, got, tt.want)
+		}
+	}
+}
+
+func TestRcloneConfigCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { rcloneConfigDir = old }(rcloneConfigDir)
+	rcloneConfigDir = filepath.Join(dir, "rclone")
+
+	cfg := &s3.Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: "http://minio:9000"}
+	configFile, err := writeRcloneConfig("bucket/prefix", cfg)
+	if err != nil {
+		t.Fatalf("writeRcloneConfig() error = %v", err)
+	}
+	if filepath.Dir(configFile) != rcloneConfigDir {
+		t.Errorf("config file %s is not in %s", configFile, rcloneConfigDir)
+	}
+	for path, want := range map[string]os.FileMode{rcloneConfigDir: 0700, configFile: 0600} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+
+	if err = Cleanup("bucket/prefix"); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if _, err = os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("config file after Cleanup() error = %v, want it removed", err)
+	}
+	if err = Cleanup("bucket/prefix"); err != nil {
+		t.Errorf("Cleanup() of cleaned up volume error = %v", err)
+	}
+
+	// A symlink planted in place of the directory is refused
+	os.RemoveAll(rcloneConfigDir)
+	if err = os.Symlink(dir, rcloneConfigDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writeRcloneConfig("bucket/prefix", cfg); err == nil {
+		t.Error("writeRcloneConfig() into a symlink succeeded")
+	}
+}
@@ -3,6 +3,7 @@ package mounter
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
@@ -13,6 +14,13 @@ type s3fsMounter struct {
 	url           string
 	region        string
 	pwFileContent string
+	anonymous     bool
+	useIAM        bool
+	requesterPays bool
+	acl           string
+	storageClass  string
+	partSize      uint64
+	concurrency   uint
 }
 
 const (
@@ -23,14 +31,23 @@ func newS3fsMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &s3fsMounter{
 		meta:          meta,
 		url:           cfg.Endpoint,
-		region:        cfg.Region,
+		region:        cfg.SigningRegion,
 		pwFileContent: cfg.AccessKeyID + ":" + cfg.SecretAccessKey,
+		anonymous:     cfg.Anonymous,
+		useIAM:        cfg.UseIAM,
+		requesterPays: cfg.RequesterPays,
+		acl:           cfg.CannedACL,
+		storageClass:  cfg.ObjectStorageClass,
+		partSize:      cfg.PartSize,
+		concurrency:   cfg.UploadConcurrency,
 	}, nil
 }
 
 func (s3fs *s3fsMounter) Mount(target, volumeID string) error {
-	if err := writes3fsPass(s3fs.pwFileContent); err != nil {
-		return err
+	if !s3fs.anonymous && !s3fs.useIAM {
+		if err := writes3fsPass(s3fs.pwFileContent); err != nil {
+			return err
+		}
 	}
 	args := []string{
 		fmt.Sprintf("%s:/%s", s3fs.meta.BucketName, s3fs.meta.Prefix),
@@ -43,6 +60,27 @@ func (s3fs *s3fsMounter) Mount(target, volumeID string) error {
 	if s3fs.region != "" {
 		args = append(args, "-o", fmt.Sprintf("endpoint=%s", s3fs.region))
 	}
+	if s3fs.anonymous {
+		args = append(args, "-o", "public_bucket=1", "-o", "ro")
+	}
+	if s3fs.useIAM {
+		args = append(args, "-o", "iam_role=auto")
+	}
+	if s3fs.requesterPays {
+		args = append(args, "-o", "requester_pays")
+	}
+	if s3fs.acl != "" {
+		args = append(args, "-o", fmt.Sprintf("default_acl=%s", s3fs.acl))
+	}
+	if s3fs.storageClass != "" {
+		args = append(args, "-o", fmt.Sprintf("storage_class=%s", strings.ToLower(s3fs.storageClass)))
+	}
+	if s3fs.partSize > 0 {
+		args = append(args, "-o", fmt.Sprintf("multipart_size=%d", s3fs.partSize>>20))
+	}
+	if s3fs.concurrency > 0 {
+		args = append(args, "-o", fmt.Sprintf("parallel_count=%d", s3fs.concurrency))
+	}
 	args = append(args, s3fs.meta.MountOptions...)
 	return fuseMount(target, s3fsCmd, args, nil)
 }
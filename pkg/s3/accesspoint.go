@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// accessPointAliasSuffix ends the aliases AWS assigns to access points,
+	// which can be used wherever a bucket name is expected
+	accessPointAliasSuffix = "-s3alias"
+)
+
+var (
+	accessPointAccount = regexp.MustCompile(`^[0-9]{12}$`)
+	accessPointName    = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+)
+
+// AccessPoint is an S3 access point, as named by its ARN, e.g.
+// "arn:aws:s3:us-west-2:123456789012:accesspoint/data"
+type AccessPoint struct {
+	Partition string
+	Region    string
+	Account   string
+	Name      string
+}
+
+// IsAccessPointARN reports whether a bucket name is actually an ARN, which
+// is how access points are usually referred to
+func IsAccessPointARN(bucketName string) bool {
+	return strings.HasPrefix(bucketName, "arn:")
+}
+
+// IsAccessPointAlias reports whether the bucket is the alias of an access
+// point, judging by its name
+func IsAccessPointAlias(bucketName string) bool {
+	return strings.HasSuffix(bucketName, accessPointAliasSuffix)
+}
+
+// ParseAccessPointARN parses and validates the ARN of an access point
+func ParseAccessPointARN(arn string) (*AccessPoint, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[1] == "" || parts[2] != "s3" {
+		return nil, fmt.Errorf("%w: %q is not an S3 ARN", ErrInvalidConfig, arn)
+	}
+	resource := strings.SplitN(parts[5], "/", 2)
+	if len(resource) != 2 || resource[0] != "accesspoint" {
+		return nil, fmt.Errorf("%w: %q is not an access point ARN", ErrInvalidConfig, arn)
+	}
+	ap := &AccessPoint{Partition: parts[1], Region: parts[3], Account: parts[4], Name: resource[1]}
+	if ap.Region == "" {
+		return nil, fmt.Errorf("%w: access point ARN %q has no region", ErrInvalidConfig, arn)
+	}
+	if !accessPointAccount.MatchString(ap.Account) {
+		return nil, fmt.Errorf("%w: access point ARN %q has an invalid account ID", ErrInvalidConfig, arn)
+	}
+	if !accessPointName.MatchString(ap.Name) {
+		return nil, fmt.Errorf("%w: access point ARN %q has an invalid name, names are 3 to 50 lowercase letters, digits and hyphens", ErrInvalidConfig, arn)
+	}
+	return ap, nil
+}
+
+// CheckAccessPointBucket rejects access point ARNs given as bucket names.
+// Neither the S3 client of the driver nor the mounters can address access
+// points by ARN, but all of them accept the alias of an access point as a
+// bucket name, so the error tells which alias and region to use instead.
+func CheckAccessPointBucket(bucketName string) error {
+	if !IsAccessPointARN(bucketName) {
+		return nil
+	}
+	ap, err := ParseAccessPointARN(bucketName)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: access point ARNs can't be used as bucket, use the alias of access point %s of account %s (ending with %s) as bucket instead, with region %s in the secret",
+		ErrInvalidConfig, ap.Name, ap.Account, accessPointAliasSuffix, ap.Region)
+}
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAccessPointARN(t *testing.T) {
+	ap, err := ParseAccessPointARN("arn:aws:s3:us-west-2:123456789012:accesspoint/data-ap")
+	if err != nil {
+		t.Fatalf("ParseAccessPointARN() error = %v", err)
+	}
+	if want := (AccessPoint{"aws", "us-west-2", "123456789012", "data-ap"}); *ap != want {
+		t.Errorf("ParseAccessPointARN() = %+v, want %+v", *ap, want)
+	}
+
+	for _, arn := range []string{
+		"arn:aws:s3:::bucket",
+		"arn:aws:iam::123456789012:role/data",
+		"arn:aws:s3::123456789012:accesspoint/data",
+		"arn:aws:s3:us-west-2:1234:accesspoint/data",
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/Data",
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/-data",
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/",
+		"arn:aws:s3:us-west-2:123456789012:job/data",
+	} {
+		if _, err := ParseAccessPointARN(arn); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseAccessPointARN(%q) error = %v, want ErrInvalidConfig", arn, err)
+		}
+	}
+}
+
+func TestCheckAccessPointBucket(t *testing.T) {
+	for _, bucket := range []string{"bucket", "data-a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6-s3alias"} {
+		if err := CheckAccessPointBucket(bucket); err != nil {
+			t.Errorf("CheckAccessPointBucket(%q) error = %v", bucket, err)
+		}
+	}
+	err := CheckAccessPointBucket("arn:aws:s3:us-west-2:123456789012:accesspoint/data")
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "alias") || !strings.Contains(err.Error(), "us-west-2") {
+		t.Errorf("CheckAccessPointBucket() of ARN error = %v, want ErrInvalidConfig naming the alias and region", err)
+	}
+}
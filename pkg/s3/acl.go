@@ -0,0 +1,33 @@
+package s3
+
+import "fmt"
+
+// cannedACLs are the canned ACLs of S3, mapped to whether they can be set
+// on buckets. The bucket-owner-* ACLs only apply to objects.
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	"public-read":               true,
+	"public-read-write":         true,
+	"authenticated-read":        true,
+	"aws-exec-read":             false,
+	"bucket-owner-read":         false,
+	"bucket-owner-full-control": false,
+	"log-delivery-write":        true,
+}
+
+// ValidateCannedACL checks that acl is empty or a known canned ACL
+func ValidateCannedACL(acl string) error {
+	if _, ok := cannedACLs[acl]; acl != "" && !ok {
+		return fmt.Errorf("%w: unknown canned ACL %q", ErrInvalidConfig, acl)
+	}
+	return nil
+}
+
+// bucketACL returns the canned ACL to create buckets with, or "" if the
+// configured one only applies to objects
+func (client *s3Client) bucketACL() string {
+	if cannedACLs[client.Config.CannedACL] {
+		return client.Config.CannedACL
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// allowListLogged holds the allow-lists already logged, as a new client is
+// created for every request
+var allowListLogged sync.Map
+
+// ParseAllowedBuckets parses a comma separated list of bucket name patterns
+// like team-xyz-*, using the syntax of path.Match
+func ParseAllowedBuckets(s string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: allowedBuckets pattern %q: %v", ErrInvalidConfig, pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// CheckBucketAllowed returns ErrBucketNotAllowed if Config.AllowedBuckets is
+// set and the bucket matches none of its patterns. Buckets outside of the
+// list are never created or removed, and neither are volumes in them.
+func (client *s3Client) CheckBucketAllowed(bucketName string) error {
+	if len(client.Config.AllowedBuckets) == 0 {
+		return nil
+	}
+	for _, pattern := range client.Config.AllowedBuckets {
+		if ok, _ := path.Match(pattern, bucketName); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s does not match %s", ErrBucketNotAllowed, bucketName, strings.Join(client.Config.AllowedBuckets, ","))
+}
+
+// logAllowedBuckets logs an allow-list the first time it is used
+func logAllowedBuckets(patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+	list := strings.Join(patterns, ",")
+	if _, logged := allowListLogged.LoadOrStore(list, true); !logged {
+		glog.Infof("Bucket allow-list is enabled: only buckets matching %s are created or deleted", list)
+	}
+}
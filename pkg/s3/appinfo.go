@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// defaultAppName identifies the driver in the User-Agent of its requests, so
+// backend operators can tell its traffic apart from other minio clients
+const defaultAppName = "k8s-csi-s3"
+
+var appInfo = struct {
+	sync.RWMutex
+	name, version string
+}{name: defaultAppName, version: buildVersion()}
+
+// SetAppInfo sets the application name and version sent in the User-Agent of
+// all clients, unless overridden in their Config
+func SetAppInfo(name, version string) {
+	appInfo.Lock()
+	defer appInfo.Unlock()
+	appInfo.name = name
+	appInfo.version = version
+}
+
+// userAgent returns the application name and version to send, those of the
+// Config taking precedence over the ones set with SetAppInfo
+func (client *s3Client) userAgent() (string, string) {
+	appInfo.RLock()
+	name, version := appInfo.name, appInfo.version
+	appInfo.RUnlock()
+	if client.Config.AppName != "" {
+		name = client.Config.AppName
+	}
+	if client.Config.AppVersion != "" {
+		version = client.Config.AppVersion
+	}
+	return name, version
+}
+
+// buildVersion returns the module version the binary was built from, which
+// is only known for builds of tagged releases
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" || info.Main.Version == "(devel)" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
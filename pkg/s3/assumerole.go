@@ -0,0 +1,199 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	defaultSTSEndpoint = "https://sts.amazonaws.com"
+	roleSessionName    = "csi-s3"
+	// webIdentityTokenFileEnv is the variable set by EKS for the projected
+	// service account token
+	webIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+)
+
+// AssumeRoleOptions are the parameters of AssumeRole
+type AssumeRoleOptions struct {
+	// STSEndpoint defaults to the regional AWS STS endpoint if Region is
+	// set, the global one otherwise
+	STSEndpoint string
+	Region      string
+	AccessKey   string
+	SecretKey   string
+	RoleARN     string
+	// ExternalID is required by roles of other accounts that demand it
+	ExternalID string
+	// TokenFile, if set, is a web identity token, e.g. a projected service
+	// account token, to assume the role with AssumeRoleWithWebIdentity
+	// instead of the keys. It is read again for every call, as the token
+	// is rotated on disk.
+	TokenFile string
+}
+
+// stsCredentials are the credentials in the responses of AssumeRole and
+// AssumeRoleWithWebIdentity
+type stsCredentials struct {
+	AccessKey    string    `xml:"AccessKeyId"`
+	SecretKey    string    `xml:"SecretAccessKey"`
+	SessionToken string    `xml:"SessionToken"`
+	Expiration   time.Time `xml:"Expiration"`
+}
+
+type stsErrorResponse struct {
+	Error struct {
+		Code    string
+		Message string
+	}
+}
+
+// AssumeRole gets temporary credentials of a role with the STS AssumeRole
+// API, authenticating with long-lived keys, or with AssumeRoleWithWebIdentity
+// if opts.TokenFile is set. minio's STSAssumeRole can't pass an external ID
+// and its STSWebIdentity can't pass a role, so the request is made here.
+func AssumeRole(opts AssumeRoleOptions) (credentials.Value, time.Time, error) {
+	endpoint := opts.STSEndpoint
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = defaultSTSEndpoint
+		if opts.Region != "" {
+			endpoint = "https://sts." + opts.Region + ".amazonaws.com"
+		}
+	}
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", opts.RoleARN)
+	form.Set("RoleSessionName", roleSessionName)
+	if opts.ExternalID != "" {
+		form.Set("ExternalId", opts.ExternalID)
+	}
+	if opts.TokenFile != "" {
+		token, err := ReadWebIdentityToken(opts.TokenFile)
+		if err != nil {
+			return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: %v", ErrAssumeRole, opts.RoleARN, err)
+		}
+		form.Set("Action", "AssumeRoleWithWebIdentity")
+		form.Set("WebIdentityToken", token)
+	}
+	body := form.Encode()
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", strings.NewReader(body))
+	if err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("%w: stsEndpoint: %v", ErrInvalidConfig, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if opts.TokenFile == "" {
+		// AssumeRoleWithWebIdentity is authenticated by the token alone
+		sum := sha256.Sum256([]byte(body))
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		req = signer.SignV4STS(*req, opts.AccessKey, opts.SecretKey, region)
+	}
+
+	client := &http.Client{Timeout: defaultRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: %v", ErrAssumeRole, opts.RoleARN, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: %v", ErrAssumeRole, opts.RoleARN, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp stsErrorResponse
+		if xml.Unmarshal(data, &errResp) == nil && errResp.Error.Code != "" {
+			return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: %s: %s", ErrAssumeRole, opts.RoleARN, errResp.Error.Code, errResp.Error.Message)
+		}
+		return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: %s", ErrAssumeRole, opts.RoleARN, resp.Status)
+	}
+	var result struct {
+		AssumeRole            stsCredentials `xml:"AssumeRoleResult>Credentials"`
+		AssumeRoleWebIdentity stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err = xml.Unmarshal(data, &result); err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("%w %s: invalid response: %v", ErrAssumeRole, opts.RoleARN, err)
+	}
+	creds := result.AssumeRole
+	if opts.TokenFile != "" {
+		creds = result.AssumeRoleWebIdentity
+	}
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, creds.Expiration, nil
+}
+
+// assumeRoleProvider implements credentials.Provider with AssumeRole,
+// assuming the role again shortly before the credentials expire
+type assumeRoleProvider struct {
+	credentials.Expiry
+	opts AssumeRoleOptions
+}
+
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	value, expiration, err := AssumeRole(p.opts)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.SetExpiration(expiration, credentials.DefaultExpiryWindow)
+	return value, nil
+}
+
+// ReadWebIdentityToken reads a web identity token file, failing if it is
+// missing or empty
+func ReadWebIdentityToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read web identity token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("web identity token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// webIdentityTokenFile returns the web identity token file to assume the role
+// of a secret with: tokenFile, or AWS_WEB_IDENTITY_TOKEN_FILE if the secret
+// has neither it nor keys. A role needs either keys or a readable token.
+func webIdentityTokenFile(secret map[string]string) (string, error) {
+	if secret["roleArn"] == "" {
+		if secret["tokenFile"] != "" {
+			return "", fmt.Errorf("%w: tokenFile requires roleArn", ErrInvalidConfig)
+		}
+		return "", nil
+	}
+	tokenFile := secret["tokenFile"]
+	if secret["accessKeyID"] != "" {
+		if tokenFile != "" {
+			return "", fmt.Errorf("%w: tokenFile can't be used together with accessKeyID", ErrInvalidConfig)
+		}
+		return "", nil
+	}
+	if tokenFile == "" {
+		tokenFile = os.Getenv(webIdentityTokenFileEnv)
+	}
+	if tokenFile == "" {
+		return "", fmt.Errorf("%w: roleArn requires accessKeyID and secretAccessKey, or tokenFile", ErrInvalidConfig)
+	}
+	if _, err := ReadWebIdentityToken(tokenFile); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return tokenFile, nil
+}
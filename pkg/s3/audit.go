@@ -0,0 +1,100 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuditProblem is the kind of problem found by AuditVolumes
+type AuditProblem string
+
+const (
+	// AuditMissingMetadata is reported for prefixes without metadata, which
+	// are mounted with the mounter of the storage class
+	AuditMissingMetadata AuditProblem = "MissingMetadata"
+	// AuditInvalidMetadata is reported for metadata objects that can't be
+	// decoded
+	AuditInvalidMetadata AuditProblem = "InvalidMetadata"
+	// AuditMetadataMismatch is reported for metadata naming another bucket or
+	// prefix, which keeps the volume from being deleted
+	AuditMetadataMismatch AuditProblem = "MetadataMismatch"
+	// AuditUnsupportedMounter is reported for mounters the driver doesn't
+	// implement anymore
+	AuditUnsupportedMounter AuditProblem = "UnsupportedMounter"
+	// AuditInvalidMountOption is reported for mount options that can't be
+	// passed to a mounter
+	AuditInvalidMountOption AuditProblem = "InvalidMountOption"
+)
+
+// AuditFinding is a problem of a volume found by AuditVolumes
+type AuditFinding struct {
+	BucketName string
+	Prefix     string
+	Problem    AuditProblem
+	Detail     string
+}
+
+func (f AuditFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", FormatVolumeID(f.BucketName, f.Prefix), f.Problem, f.Detail)
+}
+
+// AuditVolumes checks the metadata of the volumes in a bucket, i.e. that of
+// a volume using the whole bucket and those of the prefix volumes, for
+// problems that would make mounting or deleting them fail, e.g. after an
+// upgrade of the driver. It only reports them and doesn't change anything.
+func (client *s3Client) AuditVolumes(bucketName string) ([]AuditFinding, error) {
+	var findings []AuditFinding
+	prefixes, err := client.ListPrefixes(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for i, prefix := range append([]string{""}, prefixes...) {
+		meta, err := client.ReadMeta(bucketName, prefix)
+		if errors.Is(err, ErrNotFound) {
+			// A bucket without metadata may just hold prefix volumes
+			if i > 0 {
+				findings = append(findings, AuditFinding{bucketName, prefix, AuditMissingMetadata, "no metadata object"})
+			}
+			continue
+		}
+		if errors.Is(err, ErrInvalidMetadata) {
+			findings = append(findings, AuditFinding{bucketName, prefix, AuditInvalidMetadata, err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, auditMeta(bucketName, prefix, meta)...)
+	}
+	return findings, nil
+}
+
+// auditMeta checks the metadata found for the volume at bucket/prefix
+func auditMeta(bucketName, prefix string, meta *FSMeta) []AuditFinding {
+	var findings []AuditFinding
+	add := func(problem AuditProblem, format string, args ...interface{}) {
+		findings = append(findings, AuditFinding{bucketName, prefix, problem, fmt.Sprintf(format, args...)})
+	}
+	if meta.BucketName != bucketName || meta.Prefix != prefix {
+		add(AuditMetadataMismatch, "metadata is for %s", FormatVolumeID(meta.BucketName, meta.Prefix))
+	}
+	// An empty mounter is the default one of the secret
+	if meta.Mounter != "" && !supportedMounters[meta.Mounter] {
+		add(AuditUnsupportedMounter, "mounter %q is not supported", meta.Mounter)
+	}
+	for _, mounter := range meta.MounterFallback {
+		if !supportedMounters[mounter] {
+			add(AuditUnsupportedMounter, "fallback mounter %q is not supported", mounter)
+		}
+	}
+	for _, opt := range meta.MountOptions {
+		switch {
+		case strings.TrimSpace(opt) == "":
+			add(AuditInvalidMountOption, "empty mount option")
+		case strings.ContainsAny(opt, "\x00\n\r"):
+			add(AuditInvalidMountOption, "mount option %q contains control characters", opt)
+		}
+	}
+	return findings
+}
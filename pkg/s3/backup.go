@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExportAllMetadata returns the metadata of all volumes in a bucket, for
+// backups or to audit which volumes the driver knows of: that of a volume
+// using the whole bucket and those of the prefix volumes. Prefixes without
+// metadata are skipped with a warning.
+func (client *s3Client) ExportAllMetadata(bucketName string) ([]FSMeta, error) {
+	var volumes []FSMeta
+	meta, err := client.ReadMeta(bucketName, "")
+	if err == nil {
+		volumes = append(volumes, *meta)
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	prefixes, err := client.ListPrefixes(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range prefixes {
+		meta, err := client.ReadMeta(bucketName, prefix)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+			client.log().Warningf("Prefix %s of bucket %s has no metadata, skipping it", prefix, bucketName)
+			continue
+		}
+		volumes = append(volumes, *meta)
+	}
+	return volumes, nil
+}
+
+// ImportMetadata writes the metadata of volumes, e.g. as returned by
+// ExportAllMetadata, replacing the current metadata. All volumes are written
+// even if some fail.
+func (client *s3Client) ImportMetadata(volumes []FSMeta) error {
+	failed := 0
+	for i := range volumes {
+		// Replace the metadata, whatever it changed to since the export
+		meta := volumes[i]
+		meta.ETag = ""
+		if err := client.WriteMeta(&meta); err != nil {
+			client.log().Errorf("Failed to write metadata of %s/%s: %v", volumes[i].BucketName, volumes[i].Prefix, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to write metadata of %d out of %d volumes", failed, len(volumes))
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package s3
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ParseBandwidthLimit parses a bandwidth limit of a volume in bytes per
+// second. An empty string means no limit and is returned as zero.
+func ParseBandwidthLimit(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("%w: bandwidth limit %q must be a positive number of bytes per second", ErrInvalidConfig, s)
+	}
+	return limit, nil
+}
@@ -0,0 +1,59 @@
+package s3
+
+import (
+	"sync"
+	"time"
+)
+
+// bucketExistence caches the results of BucketExists for Config.BucketCacheTTL,
+// keyed by endpoint and bucket name. Like bucketRegions it is shared by all
+// clients, as a new client is created for every request.
+var bucketExistence = struct {
+	sync.Mutex
+	entries map[string]bucketExistenceEntry
+}{entries: make(map[string]bucketExistenceEntry)}
+
+type bucketExistenceEntry struct {
+	exists  bool
+	expires time.Time
+}
+
+// cachedBucketExists returns the cached existence of a bucket, if caching is
+// enabled and the entry hasn't expired
+func (client *s3Client) cachedBucketExists(bucketName string) (exists, ok bool) {
+	if client.Config.BucketCacheTTL <= 0 {
+		return false, false
+	}
+	bucketExistence.Lock()
+	defer bucketExistence.Unlock()
+	key := client.regionKey(bucketName)
+	entry, ok := bucketExistence.entries[key]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(bucketExistence.entries, key)
+		return false, false
+	}
+	return entry.exists, true
+}
+
+func (client *s3Client) setCachedBucketExists(bucketName string, exists bool) {
+	if client.Config.BucketCacheTTL <= 0 {
+		return
+	}
+	bucketExistence.Lock()
+	defer bucketExistence.Unlock()
+	bucketExistence.entries[client.regionKey(bucketName)] = bucketExistenceEntry{
+		exists:  exists,
+		expires: time.Now().Add(client.Config.BucketCacheTTL),
+	}
+}
+
+// forgetBucketExists drops the cached existence of a bucket after creating or
+// removing it
+func (client *s3Client) forgetBucketExists(bucketName string) {
+	bucketExistence.Lock()
+	defer bucketExistence.Unlock()
+	delete(bucketExistence.entries, client.regionKey(bucketName))
+}
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Prefixes and suffixes of bucket names reserved by AWS
+var (
+	reservedBucketPrefixes = []string{"xn--", "sthree-"}
+	reservedBucketSuffixes = []string{"-s3alias", "--ol-s3"}
+)
+
+// ValidateBucketName checks a bucket name against the naming rules of S3,
+// naming the rule it breaks, so that a bad name fails before reaching the
+// backend. Lenient skips the prefixes and suffixes only reserved by AWS, for
+// backends that allow them. The other rules are enforced by the S3 library
+// when creating buckets anyway.
+func ValidateBucketName(name string, lenient bool) error {
+	fail := func(rule string) error {
+		return fmt.Errorf("%w: %q %s", ErrInvalidBucketName, name, rule)
+	}
+	if len(name) < 3 || len(name) > 63 {
+		return fail("must be 3 to 63 characters long")
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			return fail("contains uppercase letters")
+		case c == '_':
+			return fail("contains underscores")
+		case !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-'):
+			return fail(fmt.Sprintf("contains %q, only lowercase letters, digits, dots and hyphens are allowed", c))
+		}
+	}
+	if first, last := name[0], name[len(name)-1]; first == '.' || first == '-' || last == '.' || last == '-' {
+		return fail("must start and end with a letter or digit")
+	}
+	for _, s := range []string{"..", ".-", "-."} {
+		if strings.Contains(name, s) {
+			return fail(fmt.Sprintf("contains %q, dots must be between letters or digits", s))
+		}
+	}
+	if net.ParseIP(name) != nil {
+		return fail("is formatted as an IP address")
+	}
+	if lenient {
+		return nil
+	}
+	for _, prefix := range reservedBucketPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fail(fmt.Sprintf("starts with %s, which is reserved by AWS", prefix))
+		}
+	}
+	for _, suffix := range reservedBucketSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return fail(fmt.Sprintf("ends with %s, which is reserved by AWS", suffix))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	checkpointName = ".delete-checkpoint.json"
+	// defaultDeletePageSize is the number of objects listed and removed
+	// between two checkpoints
+	defaultDeletePageSize = 1000
+)
+
+type deleteCheckpoint struct {
+	Marker string `json:"Marker"`
+}
+
+// removeObjectsResumable removes the objects under prefix page by page in key
+// order. After every page the last removed key is saved to a checkpoint
+// object, so a removal interrupted by a restart continues from there instead
+// of listing everything again. Versions are not handled, so it is only used
+// for unversioned buckets.
+func (client *s3Client) removeObjectsResumable(bucketName, prefix string) (RemoveStats, error) {
+	var stats RemoveStats
+	key := prefix + checkpointName
+	marker, err := client.readCheckpoint(bucketName, key)
+	if err != nil {
+		return stats, err
+	}
+	if marker != "" {
+		client.log().Infof("Resuming removal of %s/%s after %s", bucketName, prefix, marker)
+	}
+	pageSize := client.Config.ListMaxKeys
+	if pageSize <= 0 {
+		pageSize = defaultDeletePageSize
+	}
+	filter := client.newRemoveFilter(bucketName, prefix)
+	for {
+		// Checkpoint before giving up, the marker is saved after every page
+		if err = client.ctx.Err(); err != nil {
+			return stats, err
+		}
+		// Pages are listed one request at a time, so unlike a streaming
+		// listing the removal can fail over between them
+		var result minio.ListBucketResult
+		err = client.withRetry(bucketName, func(c *minio.Client) (err error) {
+			result, err = minio.Core{Client: c}.ListObjects(bucketName, prefix, marker, "", pageSize)
+			return err
+		})
+		if err != nil {
+			return stats, err
+		}
+		objects, err := filter.filter(result.Contents)
+		if err != nil {
+			return stats, err
+		}
+		removed, err := client.removePage(bucketName, key, objects)
+		stats.add(removed)
+		if err != nil {
+			return stats, err
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			break
+		}
+		marker = result.Contents[len(result.Contents)-1].Key
+		if err = client.writeCheckpoint(bucketName, key, marker); err != nil {
+			return stats, err
+		}
+	}
+	err = client.removeObject(client.ctx, bucketName, key, minio.RemoveObjectOptions{})
+	if err != nil && !isNotFound(err) {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// removePage removes a page of listed objects, except for the checkpoint
+func (client *s3Client) removePage(bucketName, checkpointKey string, objects []minio.ObjectInfo) (RemoveStats, error) {
+	page := make([]minio.ObjectInfo, 0, len(objects))
+	for _, object := range objects {
+		if object.Key != checkpointKey {
+			page = append(page, object)
+		}
+	}
+	stats, throttled := client.removeBatch(bucketName, page)
+	stats.add(client.retryThrottled(bucketName, throttled))
+	if stats.Failed > 0 {
+		return stats, fmt.Errorf("%w: %d of bucket %s", ErrObjectsNotRemoved, stats.Failed, bucketName)
+	}
+	return stats, nil
+}
+
+// readCheckpoint returns the marker saved by an interrupted removal, or ""
+func (client *s3Client) readCheckpoint(bucketName, key string) (string, error) {
+	obj, err := client.GetObject(bucketName, key)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	var checkpoint deleteCheckpoint
+	if err = json.NewDecoder(obj).Decode(&checkpoint); err != nil {
+		client.log().Warningf("Ignoring invalid checkpoint %s/%s: %v", bucketName, key, err)
+		return "", nil
+	}
+	return checkpoint.Marker, nil
+}
+
+func (client *s3Client) writeCheckpoint(bucketName, key, marker string) error {
+	data, err := json.Marshal(deleteCheckpoint{Marker: marker})
+	if err != nil {
+		return err
+	}
+	return client.PutObject(bucketName, key, data)
+}
@@ -3,8 +3,15 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"net/url"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/minio/minio-go/v7"
@@ -13,12 +20,70 @@ import (
 
 const (
 	metadataName = ".metadata.json"
+	// metadataContentType is the content type of the metadata and lock
+	// objects, which hold JSON
+	metadataContentType = "application/json"
+	// probePrefix starts the names of the objects written by CheckWritable
+	probePrefix = ".csi-s3-probe-"
+	// metadataLockTTL bounds how long a metadata update holds the volume lock
+	metadataLockTTL = time.Minute
+	// metaUpdateAttempts bounds how often UpdateMeta starts over after a
+	// concurrent change of the metadata
+	metaUpdateAttempts = 5
 )
 
+// supportedMounters are the mounters implemented in pkg/mounter
+var supportedMounters = map[string]bool{
+	"geesefs": true,
+	"s3fs":    true,
+	"rclone":  true,
+}
+
+// ParseMounters splits a comma separated list of mounters, e.g. the fallback
+// mounters of a volume, checking that the driver supports them
+func ParseMounters(value string) ([]string, error) {
+	var mounters []string
+	for _, mounter := range strings.Split(value, ",") {
+		mounter = strings.TrimSpace(mounter)
+		if mounter == "" {
+			continue
+		}
+		if !supportedMounters[mounter] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMounter, mounter)
+		}
+		mounters = append(mounters, mounter)
+	}
+	return mounters, nil
+}
+
 type s3Client struct {
 	Config *Config
 	minio  *minio.Client
 	ctx    context.Context
+	lockID string
+	opID   string
+	// cancel cancels ctx, closed, operations and inFlight track the
+	// long-running operations for Close. closed and operations are guarded
+	// by the mutex of the package-level operations.
+	cancel     context.CancelFunc
+	closed     bool
+	operations int
+	inFlight   sync.WaitGroup
+
+	creds *credentials.Credentials
+	// regionMutex guards regionClients, and minio and Config.Endpoint,
+	// which a failover swaps while other requests are running
+	regionMutex   sync.Mutex
+	regionClients map[string]*minio.Client
+	// primaryEndpoint is the configured endpoint, Config.Endpoint the one
+	// in use, which differs after failing over to a fallback endpoint.
+	// failoverMutex serializes failovers.
+	primaryEndpoint string
+	failoverMutex   sync.Mutex
+	// listV1 is set to 1 once list detected that the backend doesn't
+	// implement ListObjectsV2. It is accessed atomically, as listings run
+	// concurrently; Config is shared and left as configured.
+	listV1 int32
 }
 
 // Config holds values to configure the driver
@@ -28,195 +93,1353 @@ type Config struct {
 	Region          string
 	Endpoint        string
 	Mounter         string
+	// SigningRegion is the region requests are signed for, Region is only
+	// used as the location of created buckets. It defaults to Region, for
+	// gateways which only accept a fixed signing region.
+	SigningRegion string
+	// NoLocationConstraint creates buckets without a LocationConstraint
+	// even if Region is set, for backends which reject any
+	NoLocationConstraint bool
+	// Anonymous makes the client send unsigned requests, for public
+	// buckets that can only be mounted read-only
+	Anonymous bool
+	// Profile and CredentialsFile read the credentials from a profile of
+	// an AWS shared credentials file instead. Empty values select the
+	// defaults, AWS_PROFILE or "default" and AWS_SHARED_CREDENTIALS_FILE
+	// or ~/.aws/credentials.
+	Profile         string
+	CredentialsFile string
+	// RoleARN is a role assumed with AccessKeyID and SecretAccessKey, or
+	// with the web identity token in TokenFile, to access S3, with
+	// ExternalID if the role requires one. The mounters can't assume
+	// roles, so the node refuses to mount with it.
+	RoleARN     string
+	ExternalID  string
+	STSEndpoint string
+	TokenFile   string
+	// UseIAM takes the credentials of the EC2 instance profile or ECS task
+	// role, from IAMEndpoint if set instead of the metadata service or the
+	// ECS credentials endpoint. The mounters use the role of the nodes.
+	UseIAM      bool
+	IAMEndpoint string
+	// CredentialProvider, if set, is called for the credentials of every
+	// request instead of using AccessKeyID and SecretAccessKey. Mounters
+	// still need the static keys.
+	CredentialProvider CredentialProvider
+	// RequestTimeout bounds the wait for a response from the endpoint
+	// and DialTimeout the time to establish a connection. Zero values
+	// select the defaults.
+	RequestTimeout time.Duration
+	DialTimeout    time.Duration
+	// MetadataName overrides the name of the metadata object, and
+	// MetadataPrefix stores it under that prefix instead of inside the
+	// volume, so it never shows up in the mounted filesystem.
+	// DisableMetadata turns off writing it altogether.
+	MetadataName    string
+	MetadataPrefix  string
+	DisableMetadata bool
+	// RegionDiscovery looks up the region of every bucket and signs the
+	// requests for it, for buckets in several regions behind one endpoint
+	RegionDiscovery bool
+	// AppName and AppVersion replace the driver's name and version in the
+	// User-Agent of requests
+	AppName    string
+	AppVersion string
+	// AllowedBuckets restricts the buckets the driver creates and deletes,
+	// and deletes volumes in, to those matching one of these patterns. Empty
+	// allows all buckets.
+	AllowedBuckets []string
+	// BucketCacheTTL is how long the results of BucketExists are cached,
+	// zero disables the cache
+	BucketCacheTTL time.Duration
+	// UseDualStack connects to the dual-stack AWS endpoint of the region,
+	// for nodes reaching AWS over IPv6. Other endpoints are connected to
+	// over IPv4 or IPv6 as their names resolve.
+	UseDualStack bool
+	// FallbackEndpoints are tried in order when the endpoint can't be
+	// reached or fails with a server error. Requests are retried on them,
+	// except for batch removals and listings past their first object, which
+	// can't be resumed and fail. Clients created within failoverTTL of a
+	// failover start on the fallback endpoint.
+	FallbackEndpoints []string
+	// ProxyURL is the proxy used to connect to the endpoint, instead of the
+	// one set by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables. "direct" connects without a proxy.
+	ProxyURL string
+	// CustomHeaders are added to every request, e.g. an API key or tenant
+	// ID required by a gateway in front of S3. They aren't signed. The
+	// mounters don't send them.
+	CustomHeaders map[string]string
+	// ListObjectsV1 lists objects with the V1 API, for gateways not (or not
+	// correctly) implementing ListObjectsV2. ListMaxKeys sets the page size
+	// of listings, zero leaves it to the backend.
+	ListObjectsV1 bool
+	ListMaxKeys   int
+	// CannedACL is applied to the buckets and objects created by the
+	// driver and the mounters. ACLs which only apply to objects, like
+	// bucket-owner-full-control, are not set on buckets.
+	CannedACL string
+	// ResumableDelete removes objects in pages, saving the progress to a
+	// checkpoint object so an interrupted removal continues where it
+	// stopped
+	ResumableDelete bool
+	// RequesterPays sends all requests with x-amz-request-payer, which is
+	// required to access requester-pays buckets
+	RequesterPays bool
+	// PartSize is the size of the parts of multipart uploads by the
+	// mounters and of copies by the driver, in bytes, which copies larger
+	// objects in parts. UploadConcurrency is the number of parts the
+	// mounters upload at the same time. Zero leaves them to the default.
+	PartSize          uint64
+	UploadConcurrency uint
+	// SkipDeleteCheck removes volumes even if their metadata names another
+	// bucket or prefix
+	SkipDeleteCheck bool
+	// ForceDelete removes volumes even if some of their objects can't be
+	// removed, e.g. because of a legal hold, and leaves those behind
+	ForceDelete bool
+	// RemoveScope is RemoveVolume or RemoveDirect to make RemovePrefix keep
+	// the volumes, or all prefixes, nested in the prefix. Empty is
+	// RemoveAll.
+	RemoveScope string
+	// DeleteMaxObjects and DeleteMaxBytes make RemoveBucket and RemovePrefix
+	// refuse to remove more objects or bytes than that, zero means no limit,
+	// unless ConfirmLargeDelete is set. They guard against removing the
+	// data of the wrong volume, e.g. after a bad change of the reclaim policy.
+	DeleteMaxObjects   int64
+	DeleteMaxBytes     int64
+	ConfirmLargeDelete bool
+	// ReclaimPolicy is ReclaimPrefixOnly to make RemoveBucket refuse to run,
+	// for credentials of buckets shared by prefix volumes. Empty is
+	// ReclaimBucket, which allows both RemoveBucket and RemovePrefix.
+	ReclaimPolicy string
+	// LenientBucketNames makes CreateBucket accept names with prefixes and
+	// suffixes only reserved by AWS, e.g. xn--, for backends allowing them
+	LenientBucketNames bool
+	// ReusePrefix allows CreatePrefix to succeed on a prefix that already
+	// holds data. It is set from the volume parameters, not secrets.
+	ReusePrefix bool
+	// NoPlaceholder skips writing the empty prefix+"/" placeholder object of
+	// new volumes, for mounters that handle prefixes without one. It is set
+	// from the volume parameters too.
+	NoPlaceholder bool
+	// CompressMetadata gzips the metadata objects written, for volumes
+	// with long lists of mount options. ReadMeta reads both forms.
+	CompressMetadata bool
+	// ObjectMetadata, ObjectTags and ObjectCacheControl are set on the
+	// placeholder and metadata objects of volumes, ObjectContentType on the placeholder only
+	// as the metadata object is always JSON. They are set from the volume
+	// parameters too.
+	ObjectMetadata     map[string]string
+	ObjectTags         map[string]string
+	ObjectContentType  string
+	ObjectCacheControl string
+	// ObjectStorageClass is the storage class of the objects written by the
+	// driver and the mounters, the backend's default if empty. It is set
+	// from the volume parameters.
+	ObjectStorageClass string
 }
 
 type FSMeta struct {
-	BucketName    string `json:"Name"`
-	Prefix        string `json:"Prefix"`
-	Mounter       string `json:"Mounter"`
+	BucketName    string   `json:"Name"`
+	Prefix        string   `json:"Prefix"`
+	Mounter       string   `json:"Mounter"`
 	MountOptions  []string `json:"MountOptions"`
-	CapacityBytes int64  `json:"CapacityBytes"`
+	CapacityBytes int64    `json:"CapacityBytes"`
+	// UploadBandwidthLimit and DownloadBandwidthLimit cap the throughput
+	// of the mounter in bytes per second, zero means unlimited
+	UploadBandwidthLimit   int64 `json:"UploadBandwidthLimit,omitempty"`
+	DownloadBandwidthLimit int64 `json:"DownloadBandwidthLimit,omitempty"`
+	// MounterFallback are the mounters tried in order when Mounter isn't
+	// installed on a node or fails to mount the volume
+	MounterFallback []string `json:"MounterFallback,omitempty"`
+	// MountedWith is the mounter the volume was last staged with, which
+	// differs from Mounter after a fallback
+	MountedWith string `json:"MountedWith,omitempty"`
+	// ETag is the ETag of the metadata object the metadata was read from,
+	// if any. WriteMeta only replaces the object if it still has this ETag.
+	ETag string `json:"-"`
 }
 
 func NewClient(cfg *Config) (*s3Client, error) {
 	var client = &s3Client{}
 
 	client.Config = cfg
-	u, err := url.Parse(client.Config.Endpoint)
+	normalized, err := normalizeEndpoint(client.Config.Endpoint)
 	if err != nil {
 		return nil, err
 	}
-	ssl := u.Scheme == "https"
-	endpoint := u.Hostname()
-	if u.Port() != "" {
-		endpoint = u.Hostname() + ":" + u.Port()
+	// Mounters get the normalized endpoint too
+	client.Config.Endpoint = normalized
+	if err = ValidateProxyURL(client.Config.ProxyURL); err != nil {
+		return nil, err
 	}
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(client.Config.AccessKeyID, client.Config.SecretAccessKey, ""),
-		Secure: ssl,
-	})
+	if err = ValidateCustomHeaders(client.Config.CustomHeaders); err != nil {
+		return nil, err
+	}
+	endpoint, ssl, err := parseEndpoint(client.Config.Endpoint)
 	if err != nil {
 		return nil, err
 	}
-	client.minio = minioClient
-	client.ctx = context.Background()
+	if err = ValidateRegion("region", client.Config.Region); err != nil {
+		return nil, err
+	}
+	if err = ValidateRegion("signingRegion", client.Config.SigningRegion); err != nil {
+		return nil, err
+	}
+	if client.Config.Region == "" {
+		// Mounters get the region too
+		client.Config.Region = endpointRegion(endpoint)
+	}
+	if client.Config.SigningRegion == "" {
+		// Mounters sign for it too
+		client.Config.SigningRegion = client.Config.Region
+	}
+	if client.Config.UseDualStack {
+		dualStack := dualStackEndpoint(endpoint, client.Config.Region)
+		if dualStack == endpoint && !strings.HasPrefix(endpoint, "s3.dualstack.") {
+			glog.Warningf("useDualStack only applies to AWS endpoints with a region, using %s", endpoint)
+		}
+		// Mounters connect to it too
+		client.Config.Endpoint = strings.Replace(client.Config.Endpoint, endpoint, dualStack, 1)
+		endpoint = dualStack
+	}
+	for i, fallback := range client.Config.FallbackEndpoints {
+		if client.Config.FallbackEndpoints[i], err = normalizeEndpoint(fallback); err != nil {
+			return nil, err
+		}
+	}
+	client.primaryEndpoint = client.Config.Endpoint
+	if active, ok := client.cachedEndpoint(); ok {
+		glog.V(4).Infof("Using fallback endpoint %s instead of %s", active, client.primaryEndpoint)
+		// Mounters connect to it too
+		client.Config.Endpoint = active
+		if endpoint, ssl, err = parseEndpoint(active); err != nil {
+			return nil, err
+		}
+	}
+	logProxy(client.Config.Endpoint, client.Config.ProxyURL)
+	logAllowedBuckets(client.Config.AllowedBuckets)
+	if err = client.connect(endpoint, ssl); err != nil {
+		return nil, err
+	}
+	if client.Config.usesProfile() {
+		// Mounters get the keys from the profile too
+		value, err := client.creds.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials of profile %q: %v", client.Config.Profile, err)
+		}
+		client.Config.AccessKeyID = value.AccessKeyID
+		client.Config.SecretAccessKey = value.SecretAccessKey
+	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
 	return client, nil
 }
 
+// connect sets up the credentials and minio client from the config
+func (client *s3Client) connect(endpoint string, ssl bool) error {
+	client.creds = newCredentials(client.Config)
+	minioClient, err := client.newMinio(endpoint, ssl, client.Config.SigningRegion)
+	if err != nil {
+		return err
+	}
+	client.minio = minioClient
+	return nil
+}
+
+// UpdateCredentials switches the client to the credentials of cfg, e.g.
+// after the keys in the secret have been rotated. The minio clients are
+// rebuilt, so it must not be called while other requests are in flight.
+func (client *s3Client) UpdateCredentials(cfg *Config) error {
+	endpoint, ssl, err := parseEndpoint(client.Config.Endpoint)
+	if err != nil {
+		return err
+	}
+	client.Config.AccessKeyID = cfg.AccessKeyID
+	client.Config.SecretAccessKey = cfg.SecretAccessKey
+	client.Config.Anonymous = cfg.Anonymous
+	client.Config.CredentialProvider = cfg.CredentialProvider
+	client.Config.Profile = cfg.Profile
+	client.Config.CredentialsFile = cfg.CredentialsFile
+	client.Config.RoleARN = cfg.RoleARN
+	client.Config.ExternalID = cfg.ExternalID
+	client.Config.STSEndpoint = cfg.STSEndpoint
+	client.Config.TokenFile = cfg.TokenFile
+	client.Config.UseIAM = cfg.UseIAM
+	client.Config.IAMEndpoint = cfg.IAMEndpoint
+	if err = client.connect(endpoint, ssl); err != nil {
+		return err
+	}
+	client.regionMutex.Lock()
+	client.regionClients = nil
+	client.regionMutex.Unlock()
+	return nil
+}
+
+// UpdateEndpoint switches the client to another endpoint, e.g. after the
+// endpoint in the secret moved from http to https. Host, port and TLS are
+// taken from the new endpoint, the client is unchanged if it's invalid. Like
+// UpdateCredentials, it must not be called while other requests are in
+// flight.
+func (client *s3Client) UpdateEndpoint(endpoint string) error {
+	normalized, err := normalizeEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	host, _, err := parseEndpoint(normalized)
+	if err != nil {
+		return err
+	}
+	if client.Config.UseDualStack {
+		normalized = strings.Replace(normalized, host, dualStackEndpoint(host, client.Config.Region), 1)
+	}
+	if err = client.useEndpoint(normalized); err != nil {
+		return err
+	}
+	client.log().Infof("Switched from endpoint %s to %s", client.primaryEndpoint, normalized)
+	client.primaryEndpoint = normalized
+	return nil
+}
+
+// NewClientWithMinio creates a client using an existing minio client instead
+// of connecting to cfg.Endpoint, e.g. one pointing to a test server
+func NewClientWithMinio(cfg *Config, minioClient *minio.Client) *s3Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &s3Client{
+		Config:          cfg,
+		minio:           minioClient,
+		ctx:             ctx,
+		cancel:          cancel,
+		creds:           newCredentials(cfg),
+		primaryEndpoint: cfg.Endpoint,
+	}
+}
+
+// newMinio creates a minio client for endpoint. An empty region makes minio
+// derive it from the endpoint or look it up per bucket.
+func (client *s3Client) newMinio(endpoint string, ssl bool, region string) (*minio.Client, error) {
+	transport, err := newTransport(client.Config, ssl)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Config.Anonymous {
+		headers := make(map[string]string)
+		if client.Config.RequesterPays {
+			warnRequesterPays()
+			headers[requestPayerHeader] = "requester"
+		}
+		transport = &signingTransport{&skewTransport{transport}, client.creds, headers}
+	}
+	c, err := minio.New(endpoint, &minio.Options{
+		Creds:     client.creds,
+		Secure:    ssl,
+		Transport: transport,
+		Region:    region,
+	})
+	if err != nil {
+		// minio's errors don't tell which part of the secret is wrong
+		return nil, fmt.Errorf("%w: failed to create S3 client for endpoint %s (host %s, ssl %v, region %q): %v",
+			ErrInvalidConfig, client.Config.Endpoint, endpoint, ssl, region, err)
+	}
+	c.SetAppInfo(client.userAgent())
+	return c, nil
+}
+
 func NewClientFromSecret(secret map[string]string) (*s3Client, error) {
+	secret = withDefaults(secret)
+	if errs := ValidateSecret(secret); len(errs) > 0 {
+		return nil, secretError(errs)
+	}
+	// The values have been validated above
+	requestTimeout, _ := time.ParseDuration(secret["requestTimeout"])
+	dialTimeout, _ := time.ParseDuration(secret["dialTimeout"])
+	bucketCacheTTL, _ := time.ParseDuration(secret["bucketCacheTTL"])
+	allowedBuckets, _ := ParseAllowedBuckets(secret["allowedBuckets"])
+	fallbackEndpoints, _ := ParseFallbackEndpoints(secret["fallbackEndpoints"])
+	customHeaders, _ := ParseCustomHeaders(secret["customHeaders"])
+	listMaxKeys, _ := strconv.Atoi(secret["listMaxKeys"])
+	deleteMaxObjects, _ := strconv.ParseInt(secret["deleteMaxObjects"], 10, 64)
+	deleteMaxBytes, _ := strconv.ParseInt(secret["deleteMaxBytes"], 10, 64)
+	// In MiB, as the mounters take it
+	partSize, _ := strconv.ParseUint(secret["partSize"], 10, 32)
+	uploadConcurrency, _ := strconv.ParseUint(secret["uploadConcurrency"], 10, 16)
+	tokenFile, _ := webIdentityTokenFile(secret)
+	useProfile := secret["profile"] != "" || secret["credentialsFile"] != ""
+	useIAM := secret["useIAM"] == "true"
 	return NewClient(&Config{
-		AccessKeyID:     secret["accessKeyID"],
-		SecretAccessKey: secret["secretAccessKey"],
-		Region:          secret["region"],
-		Endpoint:        secret["endpoint"],
-		// Mounter is set in the volume preferences, not secrets
-		Mounter: "",
+		AccessKeyID:          secret["accessKeyID"],
+		SecretAccessKey:      secret["secretAccessKey"],
+		Region:               secret["region"],
+		SigningRegion:        secret["signingRegion"],
+		NoLocationConstraint: secret["noLocationConstraint"] == "true",
+		Endpoint:             secret["endpoint"],
+		// Mounter is set in the volume preferences, not secrets, this is
+		// the fallback for volumes without one
+		Mounter: defaultMounter(),
+		// Public buckets are accessed without credentials
+		Anonymous:          secret["anonymous"] == "true" || (secret["accessKeyID"] == "" && !useProfile && tokenFile == "" && !useIAM),
+		Profile:            secret["profile"],
+		CredentialsFile:    secret["credentialsFile"],
+		RoleARN:            secret["roleArn"],
+		ExternalID:         secret["externalId"],
+		STSEndpoint:        secret["stsEndpoint"],
+		TokenFile:          tokenFile,
+		UseIAM:             useIAM,
+		IAMEndpoint:        secret["iamEndpoint"],
+		RequestTimeout:     requestTimeout,
+		DialTimeout:        dialTimeout,
+		MetadataName:       secret["metadataName"],
+		MetadataPrefix:     secret["metadataPrefix"],
+		DisableMetadata:    secret["disableMetadata"] == "true",
+		RegionDiscovery:    secret["regionDiscovery"] == "true",
+		AppName:            secret["appName"],
+		AppVersion:         secret["appVersion"],
+		AllowedBuckets:     allowedBuckets,
+		BucketCacheTTL:     bucketCacheTTL,
+		UseDualStack:       secret["useDualStack"] == "true",
+		FallbackEndpoints:  fallbackEndpoints,
+		ProxyURL:           secret["proxyURL"],
+		CustomHeaders:      customHeaders,
+		ListObjectsV1:      secret["listObjectsV1"] == "true",
+		ListMaxKeys:        listMaxKeys,
+		CannedACL:          secret["cannedACL"],
+		ResumableDelete:    secret["resumableDelete"] == "true",
+		RequesterPays:      secret["requesterPays"] == "true",
+		PartSize:           partSize << 20,
+		UploadConcurrency:  uint(uploadConcurrency),
+		SkipDeleteCheck:    secret["skipDeleteCheck"] == "true",
+		ForceDelete:        secret["forceDelete"] == "true",
+		DeleteMaxObjects:   deleteMaxObjects,
+		DeleteMaxBytes:     deleteMaxBytes,
+		ConfirmLargeDelete: secret["confirmLargeDelete"] == "true",
+		ReclaimPolicy:      secret["reclaimPolicy"],
+		RemoveScope:        secret["removeScope"],
+		LenientBucketNames: secret["lenientBucketNames"] == "true",
 	})
 }
 
 func (client *s3Client) BucketExists(bucketName string) (bool, error) {
-	return client.minio.BucketExists(client.ctx, bucketName)
+	if exists, ok := client.cachedBucketExists(bucketName); ok {
+		return exists, nil
+	}
+	var exists bool
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		exists, err = c.BucketExists(client.ctx, bucketName)
+		return err
+	})
+	if err == nil {
+		client.setCachedBucketExists(bucketName, exists)
+	}
+	return exists, err
 }
 
-func (client *s3Client) CreateBucket(bucketName string) error {
-	return client.minio.MakeBucket(client.ctx, bucketName, minio.MakeBucketOptions{Region: client.Config.Region})
+// CreateBucket creates a bucket and reports whether this call created it. A
+// bucket that already exists and is owned by the credentials, created by an
+// earlier attempt or a concurrent request, is no error but not created by
+// this call.
+func (client *s3Client) CreateBucket(bucketName string) (bool, error) {
+	if client.Config.Anonymous {
+		return false, fmt.Errorf("cannot create bucket %s: %w", bucketName, ErrAnonymousAccess)
+	}
+	if err := client.CheckBucketAllowed(bucketName); err != nil {
+		return false, fmt.Errorf("cannot create bucket: %w", err)
+	}
+	if err := CheckDirectoryBucket(bucketName); err != nil {
+		return false, err
+	}
+	if IsAccessPointAlias(bucketName) {
+		return false, fmt.Errorf("%w: %s is the alias of an access point, check that the access point exists", ErrBucketNotFound, bucketName)
+	}
+	if err := ValidateBucketName(bucketName, client.Config.LenientBucketNames); err != nil {
+		return false, err
+	}
+	ctx := client.ctx
+	if acl := client.bucketACL(); acl != "" {
+		// MakeBucketOptions has no ACL
+		ctx = withSignedHeaders(ctx, map[string]string{cannedACLHeader: acl})
+	}
+	region := client.bucketLocation()
+	if region != client.Config.SigningRegion {
+		ctx = withSigningRegion(ctx, client.Config.SigningRegion)
+	}
+	// The bucket has no region yet, so it's created through the default
+	// client
+	err := client.withRetry("", func(c *minio.Client) error {
+		return c.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: region})
+	})
+	client.forgetBucketExists(bucketName)
+	switch minio.ToErrorResponse(err).Code {
+	case "BucketAlreadyOwnedByYou":
+		// Created by an earlier attempt
+		client.log().V(4).Infof("Bucket %s already exists and is owned by us", bucketName)
+		return false, nil
+	case "BucketAlreadyExists":
+		return false, fmt.Errorf("%w: %s", ErrBucketOwnedByOther, bucketName)
+	}
+	return err == nil, err
+}
+
+// bucketLocation returns the location to create buckets in. minio sends no
+// LocationConstraint for us-east-1 only, which is also what AWS expects for
+// that region, so it stands for an unconstrained bucket. With an empty
+// location minio would use the signing region instead.
+func (client *s3Client) bucketLocation() string {
+	region := client.Config.Region
+	if region == "" || client.Config.NoLocationConstraint {
+		return "us-east-1"
+	}
+	return region
+}
+
+// WaitForBucket polls until a bucket is visible, for backends that don't
+// show a new bucket to all requests right after creating it
+func (client *s3Client) WaitForBucket(bucketName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(client.ctx, timeout)
+	defer cancel()
+	delay := 100 * time.Millisecond
+	for {
+		exists, err := client.bucketClient(bucketName).BucketExists(ctx, bucketName)
+		if err == nil && exists {
+			return nil
+		}
+		if err != nil {
+			client.log().V(4).Infof("Waiting for bucket %s: %v", bucketName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("bucket %s not visible after %v", bucketName, timeout)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > 2*time.Second {
+			delay = 2 * time.Second
+		}
+	}
 }
 
 func (client *s3Client) CreatePrefix(bucketName string, prefix string) error {
+	if client.Config.Anonymous {
+		return fmt.Errorf("cannot create prefix %s in bucket %s: %w", prefix, bucketName, ErrAnonymousAccess)
+	}
+	if err := ValidatePrefix(prefix); err != nil {
+		return err
+	}
 	if prefix != "" {
-		_, err := client.minio.PutObject(client.ctx, bucketName, prefix+"/", bytes.NewReader([]byte("")), 0, minio.PutObjectOptions{})
-		if err != nil {
+		if !client.Config.ReusePrefix {
+			empty, err := client.IsPrefixEmpty(bucketName, prefix)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return fmt.Errorf("%w: %s/%s", ErrPrefixNotEmpty, bucketName, prefix)
+			}
+		}
+		if client.Config.NoPlaceholder {
+			return nil
+		}
+		if _, err := client.putObject(client.ctx, bucketName, prefix+"/", []byte{}, client.objectOptions()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (client *s3Client) RemovePrefix(bucketName string, prefix string) error {
-	var err error
+// CheckWritable verifies that the credentials allow writing to a volume by
+// writing a small probe object into it and removing it again
+func (client *s3Client) CheckWritable(bucketName, prefix string) error {
+	key := path.Join(prefix, probePrefix+client.lockOwner())
+	err := client.PutObject(bucketName, key, []byte("probe"))
+	if err == nil {
+		err = client.removeObject(client.ctx, bucketName, key, minio.RemoveObjectOptions{})
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "AccessDenied" {
+			return fmt.Errorf("%w %s/%s, the credentials need s3:PutObject and s3:DeleteObject: %v", ErrNotWritable, bucketName, prefix, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// IsPrefixEmpty reports whether a prefix holds no user data, i.e. nothing
+// but the driver's own objects: the placeholder created by CreatePrefix, the
+// metadata object, the lock, write probes and delete checkpoints. A freshly
+// provisioned volume is empty.
+func (client *s3Client) IsPrefixEmpty(bucketName, prefix string) (bool, error) {
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	for object := range client.list(ctx, bucketName, client.listOptions(prefix+"/", true)) {
+		if object.Err != nil {
+			return false, object.Err
+		}
+		if !client.isDriverObject(prefix, object.Key) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isDriverObject reports whether key is one of the objects the driver keeps
+// in the volume at prefix
+func (client *s3Client) isDriverObject(prefix, key string) bool {
+	switch key {
+	case prefix + "/", client.metaKey(prefix), path.Join(prefix, lockName), path.Join(prefix, checkpointName):
+		return true
+	}
+	return strings.HasPrefix(key, path.Join(prefix, probePrefix))
+}
+
+// VolumeExists checks whether the volume at bucket/prefix exists and returns
+// its metadata if present. A missing bucket is reported as ErrBucketNotFound,
+// while a missing prefix in an existing bucket is reported as not existing.
+func (client *s3Client) VolumeExists(bucketName, prefix string) (bool, *FSMeta, error) {
+	bucketExists, err := client.BucketExists(bucketName)
+	if err != nil {
+		return false, nil, err
+	}
+	if !bucketExists {
+		return false, nil, fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+	exists := prefix == ""
+	if !exists {
+		ctx, cancel := context.WithCancel(client.ctx)
+		defer cancel()
+		opts := client.listOptions(prefix+"/", true)
+		opts.MaxKeys = 1
+		for object := range client.list(ctx, bucketName, opts) {
+			if object.Err != nil {
+				return false, nil, object.Err
+			}
+			exists = true
+			break
+		}
+	}
+	meta, err := client.ReadMeta(bucketName, prefix)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return exists, nil, nil
+		}
+		return false, nil, err
+	}
+	return true, meta, nil
+}
+
+// ListPrefixes returns the top-level prefixes of a bucket, i.e. the volumes
+// provisioned inside a shared bucket. Prefixes are returned without the
+// trailing slash, the same way they appear in volume IDs.
+func (client *s3Client) ListPrefixes(bucketName string) ([]string, error) {
+	prefixes := make([]string, 0)
+	for object := range client.list(client.ctx, bucketName, client.listOptions("", false)) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// With a delimiter, common prefixes (and prefix placeholder objects)
+		// are returned as keys ending with a slash
+		if strings.HasSuffix(object.Key, "/") && !client.isMetadataPrefix(object.Key) {
+			prefixes = append(prefixes, strings.TrimSuffix(object.Key, "/"))
+		}
+	}
+	return prefixes, nil
+}
 
-	if err = client.removeObjects(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+// ListVolumes returns the metadata of every prefix volume in a shared bucket.
+// Prefixes without a metadata object are still reported, with only the bucket
+// name and prefix filled in.
+func (client *s3Client) ListVolumes(bucketName string) ([]*FSMeta, error) {
+	prefixes, err := client.ListPrefixes(bucketName)
+	if err != nil {
+		return nil, err
 	}
+	volumes := make([]*FSMeta, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		meta, err := client.ReadMeta(bucketName, prefix)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+			meta = &FSMeta{BucketName: bucketName, Prefix: prefix}
+		}
+		volumes = append(volumes, meta)
+	}
+	return volumes, nil
+}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+// ReadMeta reads the metadata object of a volume
+func (client *s3Client) ReadMeta(bucketName, prefix string) (*FSMeta, error) {
+	if client.Config.DisableMetadata {
+		return nil, fmt.Errorf("%w: metadata is disabled", ErrNotFound)
+	}
+	obj, err := client.GetObject(bucketName, client.metaKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	var meta FSMeta
+	if err = decodeMeta(obj, &meta); err != nil {
+		return nil, fmt.Errorf("%w of %s/%s: %v", ErrInvalidMetadata, bucketName, prefix, err)
+	}
+	// GetObject has already fetched the object info
+	if info, err := obj.(*archivedReader).Stat(); err == nil {
+		meta.ETag = strings.Trim(info.ETag, "\"")
+	}
+	return &meta, nil
+}
+
+// CheckVolumeMeta verifies that the metadata found for a volume names the
+// same bucket and prefix, so that a mangled volume ID can't make the driver
+// remove the data of another volume. Volumes without metadata and missing
+// buckets pass, as there is nothing to compare.
+func (client *s3Client) CheckVolumeMeta(bucketName, prefix string) error {
+	meta, err := client.ReadMeta(bucketName, prefix)
+	if errors.Is(err, ErrNotFound) || isNoSuchBucket(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if meta.BucketName != bucketName || meta.Prefix != prefix {
+		return fmt.Errorf("%w: metadata of %s/%s is for %s/%s", ErrMetadataMismatch, bucketName, prefix, meta.BucketName, meta.Prefix)
+	}
+	return nil
+}
 
-	if err = client.removeObjectsOneByOne(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+// UpdateVolumeMounter changes the mounter and mount options stored in the
+// metadata of a volume, e.g. to migrate it from s3fs to geesefs. nil options
+// keep the current ones. The volume is locked while the metadata is rewritten.
+func (client *s3Client) UpdateVolumeMounter(bucketName, prefix, mounter string, options []string) error {
+	if !supportedMounters[mounter] {
+		return fmt.Errorf("%w: %q", ErrInvalidMounter, mounter)
+	}
+	if err := client.AcquireLock(bucketName, prefix, metadataLockTTL); err != nil {
+		return err
 	}
+	defer func() {
+		if err := client.ReleaseLock(bucketName, prefix); err != nil {
+			client.log().Warningf("Failed to release lock of %s/%s: %v", bucketName, prefix, err)
+		}
+	}()
+	return client.UpdateMeta(bucketName, prefix, func(meta *FSMeta) error {
+		meta.Mounter = mounter
+		if options != nil {
+			meta.MountOptions = options
+		}
+		return nil
+	})
+}
+
+// errMetaUnchanged stops UpdateMeta without writing the metadata
+var errMetaUnchanged = errors.New("metadata unchanged")
 
+// SetMountedWith records the mounter a volume was staged with in its
+// metadata, unless it's already recorded. Volumes without metadata are
+// skipped.
+func (client *s3Client) SetMountedWith(bucketName, prefix, mounter string) error {
+	if client.Config.Anonymous || client.Config.DisableMetadata {
+		return nil
+	}
+	err := client.UpdateMeta(bucketName, prefix, func(meta *FSMeta) error {
+		if meta.MountedWith == mounter {
+			return errMetaUnchanged
+		}
+		meta.MountedWith = mounter
+		return nil
+	})
+	if errors.Is(err, errMetaUnchanged) || errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	return err
 }
 
-func (client *s3Client) RemoveBucket(bucketName string) error {
+// WriteMeta stores the metadata of a volume. Metadata read with ReadMeta is
+// only written if the metadata object hasn't changed since, otherwise
+// WriteMeta fails with ErrMetadataConflict and the caller has to read it
+// again. Clear ETag to overwrite the object regardless.
+func (client *s3Client) WriteMeta(meta *FSMeta) error {
+	if client.Config.DisableMetadata {
+		return nil
+	}
+	ctx := client.ctx
+	if meta.ETag != "" {
+		ctx = withHeaders(ctx, map[string]string{"If-Match": meta.ETag})
+	}
+	etag, err := client.putMeta(ctx, meta)
+	if meta.ETag != "" && isConditionFailed(err) {
+		return fmt.Errorf("%w: %s/%s", ErrMetadataConflict, meta.BucketName, meta.Prefix)
+	}
+	if err != nil {
+		return err
+	}
+	// Further writes are conditional on this one
+	meta.ETag = etag
+	return nil
+}
+
+// UpdateMeta reads the metadata of a volume, changes it with update and
+// writes it back, starting over if it was changed by someone else in the
+// meantime. Errors of update are returned as they are.
+func (client *s3Client) UpdateMeta(bucketName, prefix string, update func(*FSMeta) error) error {
 	var err error
+	for attempt := 0; attempt < metaUpdateAttempts; attempt++ {
+		var meta *FSMeta
+		if meta, err = client.ReadMeta(bucketName, prefix); err != nil {
+			return err
+		}
+		if err = update(meta); err != nil {
+			return err
+		}
+		if err = client.WriteMeta(meta); !errors.Is(err, ErrMetadataConflict) {
+			return err
+		}
+		client.log().V(4).Infof("Metadata of %s/%s changed while updating it, retrying", bucketName, prefix)
+	}
+	return err
+}
+
+// isConditionFailed reports whether a conditional write failed because the
+// object has changed, or is gone when it had to exist
+func isConditionFailed(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "PreconditionFailed" || code == "NoSuchKey" || code == "ConditionalRequestConflict"
+}
+
+// EnsureMetadata writes the metadata of a volume unless it already has some,
+// e.g. when adopting a bucket or prefix created outside of the driver. It
+// returns whether it wrote the metadata. Existing metadata is looked for
+// first, as backends which ignore conditional writes would overwrite it.
+func (client *s3Client) EnsureMetadata(meta *FSMeta) (bool, error) {
+	if client.Config.DisableMetadata {
+		return false, nil
+	}
+	if client.Config.Anonymous {
+		return false, fmt.Errorf("cannot write metadata of %s/%s: %w", meta.BucketName, meta.Prefix, ErrAnonymousAccess)
+	}
+	_, err := client.StatObject(meta.BucketName, client.metaKey(meta.Prefix))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	etag, err := client.putMeta(withHeaders(client.ctx, map[string]string{"If-None-Match": "*"}), meta)
+	if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+		// Written by someone else in the meantime
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	meta.ETag = etag
+	client.log().Infof("Wrote missing metadata of %s/%s", meta.BucketName, meta.Prefix)
+	return true, nil
+}
+
+// putMeta writes the metadata object of a volume and returns the ETag the
+// backend assigned to it, which isn't the MD5 of the data for encrypted
+// objects
+func (client *s3Client) putMeta(ctx context.Context, meta *FSMeta) (string, error) {
+	data, contentType, err := encodeMeta(meta, client.Config.CompressMetadata)
+	if err != nil {
+		return "", err
+	}
+	opts := client.objectOptions()
+	opts.ContentType = contentType
+	info, err := client.putObject(ctx, meta.BucketName, client.metaKey(meta.Prefix), data, opts)
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
+}
+
+// metaKey returns the key of the metadata object of the volume at prefix
+func (client *s3Client) metaKey(prefix string) string {
+	name := client.Config.MetadataName
+	if name == "" {
+		name = metadataName
+	}
+	return path.Join(client.Config.MetadataPrefix, prefix, name)
+}
+
+// isMetadataPrefix reports whether a top-level prefix holds the metadata of
+// the volumes instead of being a volume itself
+func (client *s3Client) isMetadataPrefix(key string) bool {
+	if client.Config.MetadataPrefix == "" {
+		return false
+	}
+	top := strings.SplitN(strings.Trim(client.Config.MetadataPrefix, "/"), "/", 2)[0]
+	return strings.TrimSuffix(key, "/") == top
+}
 
-	if err = client.removeObjects(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+// removeDetachedMeta removes the metadata object of a volume if it is kept
+// outside of the volume prefix and thus not removed along with it
+func (client *s3Client) removeDetachedMeta(bucketName, prefix string) error {
+	if client.Config.MetadataPrefix == "" || client.Config.DisableMetadata {
+		return nil
+	}
+	return client.removeObject(client.ctx, bucketName, client.metaKey(prefix), minio.RemoveObjectOptions{})
+}
+
+// GetObject opens an object for reading. Unlike minio's GetObject it checks
+// that the object exists up front and returns ErrNotFound if it doesn't.
+// Reading an archived object which isn't restored fails with
+// ErrObjectArchived.
+func (client *s3Client) GetObject(bucketName, key string) (io.ReadCloser, error) {
+	var obj *minio.Object
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		if obj, err = c.GetObject(client.ctx, bucketName, key, minio.GetObjectOptions{}); err != nil {
+			return err
+		}
+		if _, err = obj.Stat(); err != nil {
+			obj.Close()
+		}
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, bucketName, key)
+		}
+		return nil, err
 	}
+	return &archivedReader{obj, bucketName, key}, nil
+}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+// ObjectInfo describes an object, as returned by StatObject
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	StorageClass string
+}
 
-	if err = client.removeObjectsOneByOne(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+// StatObject returns the size, ETag and modification time of an object
+// without reading it, or ErrNotFound if it doesn't exist
+func (client *s3Client) StatObject(bucketName, key string) (*ObjectInfo, error) {
+	var info minio.ObjectInfo
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		info, err = c.StatObject(client.ctx, bucketName, key, minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, bucketName, key)
+		}
+		return nil, err
 	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ETag:         strings.Trim(info.ETag, "\""),
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+		StorageClass: info.StorageClass,
+	}, nil
+}
 
+// PutObject writes a small object in a single request. Its MD5 is sent along,
+// so that the backend refuses it if it was corrupted on the way.
+func (client *s3Client) PutObject(bucketName, key string, data []byte) error {
+	_, err := client.putObject(client.ctx, bucketName, key, data, minio.PutObjectOptions{})
 	return err
 }
 
-func (client *s3Client) removeObjects(bucketName, prefix string) error {
-	objectsCh := make(chan minio.ObjectInfo)
-	var listErr error
+// objectOptions returns the options for the objects of a volume created by
+// the driver, i.e. the prefix placeholder and the metadata object
+func (client *s3Client) objectOptions() minio.PutObjectOptions {
+	metadata := make(map[string]string, len(client.Config.ObjectMetadata)+1)
+	for k, v := range client.Config.ObjectMetadata {
+		metadata[k] = v
+	}
+	if client.Config.CannedACL != "" {
+		// Sent as x-amz-acl header, not as metadata
+		metadata[cannedACLHeader] = client.Config.CannedACL
+	}
+	return minio.PutObjectOptions{
+		UserMetadata: metadata,
+		UserTags:     client.Config.ObjectTags,
+		ContentType:  client.Config.ObjectContentType,
+		CacheControl: client.Config.ObjectCacheControl,
+		StorageClass: client.Config.ObjectStorageClass,
+	}
+}
 
-	go func() {
-		defer close(objectsCh)
+// putObject writes a small object in a single request. ctx is client.ctx, or
+// one derived from it, e.g. with withHeaders for a conditional write. The
+// ETag isn't compared with the MD5 of the data, which it isn't for objects
+// encrypted with SSE-KMS or SSE-C, the Content-MD5 header has the backend
+// check the data instead.
+func (client *s3Client) putObject(ctx context.Context, bucketName, key string, data []byte, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	opts.SendContentMd5 = true
+	opts.DisableMultipart = true
+	var info minio.UploadInfo
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		info, err = c.PutObject(ctx, bucketName, key, bytes.NewReader(data), int64(len(data)), opts)
+		return err
+	})
+	return info, err
+}
 
-		for object := range client.minio.ListObjects(
-			client.ctx,
-			bucketName,
-			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
-			if object.Err != nil {
-				listErr = object.Err
-				return
-			}
-			objectsCh <- object
+// removeObject removes an object, failing over like putObject
+func (client *s3Client) removeObject(ctx context.Context, bucketName, key string, opts minio.RemoveObjectOptions) error {
+	return client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.RemoveObject(ctx, bucketName, key, opts)
+	})
+}
+
+// RemoveStats counts the objects, including versions, removed from a bucket
+// and their size. Failed counts the objects left behind, which are in neither
+// count. Failed is only non-zero without an error if the client is configured
+// with ForceDelete.
+type RemoveStats struct {
+	Objects int64
+	Bytes   int64
+	Failed  int64
+}
+
+func (s *RemoveStats) add(other RemoveStats) {
+	s.Objects += other.Objects
+	s.Bytes += other.Bytes
+	s.Failed += other.Failed
+}
+
+// ignoreRemoveErrors reports whether a failed removal of objects is to be
+// ignored because the client is configured with ForceDelete. Only failures of
+// individual objects are ignored, not e.g. a failed listing.
+func (client *s3Client) ignoreRemoveErrors(err error) bool {
+	return client.Config.ForceDelete && errors.Is(err, ErrObjectsNotRemoved)
+}
+
+func (client *s3Client) RemovePrefix(bucketName string, prefix string) (RemoveStats, error) {
+	var stats RemoveStats
+
+	if client.Config.Anonymous {
+		return stats, fmt.Errorf("cannot remove prefix %s from bucket %s: %w", prefix, bucketName, ErrAnonymousAccess)
+	}
+	if err := client.CheckBucketAllowed(bucketName); err != nil {
+		return stats, fmt.Errorf("cannot remove prefix %s: %w", prefix, err)
+	}
+	// An empty prefix would remove the whole bucket, use RemoveBucket for that
+	if prefix == "" {
+		return stats, fmt.Errorf("cannot remove empty prefix from bucket %s", bucketName)
+	}
+	if err := ValidatePrefix(prefix); err != nil {
+		return stats, err
+	}
+	if err := client.checkDeleteLimits(bucketName, prefix+"/"); err != nil {
+		return stats, err
+	}
+	done, err := client.begin("remove prefix " + prefix)
+	if err != nil {
+		return stats, err
+	}
+	defer done()
+
+	if err := client.removeIncompleteUploads(bucketName, prefix+"/"); err != nil {
+		client.log().Warningf("Failed to remove incomplete uploads of prefix %s: %v", prefix, err)
+	}
+	// List with the trailing slash so that the placeholder object is matched
+	// but other volumes sharing the name as a prefix, e.g. vol1 and vol10, are not
+	removed, err := client.removeObjects(bucketName, prefix+"/")
+	stats.add(removed)
+	if err == nil {
+		return stats, client.removePrefixRoot(bucketName, prefix)
+	}
+	if isNoSuchBucket(err) {
+		client.log().Warningf("Bucket %s of prefix %s does not exist, nothing to remove", bucketName, prefix)
+		return stats, nil
+	}
+
+	client.log().Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+
+	// The objects that failed are retried, only count them once
+	stats.Failed = 0
+	removed, err = client.removeObjectsOneByOne(bucketName, prefix+"/")
+	stats.add(removed)
+	if err == nil {
+		return stats, client.removePrefixRoot(bucketName, prefix)
+	}
+	if isNoSuchBucket(err) {
+		return stats, nil
+	}
+	if client.ignoreRemoveErrors(err) {
+		client.log().Warningf("Force-deleting prefix %s of bucket %s, leaving %d objects behind: %v", prefix, bucketName, stats.Failed, err)
+		return stats, client.removePrefixRoot(bucketName, prefix)
+	}
+
+	return stats, err
+}
+
+// removePrefixRoot removes the prefix object itself once its contents are
+// gone, both with and without the trailing slash. The placeholder written
+// by CreatePrefix is normally removed with the contents, but isn't listed by
+// all backends. The lock of the volume, kept while the contents are removed,
+// goes last.
+func (client *s3Client) removePrefixRoot(bucketName, prefix string) error {
+	for _, key := range []string{prefix + "/", prefix} {
+		err := client.removeObject(client.ctx, bucketName, key, minio.RemoveObjectOptions{})
+		if err != nil && !isNotFound(err) {
+			return err
 		}
-	}()
+	}
+	if err := client.removeDetachedMeta(bucketName, prefix); err != nil && !isNotFound(err) {
+		return err
+	}
+	return client.removeLock(bucketName, prefix)
+}
 
-	if listErr != nil {
-		glog.Error("Error listing objects", listErr)
-		return listErr
+func (client *s3Client) RemoveBucket(bucketName string) (RemoveStats, error) {
+	var stats RemoveStats
+
+	if client.Config.Anonymous {
+		return stats, fmt.Errorf("cannot remove bucket %s: %w", bucketName, ErrAnonymousAccess)
+	}
+	if err := client.CheckBucketAllowed(bucketName); err != nil {
+		return stats, fmt.Errorf("cannot remove bucket: %w", err)
+	}
+	if client.Config.ReclaimPolicy == ReclaimPrefixOnly {
+		return stats, fmt.Errorf("%w: %s, reclaimPolicy is %s", ErrBucketPreserved, bucketName, ReclaimPrefixOnly)
+	}
+	if err := client.checkDeleteLimits(bucketName, ""); err != nil {
+		return stats, err
+	}
+	done, err := client.begin("remove bucket " + bucketName)
+	if err != nil {
+		return stats, err
+	}
+	defer done()
+
+	if err := client.removeIncompleteUploads(bucketName, ""); err != nil {
+		client.log().Warningf("Failed to remove incomplete uploads of bucket %s: %v", bucketName, err)
+	}
+	removed, err := client.removeObjects(bucketName, "")
+	stats.add(removed)
+	if err == nil {
+		return stats, client.removeEmptyBucket(bucketName)
+	}
+	if isNoSuchBucket(err) {
+		client.log().Warningf("Bucket %s does not exist, nothing to remove", bucketName)
+		return stats, nil
 	}
 
-	select {
-	default:
-		opts := minio.RemoveObjectsOptions{
-			GovernanceBypass: true,
+	client.log().Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+
+	// The objects that failed are retried, only count them once
+	stats.Failed = 0
+	removed, err = client.removeObjectsOneByOne(bucketName, "")
+	stats.add(removed)
+	if err == nil {
+		return stats, client.removeEmptyBucket(bucketName)
+	}
+	if isNoSuchBucket(err) {
+		return stats, nil
+	}
+	if client.ignoreRemoveErrors(err) {
+		client.log().Warningf("Force-deleting bucket %s, leaving %d objects behind: %v", bucketName, stats.Failed, err)
+		// Fails as long as objects are left, but the volume is gone anyway
+		if err := client.removeEmptyBucket(bucketName); err != nil {
+			client.log().Warningf("Failed to remove bucket %s: %v", bucketName, err)
 		}
-		errorCh := client.minio.RemoveObjects(client.ctx, bucketName, objectsCh, opts)
-		haveErrWhenRemoveObjects := false
-		for e := range errorCh {
-			glog.Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
-			haveErrWhenRemoveObjects = true
+		return stats, nil
+	}
+
+	return stats, err
+}
+
+// removeEmptyBucket removes a bucket once its contents are gone
+func (client *s3Client) removeEmptyBucket(bucketName string) error {
+	err := client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.RemoveBucket(client.ctx, bucketName)
+	})
+	client.forgetBucketExists(bucketName)
+	if isNoSuchBucket(err) {
+		return nil
+	}
+	return err
+}
+
+// isNoSuchBucket reports whether err means that the bucket doesn't exist,
+// which the remove paths treat as success so deleting a volume is idempotent
+func isNoSuchBucket(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchBucket"
+}
+
+// isNotFound reports whether err means that the bucket or object doesn't exist
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return err != nil && (code == "NoSuchBucket" || code == "NoSuchKey")
+}
+
+// listOptions returns the options to list the objects under prefix with
+func (client *s3Client) listOptions(prefix string, recursive bool) minio.ListObjectsOptions {
+	return minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: recursive,
+		UseV1:     client.useListV1(),
+		MaxKeys:   client.Config.ListMaxKeys,
+	}
+}
+
+// useListV1 reports whether objects are listed with the V1 API, because it is
+// configured or the backend doesn't implement ListObjectsV2
+func (client *s3Client) useListV1() bool {
+	return client.Config.ListObjectsV1 || atomic.LoadInt32(&client.listV1) == 1
+}
+
+// list wraps minio's ListObjects, falling back to the V1 API if the backend
+// doesn't implement ListObjectsV2. If the endpoint is down, the listing is
+// started again on the fallback endpoints, but only before the first object:
+// a listing that fails later ends with the error, as it can't be resumed on
+// another endpoint.
+func (client *s3Client) list(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		endpoint := client.endpoint()
+		listCh := client.bucketClient(bucketName).ListObjects(ctx, bucketName, opts)
+		object, ok := <-listCh
+		if !opts.UseV1 && ok && object.Err != nil && minio.ToErrorResponse(object.Err).Code == "NotImplemented" {
+			client.log().Warningf("ListObjectsV2 is not implemented by %s, falling back to ListObjects V1", endpoint)
+			atomic.StoreInt32(&client.listV1, 1)
+			opts.UseV1 = true
+			listCh = client.bucketClient(bucketName).ListObjects(ctx, bucketName, opts)
+			object, ok = <-listCh
 		}
-		if haveErrWhenRemoveObjects {
-			return fmt.Errorf("Failed to remove all objects of bucket %s", bucketName)
+		for i := 0; ok && i < len(client.Config.FallbackEndpoints) && client.failover(endpoint, object.Err); i++ {
+			endpoint = client.endpoint()
+			listCh = client.bucketClient(bucketName).ListObjects(ctx, bucketName, opts)
+			object, ok = <-listCh
 		}
-	}
+		for ; ok; object, ok = <-listCh {
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return objectsCh
+}
 
-	return nil
+// removeListOptions returns the options to list the objects to remove under
+// prefix with. In versioned buckets, all versions have to be removed.
+func (client *s3Client) removeListOptions(bucketName, prefix string) minio.ListObjectsOptions {
+	opts := client.listOptions(prefix, true)
+	opts.WithVersions = client.hasVersions(bucketName)
+	return opts
 }
 
-// will delete files one by one without file lock
-func (client *s3Client) removeObjectsOneByOne(bucketName, prefix string) error {
-	parallelism := 16
-	objectsCh := make(chan minio.ObjectInfo, 1)
-	guardCh := make(chan int, parallelism)
+// listObjects streams the objects listed with opts into the returned channel,
+// which is closed when the listing ends or ctx is cancelled. The returned
+// function waits for the listing to end and returns its error, so it must be
+// called after draining the channel or cancelling ctx.
+func (client *s3Client) listObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) (<-chan minio.ObjectInfo, func() error) {
+	objectsCh := make(chan minio.ObjectInfo)
+	doneCh := make(chan struct{})
 	var listErr error
-	totalObjects := 0
-	removeErrors := 0
 
 	go func() {
+		defer close(doneCh)
 		defer close(objectsCh)
 
-		for object := range client.minio.ListObjects(client.ctx, bucketName,
-			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		for object := range client.list(ctx, bucketName, opts) {
 			if object.Err != nil {
+				client.learnRegion(bucketName, object.Err)
 				listErr = object.Err
 				return
 			}
-			totalObjects++
-			objectsCh <- object
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+				listErr = ctx.Err()
+				return
+			}
 		}
 	}()
 
-	if listErr != nil {
-		glog.Error("Error listing objects", listErr)
+	return objectsCh, func() error {
+		<-doneCh
 		return listErr
 	}
+}
+
+func (client *s3Client) removeObjects(bucketName, prefix string) (RemoveStats, error) {
+	listOpts := client.removeListOptions(bucketName, prefix)
+	if client.Config.ResumableDelete && !listOpts.WithVersions {
+		return client.removeObjectsResumable(bucketName, prefix)
+	}
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	listedCh, listResult := client.newRemoveFilter(bucketName, prefix).list(ctx, listOpts)
+
+	var stats RemoveStats
+	// Throttled objects are retried after the others, with a backoff
+	var throttled []minio.ObjectInfo
+	batch := make([]minio.ObjectInfo, 0, maxRemoveBatch)
+	removeBatch := func() {
+		removed, retry := client.removeBatch(bucketName, batch)
+		stats.add(removed)
+		throttled = append(throttled, retry...)
+		batch = batch[:0]
+	}
+	for object := range listedCh {
+		if batch = append(batch, object); len(batch) == maxRemoveBatch {
+			removeBatch()
+		}
+	}
+	if len(batch) > 0 {
+		removeBatch()
+	}
+	if listErr := listResult(); listErr != nil {
+		client.log().Errorf("Error listing objects: %v", listErr)
+		return stats, listErr
+	}
+	stats.add(client.retryThrottled(bucketName, throttled))
+	if stats.Failed > 0 {
+		return stats, fmt.Errorf("%w: %d of bucket %s", ErrObjectsNotRemoved, stats.Failed, bucketName)
+	}
+
+	return stats, nil
+}
+
+// will delete files one by one without file lock
+func (client *s3Client) removeObjectsOneByOne(bucketName, prefix string) (RemoveStats, error) {
+	parallelism := 16
+	guardCh := make(chan int, parallelism)
+	var wg sync.WaitGroup
+	var totalObjects, removeErrors int64
+	var stats RemoveStats
+
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	objectsCh, listResult := client.newRemoveFilter(bucketName, prefix).list(ctx, client.removeListOptions(bucketName, prefix))
 
 	for object := range objectsCh {
+		totalObjects++
 		guardCh <- 1
-		go func() {
-			err := client.minio.RemoveObject(client.ctx, bucketName, object.Key,
+		wg.Add(1)
+		go func(object minio.ObjectInfo) {
+			defer wg.Done()
+			err := client.removeObject(ctx, bucketName, object.Key,
 				minio.RemoveObjectOptions{VersionID: object.VersionID})
 			if err != nil {
-				glog.Errorf("Failed to remove object %s, error: %s", object.Key, err)
-				removeErrors++
+				client.log().Errorf("Failed to remove object %s, error: %s", object.Key, err)
+				atomic.AddInt64(&removeErrors, 1)
+			} else {
+				atomic.AddInt64(&stats.Objects, 1)
+				atomic.AddInt64(&stats.Bytes, object.Size)
 			}
-			<- guardCh
-		}()
-	}
-	for i := 0; i < parallelism; i++ {
-		guardCh <- 1
-	}
-	for i := 0; i < parallelism; i++ {
-		<- guardCh
+			<-guardCh
+		}(object)
 	}
+	wg.Wait()
 
+	if listErr := listResult(); listErr != nil {
+		client.log().Errorf("Error listing objects: %v", listErr)
+		return stats, listErr
+	}
+	stats.Failed = removeErrors
 	if removeErrors > 0 {
-		return fmt.Errorf("Failed to remove %v objects out of total %v of path %s", removeErrors, totalObjects, bucketName)
+		return stats, fmt.Errorf("%w: %v out of total %v of path %s", ErrObjectsNotRemoved, removeErrors, totalObjects, bucketName)
 	}
 
-	return nil
+	return stats, nil
 }
@@ -0,0 +1,1773 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestCreatePrefix(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() error = %v", err)
+	}
+	if got, want := fake.keys("bucket"), []string{"volume/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	// Creating it again is fine as long as it holds no data
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() again error = %v", err)
+	}
+
+	fake.put("bucket", "volume/file", "data")
+	if err := client.CreatePrefix("bucket", "volume"); !errors.Is(err, ErrPrefixNotEmpty) {
+		t.Errorf("CreatePrefix() on prefix with data error = %v, want ErrPrefixNotEmpty", err)
+	}
+	client.Config.ReusePrefix = true
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Errorf("CreatePrefix() with ReusePrefix error = %v", err)
+	}
+}
+
+func TestReadWriteMeta(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+
+	if _, err := client.ReadMeta("bucket", "volume"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReadMeta() of missing metadata error = %v, want ErrNotFound", err)
+	}
+	meta := &FSMeta{
+		BucketName:    "bucket",
+		Prefix:        "volume",
+		Mounter:       "geesefs",
+		MountOptions:  []string{"--memory-limit", "1000"},
+		CapacityBytes: 1 << 30,
+
+		UploadBandwidthLimit:   10 << 20,
+		DownloadBandwidthLimit: 50 << 20,
+	}
+	if err := client.WriteMeta(meta); err != nil {
+		t.Fatalf("WriteMeta() error = %v", err)
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatalf("ReadMeta() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("ReadMeta() = %+v, want %+v", got, meta)
+	}
+}
+
+func TestCompressMetadata(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.CompressMetadata = true
+
+	meta := &FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "geesefs", MountOptions: []string{"--memory-limit", "1000"}}
+	if err := client.WriteMeta(meta); err != nil {
+		t.Fatalf("WriteMeta() error = %v", err)
+	}
+	obj := fake.buckets["bucket"][client.metaKey("volume")]
+	if !bytes.HasPrefix(obj.data, gzipMagic) {
+		t.Errorf("compressed metadata starts with %q, want gzip magic", obj.data[:2])
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatalf("ReadMeta() of compressed metadata error = %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("ReadMeta() = %+v, want %+v", got, meta)
+	}
+
+	// Metadata written before stays readable
+	fake.put("bucket", client.metaKey("plain"), `{"BucketName":"bucket","Prefix":"plain","Mounter":"s3fs"}`)
+	if got, err = client.ReadMeta("bucket", "plain"); err != nil || got.Mounter != "s3fs" {
+		t.Errorf("ReadMeta() of plain metadata = %+v, %v", got, err)
+	}
+
+	huge := &FSMeta{BucketName: "bucket", Prefix: "huge", MountOptions: make([]string, maxMetadataSize/2)}
+	if err = ValidateMeta(huge); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Errorf("ValidateMeta() of huge metadata error = %v, want ErrMetadataTooLarge", err)
+	}
+	if err = client.WriteMeta(huge); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Errorf("WriteMeta() of huge metadata error = %v, want ErrMetadataTooLarge", err)
+	}
+	if _, ok := fake.buckets["bucket"][client.metaKey("huge")]; ok {
+		t.Error("huge metadata was written")
+	}
+	fake.put("bucket", client.metaKey("huge"), `{"MountOptions":["`+strings.Repeat("x", maxMetadataSize)+`"]}`)
+	if _, err = client.ReadMeta("bucket", "huge"); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("ReadMeta() of huge metadata error = %v, want ErrInvalidMetadata", err)
+	}
+}
+
+func TestEnsureMetadata(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "adopted/file", "data")
+
+	meta := &FSMeta{BucketName: "bucket", Prefix: "adopted", Mounter: "geesefs"}
+	created, err := client.EnsureMetadata(meta)
+	if err != nil || !created {
+		t.Fatalf("EnsureMetadata() = %v, %v, want created", created, err)
+	}
+	created, err = client.EnsureMetadata(&FSMeta{BucketName: "bucket", Prefix: "adopted", Mounter: "rclone"})
+	if err != nil || created {
+		t.Fatalf("EnsureMetadata() of existing metadata = %v, %v, want found", created, err)
+	}
+	got, err := client.ReadMeta("bucket", "adopted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("ReadMeta() = %+v, want the first metadata %+v", got, meta)
+	}
+}
+
+func TestWriteMetaConflict(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "s3fs"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.CapacityBytes = 1 << 30
+	if err = client.WriteMeta(first); err != nil {
+		t.Fatalf("WriteMeta() error = %v", err)
+	}
+	// Writes of the same reader stay conditional on its last write
+	first.CapacityBytes = 2 << 30
+	if err = client.WriteMeta(first); err != nil {
+		t.Fatalf("WriteMeta() again error = %v", err)
+	}
+	second.Mounter = "geesefs"
+	if err = client.WriteMeta(second); !errors.Is(err, ErrMetadataConflict) {
+		t.Fatalf("WriteMeta() of stale metadata error = %v, want ErrMetadataConflict", err)
+	}
+
+	// UpdateMeta starts from the current metadata
+	err = client.UpdateMeta("bucket", "volume", func(meta *FSMeta) error {
+		meta.Mounter = "geesefs"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateMeta() error = %v", err)
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mounter != "geesefs" || got.CapacityBytes != 2<<30 {
+		t.Errorf("metadata = %+v, want both updates", got)
+	}
+}
+
+func TestEncryptedObjects(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	// ETags of encrypted objects aren't the MD5 of their data
+	fake.encrypted = true
+
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() error = %v", err)
+	}
+	meta := &FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "geesefs"}
+	if err := client.WriteMeta(meta); err != nil {
+		t.Fatalf("WriteMeta() error = %v", err)
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mounter != meta.Mounter || got.ETag != meta.ETag {
+		t.Errorf("ReadMeta() = %+v, want %+v", got, meta)
+	}
+
+	// Writes conditional on the ETag of the last write succeed
+	meta.CapacityBytes = 1 << 30
+	if err = client.WriteMeta(meta); err != nil {
+		t.Fatalf("WriteMeta() again error = %v", err)
+	}
+	if err = client.UpdateMeta("bucket", "volume", func(meta *FSMeta) error {
+		meta.Mounter = "rclone"
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateMeta() error = %v", err)
+	}
+	if got, err = client.ReadMeta("bucket", "volume"); err != nil || got.Mounter != "rclone" || got.CapacityBytes != 1<<30 {
+		t.Errorf("ReadMeta() after updates = %+v, %v", got, err)
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "a/", "")
+	fake.put("bucket", "b/file", "data")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "a", Mounter: "s3fs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := client.ListVolumes("bucket")
+	if err != nil {
+		t.Fatalf("ListVolumes() error = %v", err)
+	}
+	want := []*FSMeta{
+		{BucketName: "bucket", Prefix: "a", Mounter: "s3fs"},
+		{BucketName: "bucket", Prefix: "b"},
+	}
+	for _, volume := range volumes {
+		volume.ETag = ""
+	}
+	if !reflect.DeepEqual(volumes, want) {
+		t.Errorf("ListVolumes() = %+v, want %+v", volumes, want)
+	}
+}
+
+func TestRemovePrefix(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol1/", "")
+	fake.put("bucket", "vol1/file", "data")
+	fake.put("bucket", "vol1/dir/file", "data")
+	fake.put("bucket", "vol10/", "")
+	fake.put("bucket", "vol10/file", "data")
+
+	stats, err := client.RemovePrefix("bucket", "vol1")
+	if err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	if want := (RemoveStats{Objects: 3, Bytes: 8}); stats != want {
+		t.Errorf("RemovePrefix() = %+v, want %+v", stats, want)
+	}
+	if got, want := fake.keys("bucket"), []string{"vol10/", "vol10/file"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	if _, err := client.RemovePrefix("bucket", ""); err == nil {
+		t.Errorf("RemovePrefix() with empty prefix succeeded")
+	}
+	if got := fake.keys("bucket"); len(got) != 2 {
+		t.Errorf("RemovePrefix() with empty prefix removed objects, left %v", got)
+	}
+}
+
+func TestRemoveScope(t *testing.T) {
+	for _, tc := range []struct {
+		scope     string
+		resumable bool
+		want      []string
+	}{
+		{RemoveAll, false, []string{}},
+		{RemoveVolume, false, []string{"parent/child/", "parent/child/.metadata.json", "parent/child/file", "parent/child/sub/file"}},
+		{RemoveVolume, true, []string{"parent/child/", "parent/child/.metadata.json", "parent/child/file", "parent/child/sub/file"}},
+		{RemoveDirect, false, []string{"parent/child/", "parent/child/.metadata.json", "parent/child/file", "parent/child/sub/file", "parent/dir/file"}},
+	} {
+		t.Run(fmt.Sprintf("%s resumable=%v", tc.scope, tc.resumable), func(t *testing.T) {
+			client, fake := newTestClient(t, "bucket")
+			client.Config.RemoveScope = tc.scope
+			client.Config.ResumableDelete = tc.resumable
+			client.Config.ListMaxKeys = 2
+			for _, key := range []string{"parent/", "parent/file", "parent/dir/file", "parent/child/", "parent/child/file", "parent/child/sub/file"} {
+				fake.put("bucket", key, "data")
+			}
+			fake.put("bucket", "parent/child/.metadata.json", `{"BucketName":"bucket","Prefix":"parent/child"}`)
+			fake.addUpload("bucket", "parent/child/part", "child-upload")
+
+			if _, err := client.RemovePrefix("bucket", "parent"); err != nil {
+				t.Fatalf("RemovePrefix() error = %v", err)
+			}
+			if got := fake.keys("bucket"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("keys = %v, want %v", got, tc.want)
+			}
+			if _, kept := fake.uploads["bucket"]["child-upload"]; kept != (tc.scope != RemoveAll) {
+				t.Errorf("upload of nested volume kept = %v", kept)
+			}
+		})
+	}
+
+	if err := ValidateRemoveScope("children"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ValidateRemoveScope() error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestRemovePrefixPlaceholder(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	if err := client.CreatePrefix("bucket", "empty"); err != nil {
+		t.Fatal(err)
+	}
+	fake.put("bucket", "empty", "")
+
+	if _, err := client.RemovePrefix("bucket", "empty"); err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys = %v, want none", got)
+	}
+}
+
+func TestNoPlaceholder(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.NoPlaceholder = true
+
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys = %v, want none", got)
+	}
+	fake.put("bucket", "volume/file", "data")
+	if exists, _, err := client.VolumeExists("bucket", "volume"); err != nil || !exists {
+		t.Errorf("VolumeExists() = %v, %v, want true", exists, err)
+	}
+
+	// Placeholders of volumes created before are still removed
+	fake.put("bucket", "volume/", "")
+	if _, err := client.RemovePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys = %v, want none", got)
+	}
+}
+
+func TestStatObject(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	if err := client.PutObject("bucket", "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := client.StatObject("bucket", "key")
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	want := &ObjectInfo{Key: "key", Size: 4, ETag: "8d777f385d3dfec8815d20f7496026dc",
+		LastModified: time.Unix(0, 0).UTC(), ContentType: "application/octet-stream"}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("StatObject() = %+v, want %+v", info, want)
+	}
+	if _, err = client.StatObject("bucket", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StatObject() of missing object error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+
+	if err := client.CheckWritable("bucket", "volume"); err != nil {
+		t.Fatalf("CheckWritable() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("CheckWritable() left objects %v", got)
+	}
+}
+
+func TestRemoveMissingBucket(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	if _, err := client.RemoveBucket("missing"); err != nil {
+		t.Errorf("RemoveBucket() of missing bucket error = %v", err)
+	}
+	if _, err := client.RemovePrefix("missing", "volume"); err != nil {
+		t.Errorf("RemovePrefix() in missing bucket error = %v", err)
+	}
+}
+
+func TestDeleteLimits(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	for _, key := range []string{"vol/a", "vol/b", "vol/c", "other/a"} {
+		fake.put("bucket", key, "data")
+	}
+	client.Config.DeleteMaxObjects = 2
+
+	if _, err := client.RemovePrefix("bucket", "vol"); !errors.Is(err, ErrDeleteTooLarge) {
+		t.Fatalf("RemovePrefix() above the limit error = %v, want ErrDeleteTooLarge", err)
+	}
+	if _, err := client.RemoveBucket("bucket"); !errors.Is(err, ErrDeleteTooLarge) {
+		t.Fatalf("RemoveBucket() above the limit error = %v, want ErrDeleteTooLarge", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 4 {
+		t.Errorf("keys = %v, want all kept", got)
+	}
+	if _, err := client.RemovePrefix("bucket", "other"); err != nil {
+		t.Errorf("RemovePrefix() below the limit error = %v", err)
+	}
+
+	client.Config.DeleteMaxObjects = 0
+	client.Config.DeleteMaxBytes = 10
+	if _, err := client.RemovePrefix("bucket", "vol"); !errors.Is(err, ErrDeleteTooLarge) {
+		t.Fatalf("RemovePrefix() above the byte limit error = %v, want ErrDeleteTooLarge", err)
+	}
+	client.Config.ConfirmLargeDelete = true
+	if _, err := client.RemovePrefix("bucket", "vol"); err != nil {
+		t.Fatalf("RemovePrefix() confirmed error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys = %v, want none", got)
+	}
+}
+
+func TestReclaimPolicy(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/file", "data")
+	fake.put("bucket", "other/file", "data")
+	client.Config.ReclaimPolicy = ReclaimPrefixOnly
+
+	if _, err := client.RemoveBucket("bucket"); !errors.Is(err, ErrBucketPreserved) {
+		t.Fatalf("RemoveBucket() with prefixOnly error = %v, want ErrBucketPreserved", err)
+	}
+	if _, err := client.RemovePrefix("bucket", "vol"); err != nil {
+		t.Fatalf("RemovePrefix() with prefixOnly error = %v", err)
+	}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, []string{"other/file"}) {
+		t.Errorf("keys = %v, want only the other volume", got)
+	}
+
+	client.Config.ReclaimPolicy = ReclaimBucket
+	if _, err := client.RemoveBucket("bucket"); err != nil {
+		t.Errorf("RemoveBucket() with bucket policy error = %v", err)
+	}
+	if err := ValidateReclaimPolicy("delete"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ValidateReclaimPolicy() of unknown policy error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestForceDelete(t *testing.T) {
+	client, fake := newTestClient(t, "bucket", "held")
+	fake.denyDeletes = ".held"
+	fake.put("bucket", "vol/", "")
+	fake.put("bucket", "vol/file", "data")
+	fake.put("bucket", "vol/file.held", "data")
+	fake.put("held", "file.held", "data")
+
+	_, err := client.RemovePrefix("bucket", "vol")
+	if !errors.Is(err, ErrObjectsNotRemoved) {
+		t.Fatalf("RemovePrefix() error = %v, want %v", err, ErrObjectsNotRemoved)
+	}
+
+	client.Config.ForceDelete = true
+	stats, err := client.RemovePrefix("bucket", "vol")
+	if err != nil {
+		t.Fatalf("RemovePrefix() with ForceDelete error = %v", err)
+	}
+	if stats.Failed != 1 {
+		t.Errorf("RemovePrefix() failed = %d, want 1", stats.Failed)
+	}
+	if got, want := fake.keys("bucket"), []string{"vol/file.held"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	stats, err = client.RemoveBucket("held")
+	if err != nil {
+		t.Fatalf("RemoveBucket() with ForceDelete error = %v", err)
+	}
+	if stats.Failed != 1 {
+		t.Errorf("RemoveBucket() failed = %d, want 1", stats.Failed)
+	}
+}
+
+func TestListObjectsV1Fallback(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/file", "data")
+	fake.rejectListV2 = true
+
+	stats, err := client.PrefixStats("bucket", "vol")
+	if err != nil {
+		t.Fatalf("PrefixStats() error = %v", err)
+	}
+	if stats.Objects != 1 {
+		t.Errorf("PrefixStats().Objects = %d, want 1", stats.Objects)
+	}
+	if !client.useListV1() {
+		t.Errorf("client did not switch to ListObjects V1")
+	}
+	if client.Config.ListObjectsV1 {
+		t.Errorf("switching to ListObjects V1 changed the shared Config")
+	}
+
+	// Listings running while the client switches don't race on it
+	client, fake = newTestClient(t, "bucket")
+	fake.put("bucket", "vol/file", "data")
+	fake.rejectListV2 = true
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.PrefixStats("bucket", "vol"); err != nil {
+				t.Errorf("concurrent PrefixStats() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPrefixStatsParallel(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/", "")
+	fake.put("bucket", "vol/top", "1")
+	fake.put("bucket", "vol/a/", "")
+	for i := 0; i < 20; i++ {
+		fake.put("bucket", fmt.Sprintf("vol/a/%d/file", i), "22")
+		fake.put("bucket", fmt.Sprintf("vol/b/%d", i), "333")
+	}
+	fake.put("bucket", "vol10/file", "excluded")
+
+	stats, err := client.PrefixStats("bucket", "vol")
+	if err != nil {
+		t.Fatalf("PrefixStats() error = %v", err)
+	}
+	if stats.Objects != 43 || stats.Bytes != 101 {
+		t.Errorf("PrefixStats() = %d objects, %d bytes, want 43 objects, 101 bytes", stats.Objects, stats.Bytes)
+	}
+	if stats.Histogram[0].Objects != 43 {
+		t.Errorf("PrefixStats().Histogram[0] = %+v, want 43 objects", stats.Histogram[0])
+	}
+	if usage, err := client.GetBucketUsage("bucket", ""); err != nil || usage != 109 {
+		t.Errorf("GetBucketUsage() of bucket = %d, %v, want 109", usage, err)
+	}
+}
+
+func TestBucketVersioning(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+
+	if err := client.SetBucketVersioning("bucket", true); err != nil {
+		t.Fatalf("SetBucketVersioning() error = %v", err)
+	}
+	enabled, err := client.GetBucketVersioning("bucket")
+	if err != nil || !enabled {
+		t.Errorf("GetBucketVersioning() = %v, %v, want true", enabled, err)
+	}
+	if err := client.SetBucketVersioning("bucket", false); err != nil {
+		t.Fatalf("SetBucketVersioning() error = %v", err)
+	}
+	if enabled, _ = client.GetBucketVersioning("bucket"); enabled {
+		t.Errorf("GetBucketVersioning() after suspending = true")
+	}
+	if !client.hasVersions("bucket") {
+		t.Errorf("hasVersions() of suspended bucket = false")
+	}
+}
+
+func TestRemovePrefixResumable(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.ResumableDelete = true
+	client.Config.ListMaxKeys = 2
+	fake.put("bucket", "vol/", "")
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		fake.put("bucket", "vol/"+key, "data")
+	}
+	// Left by an interrupted removal that got up to b
+	fake.put("bucket", "vol/"+checkpointName, `{"Marker":"vol/b"}`)
+	fake.put("bucket", "other/file", "data")
+
+	if _, err := client.RemovePrefix("bucket", "vol"); err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	// Objects before the marker are considered removed already, the
+	// placeholder is removed in any case
+	want := []string{"other/file", "vol/a", "vol/b"}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestObjectOptions(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.ObjectMetadata = map[string]string{"team": "data"}
+	client.Config.ObjectCacheControl = "no-cache"
+	client.Config.ObjectStorageClass = "STANDARD_IA"
+
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() error = %v", err)
+	}
+	header := fake.buckets["bucket"]["volume/"].header
+	if got := header.Get("X-Amz-Meta-Team"); got != "data" {
+		t.Errorf("x-amz-meta-team = %q, want %q", got, "data")
+	}
+	if got := header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want %q", got, "no-cache")
+	}
+	if got := header.Get("X-Amz-Storage-Class"); got != "STANDARD_IA" {
+		t.Errorf("x-amz-storage-class = %q, want %q", got, "STANDARD_IA")
+	}
+}
+
+func TestUpdateVolumeMounter(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	meta := &FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "s3fs", MountOptions: []string{"-o", "ro"}}
+	if err := client.WriteMeta(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.UpdateVolumeMounter("bucket", "volume", "fuse", nil); !errors.Is(err, ErrInvalidMounter) {
+		t.Errorf("UpdateVolumeMounter() with unknown mounter error = %v, want ErrInvalidMounter", err)
+	}
+	if err := client.UpdateVolumeMounter("bucket", "volume", "geesefs", []string{"--memory-limit", "1000"}); err != nil {
+		t.Fatalf("UpdateVolumeMounter() error = %v", err)
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "geesefs", MountOptions: []string{"--memory-limit", "1000"}}
+	got.ETag = ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %+v, want %+v", got, want)
+	}
+	if keys := fake.keys("bucket"); !reflect.DeepEqual(keys, []string{"volume/.metadata.json"}) {
+		t.Errorf("keys = %v, lock not released", keys)
+	}
+}
+
+func TestSetMountedWith(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	if err := client.SetMountedWith("bucket", "missing", "s3fs"); err != nil {
+		t.Errorf("SetMountedWith() of volume without metadata error = %v", err)
+	}
+	meta := &FSMeta{BucketName: "bucket", Prefix: "volume", Mounter: "geesefs", MounterFallback: []string{"s3fs"}}
+	if err := client.WriteMeta(meta); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.SetMountedWith("bucket", "volume", "s3fs"); err != nil {
+			t.Fatalf("SetMountedWith() error = %v", err)
+		}
+	}
+	got, err := client.ReadMeta("bucket", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MountedWith != "s3fs" || got.Mounter != "geesefs" || !reflect.DeepEqual(got.MounterFallback, []string{"s3fs"}) {
+		t.Errorf("metadata = %+v, want s3fs recorded", got)
+	}
+
+	if _, err := ParseMounters("s3fs, rclone,fuse"); !errors.Is(err, ErrInvalidMounter) {
+		t.Errorf("ParseMounters() with unknown mounter error = %v, want ErrInvalidMounter", err)
+	}
+}
+
+func TestCredentialProvider(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	calls := 0
+	client.Config.CredentialProvider = func() (credentials.Value, error) {
+		calls++
+		return credentials.Value{AccessKeyID: "key", SecretAccessKey: "secret", SignerType: credentials.SignatureV4}, nil
+	}
+	if err := client.UpdateCredentials(client.Config); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.BucketExists("bucket"); err != nil {
+			t.Fatalf("BucketExists() error = %v", err)
+		}
+	}
+	if calls < 2 {
+		t.Errorf("credential provider called %d times, want at least once per request", calls)
+	}
+}
+
+func TestUpdateEndpoint(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	plain := client.Config.Endpoint
+	secure := strings.Replace(plain, "http://", "https://", 1)
+
+	if err := client.UpdateEndpoint(plain + ":99999"); err == nil {
+		t.Error("UpdateEndpoint() with invalid port succeeded")
+	}
+	if client.Config.Endpoint != plain {
+		t.Errorf("endpoint after failed update = %s, want %s", client.Config.Endpoint, plain)
+	}
+
+	// The test server doesn't speak TLS
+	if err := client.UpdateEndpoint(secure); err != nil {
+		t.Fatalf("UpdateEndpoint(%s) error = %v", secure, err)
+	}
+	if client.Config.Endpoint != secure || client.primaryEndpoint != secure {
+		t.Errorf("endpoint = %s, primary %s, want %s", client.Config.Endpoint, client.primaryEndpoint, secure)
+	}
+	if _, err := client.BucketExists("bucket"); err == nil {
+		t.Error("BucketExists() over TLS to plain server succeeded")
+	}
+
+	if err := client.UpdateEndpoint(plain); err != nil {
+		t.Fatalf("UpdateEndpoint(%s) error = %v", plain, err)
+	}
+	if exists, err := client.BucketExists("bucket"); err != nil || !exists {
+		t.Errorf("BucketExists() = %v, %v, want true", exists, err)
+	}
+}
+
+func TestCannedACL(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.CannedACL = "bucket-owner-full-control"
+
+	if err := client.CreatePrefix("bucket", "volume"); err != nil {
+		t.Fatalf("CreatePrefix() error = %v", err)
+	}
+	if got := fake.buckets["bucket"]["volume/"].header.Get("X-Amz-Acl"); got != "bucket-owner-full-control" {
+		t.Errorf("x-amz-acl = %q, want bucket-owner-full-control", got)
+	}
+	if got := client.bucketACL(); got != "" {
+		t.Errorf("bucketACL() = %q, want none for an object ACL", got)
+	}
+	if err := ValidateCannedACL("owner-only"); err == nil {
+		t.Errorf("ValidateCannedACL() accepted an unknown ACL")
+	}
+}
+
+func TestRefreshCapacity(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "volume", CapacityBytes: 1}); err != nil {
+		t.Fatal(err)
+	}
+	fake.put("bucket", "volume/a", "12345")
+	fake.put("bucket", "volume/b", "67890")
+
+	metaSize := int64(len(fake.buckets["bucket"]["volume/.metadata.json"].data))
+	usage, err := client.RefreshCapacity("bucket", "volume")
+	if err != nil {
+		t.Fatalf("RefreshCapacity() error = %v", err)
+	}
+	if want := 10 + metaSize; usage != want {
+		t.Errorf("RefreshCapacity() = %d, want %d", usage, want)
+	}
+	if meta, _ := client.ReadMeta("bucket", "volume"); meta.CapacityBytes != usage {
+		t.Errorf("stored capacity = %d, want %d", meta.CapacityBytes, usage)
+	}
+}
+
+func TestCapacityExceeded(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "volume/a", "12345")
+	fake.put("bucket", "volume/b", "67890")
+
+	for _, tc := range []struct {
+		capacity int64
+		exceeded bool
+		usage    int64
+	}{
+		{0, false, 0},
+		{9, true, 10},
+		{10, false, 10},
+	} {
+		exceeded, usage, err := client.CapacityExceeded(&FSMeta{BucketName: "bucket", Prefix: "volume", CapacityBytes: tc.capacity})
+		if err != nil || exceeded != tc.exceeded || usage != tc.usage {
+			t.Errorf("CapacityExceeded() with capacity %d = %v, %d, %v, want %v, %d", tc.capacity, exceeded, usage, err, tc.exceeded, tc.usage)
+		}
+	}
+}
+
+func TestRenamePrefix(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "old/", "")
+	fake.put("bucket", "old/dir/file", "data")
+	// Nested objects named like the driver's own are data of the volume
+	fake.put("bucket", "old/sub/"+lockName, "data")
+	fake.put("bucket", "old/sub/"+checkpointName, "data")
+	fake.put("bucket", "old/"+checkpointName, "{}")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "old", Mounter: "geesefs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RenamePrefix("bucket", "old", "new"); err != nil {
+		t.Fatalf("RenamePrefix() error = %v", err)
+	}
+	want := []string{"new/", "new/.metadata.json", "new/dir/file", "new/sub/" + checkpointName, "new/sub/" + lockName}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	meta, err := client.ReadMeta("bucket", "new")
+	if err != nil || meta.Prefix != "new" {
+		t.Errorf("ReadMeta() = %+v, %v, want prefix new", meta, err)
+	}
+	// Renaming again finds nothing left to move
+	if err := client.RenamePrefix("bucket", "old", "new"); err != nil {
+		t.Errorf("RenamePrefix() again error = %v", err)
+	}
+}
+
+func TestRenamePrefixTarget(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/", "")
+	fake.put("bucket", "vol/file", "data")
+	fake.put("bucket", "other/", "")
+	fake.put("bucket", "other/file", "other data")
+	want := fake.keys("bucket")
+
+	for _, newPrefix := range []string{"vol/new", "other", "vol/../x"} {
+		if err := client.RenamePrefix("bucket", "vol", newPrefix); err == nil {
+			t.Errorf("RenamePrefix(vol, %s) succeeded", newPrefix)
+		}
+	}
+	if err := client.RenamePrefix("bucket", "vol", "other"); !errors.Is(err, ErrPrefixNotEmpty) {
+		t.Errorf("RenamePrefix() onto a volume error = %v, want ErrPrefixNotEmpty", err)
+	}
+	if err := client.RenamePrefix("bucket", "other", "vol"); !errors.Is(err, ErrPrefixNotEmpty) {
+		t.Errorf("RenamePrefix() onto a volume error = %v, want ErrPrefixNotEmpty", err)
+	}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after refused renames = %v, want %v", got, want)
+	}
+
+	// The copies of an interrupted rename are no obstacle
+	fake.put("bucket", "vol/second", "data")
+	fake.denyPuts = "new/second"
+	if err := client.RenamePrefix("bucket", "vol", "new"); err == nil {
+		t.Fatal("RenamePrefix() with a failing copy succeeded")
+	}
+	fake.denyPuts = ""
+	if err := client.RenamePrefix("bucket", "vol", "new"); err != nil {
+		t.Fatalf("RenamePrefix() after interruption error = %v", err)
+	}
+	if got, want := fake.keys("bucket"), []string{"new/", "new/file", "new/second", "other/", "other/file"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after rename = %v, want %v", got, want)
+	}
+}
+
+func TestCopyPrefix(t *testing.T) {
+	client, fake := newTestClient(t, "bucket", "other")
+	fake.put("bucket", "src/", "")
+	fake.put("bucket", "src/file", "data")
+	fake.put("bucket", "src/dir/", "")
+	fake.put("bucket", "src/dir/file", "data")
+	fake.put("bucket", "src/.lock.json", "{}")
+	fake.put("bucket", "src10/file", "data")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "src", Mounter: "geesefs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.denyPuts = "dir/file"
+	err := client.CopyPrefix("bucket", "src", "other", "dst")
+	var copyErr *CopyError
+	if !errors.As(err, &copyErr) || !reflect.DeepEqual(copyErr.Keys, []string{"src/dir/file"}) || copyErr.Total != 3 {
+		t.Fatalf("CopyPrefix() error = %#v, want CopyError of src/dir/file out of 3", err)
+	}
+	// Only the data is copied before all of it succeeded
+	if got, want := fake.keys("other"), []string{"dst/dir/", "dst/file"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after failed copy = %v, want %v", got, want)
+	}
+
+	fake.denyPuts = ""
+	if err = client.CopyPrefix("bucket", "src", "other", "dst"); err != nil {
+		t.Fatalf("CopyPrefix() error = %v", err)
+	}
+	want := []string{"dst/", "dst/.metadata.json", "dst/dir/", "dst/dir/file", "dst/file"}
+	if got := fake.keys("other"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	meta, err := client.ReadMeta("other", "dst")
+	if err != nil || meta.BucketName != "other" || meta.Prefix != "dst" || meta.Mounter != "geesefs" {
+		t.Errorf("ReadMeta() = %+v, %v, want bucket other and prefix dst", meta, err)
+	}
+
+	if err = client.CopyPrefix("bucket", "src", "bucket", "src/clone"); err == nil {
+		t.Error("CopyPrefix() into the source prefix succeeded")
+	}
+}
+
+func TestRemoveObjectsListError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		oneByOne bool
+	}{
+		{"bulk", false},
+		{"one by one", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, fake := newTestClient(t, "bucket")
+			client.Config.ListMaxKeys = 2
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				fake.put("bucket", "vol/"+key, "data")
+			}
+			fake.failListPages = true
+
+			remove := client.removeObjects
+			if tc.oneByOne {
+				remove = client.removeObjectsOneByOne
+			}
+			_, err := remove("bucket", "vol/")
+			if err == nil || errors.Is(err, ErrObjectsNotRemoved) {
+				t.Fatalf("remove with failing listing error = %v, want the listing error", err)
+			}
+			if got := fake.keys("bucket"); len(got) != 3 {
+				t.Errorf("keys = %v, want the 3 keys after the first page", got)
+			}
+		})
+	}
+}
+
+func TestRemoveObjectsThrottled(t *testing.T) {
+	defer func(delay time.Duration) { removeRetryDelay = delay }(removeRetryDelay)
+	removeRetryDelay = time.Millisecond
+
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/a", "data")
+	fake.put("bucket", "vol/b", "data")
+	fake.throttleDeletes = 2
+
+	if _, err := client.removeObjects("bucket", "vol/"); err != nil {
+		t.Fatalf("removeObjects() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys = %v, want none", got)
+	}
+
+	// Throttled objects are retried in batches growing while the backend
+	// keeps up, and shrinking when it throttles again
+	fake.deleteBatches = nil
+	for i := 0; i < 100; i++ {
+		fake.put("bucket", fmt.Sprintf("vol/%03d", i), "data")
+	}
+	fake.throttleDeletes = 3
+	stats, err := client.removeObjects("bucket", "vol/")
+	if err != nil {
+		t.Fatalf("removeObjects() error = %v", err)
+	}
+	if want := (RemoveStats{Objects: 100, Bytes: 400}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if want := []int{100, 10, 10, 10, 20, 40, 30}; !reflect.DeepEqual(fake.deleteBatches, want) {
+		t.Errorf("delete batches = %v, want %v", fake.deleteBatches, want)
+	}
+}
+
+func TestRemoveObjectsStats(t *testing.T) {
+	defer func(delay time.Duration) { removeRetryDelay = delay }(removeRetryDelay)
+	removeRetryDelay = time.Millisecond
+
+	for _, tc := range []struct {
+		name     string
+		throttle int
+	}{
+		{"failed", 0},
+		{"failed after throttling", 1},
+		{"given up after throttling", maxRemoveRetries + 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, fake := newTestClient(t, "bucket")
+			fake.put("bucket", "vol/a", "data")
+			fake.put("bucket", "vol/held", "held data")
+			fake.denyDeletes = "held"
+			fake.throttleDeletes = tc.throttle
+
+			stats, err := client.removeObjects("bucket", "vol/")
+			if !errors.Is(err, ErrObjectsNotRemoved) {
+				t.Fatalf("removeObjects() error = %v, want ErrObjectsNotRemoved", err)
+			}
+			// What is left behind counts as failed only
+			want := RemoveStats{Objects: 1, Bytes: 4, Failed: 1}
+			if tc.throttle > maxRemoveRetries {
+				want = RemoveStats{Failed: 2}
+			}
+			if stats != want {
+				t.Errorf("stats = %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestProvisionVolume(t *testing.T) {
+	client, fake := newTestClient(t, "shared")
+	fake.put("shared", "old/", "")
+
+	meta := &FSMeta{BucketName: "shared", Prefix: "vol", Mounter: "geesefs"}
+	if err := client.ProvisionVolume(meta, false, ProvisionHooks{}); err != nil {
+		t.Fatalf("ProvisionVolume() error = %v", err)
+	}
+	want := []string{"old/", "vol/", "vol/.metadata.json"}
+	if got := fake.keys("shared"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	err := client.ProvisionVolume(&FSMeta{BucketName: "missing", Prefix: "vol"}, false, ProvisionHooks{})
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("ProvisionVolume() without bucket error = %v, want ErrBucketNotFound", err)
+	}
+
+	// A failed metadata write removes only what was created
+	fake.denyPuts = metadataName
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "shared", Prefix: "new"}, false, ProvisionHooks{}); err == nil {
+		t.Fatal("ProvisionVolume() error = nil, want failed metadata write")
+	}
+	if got := fake.keys("shared"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after rollback = %v, want %v", got, want)
+	}
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "fresh"}, true, ProvisionHooks{}); err == nil {
+		t.Fatal("ProvisionVolume() error = nil, want failed metadata write")
+	}
+	if _, ok := fake.buckets["fresh"]; ok {
+		t.Error("bucket fresh was not removed on rollback")
+	}
+	if err := client.ProvisionVolume(meta, false, ProvisionHooks{}); err == nil {
+		t.Fatal("ProvisionVolume() of existing volume error = nil, want failed metadata write")
+	}
+	if got := fake.keys("shared"); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after failed reprovisioning = %v, want %v", got, want)
+	}
+}
+
+func TestProvisionVolumeRollback(t *testing.T) {
+	client, fake := newTestClient(t)
+	client.Config.BucketCacheTTL = time.Minute
+	fake.denyPuts = metadataName
+
+	// A bucket created since BucketExists was cached isn't removed
+	if exists, _ := client.BucketExists("late"); exists {
+		t.Fatal("BucketExists() = true for a missing bucket")
+	}
+	fake.Lock()
+	fake.buckets["late"] = make(map[string]*fakeObject)
+	fake.Unlock()
+	fake.put("late", "data", "data")
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "late"}, true, ProvisionHooks{}); err == nil {
+		t.Fatal("ProvisionVolume() error = nil, want failed metadata write")
+	}
+	if got, want := fake.keys("late"), []string{"data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys of existing bucket after rollback = %v, want %v", got, want)
+	}
+
+	// Buckets created for a prefix are kept, other volumes may use them
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "shared", Prefix: "vol"}, true, ProvisionHooks{}); err == nil {
+		t.Fatal("ProvisionVolume() error = nil, want failed metadata write")
+	}
+	if got := fake.keys("shared"); fake.buckets["shared"] == nil || len(got) != 0 {
+		t.Errorf("bucket shared after rollback of a prefix = %v, want kept and empty", got)
+	}
+
+	// Failing hooks roll back too, and the hooks see what was created
+	fake.denyPuts = ""
+	hookErr := errors.New("hook failed")
+	var created []bool
+	hooks := ProvisionHooks{
+		BeforePrefix: func(bucketCreated bool) error {
+			created = append(created, bucketCreated)
+			return nil
+		},
+		BeforeMeta: func() error { return hookErr },
+	}
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "own"}, true, hooks); err != hookErr {
+		t.Errorf("ProvisionVolume() with failing hook error = %v, want %v", err, hookErr)
+	}
+	if err := client.ProvisionVolume(&FSMeta{BucketName: "shared", Prefix: "vol"}, true, hooks); err != hookErr {
+		t.Errorf("ProvisionVolume() with failing hook error = %v, want %v", err, hookErr)
+	}
+	if !reflect.DeepEqual(created, []bool{true, false}) {
+		t.Errorf("BeforePrefix() got created %v, want [true false]", created)
+	}
+	if _, ok := fake.buckets["own"]; ok {
+		t.Error("bucket own was not removed on rollback")
+	}
+	if got := fake.keys("shared"); len(got) != 0 {
+		t.Errorf("keys after rollback of a prefix = %v, want none", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := NewClientFromSecret(map[string]string{"endpoint": "https://s3.example.com", "requestTimeout": "soon"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewClientFromSecret() with invalid timeout error = %v, want ErrInvalidConfig", err)
+	}
+	_, err = NewClientFromSecret(map[string]string{"endpoint": "ftp://s3.example.com"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewClientFromSecret() with invalid endpoint error = %v, want ErrInvalidConfig", err)
+	}
+
+	client, _ := newTestClient(t, "bucket")
+	client.Config.Anonymous = true
+	if err = client.CreatePrefix("bucket", "volume"); !errors.Is(err, ErrAnonymousAccess) {
+		t.Errorf("CreatePrefix() anonymously error = %v, want ErrAnonymousAccess", err)
+	}
+	if _, err = client.RemoveBucket("bucket"); !errors.Is(err, ErrAnonymousAccess) {
+		t.Errorf("RemoveBucket() anonymously error = %v, want ErrAnonymousAccess", err)
+	}
+}
+
+func TestErrorHint(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.denyPuts = "file"
+	err := client.PutObject("bucket", "file", []byte("data"))
+	code, hint, ok := ErrorHint(fmt.Errorf("failed to write: %w", err))
+	if code != "AccessDenied" || hint == "" || !ok {
+		t.Errorf("ErrorHint(%v) = %q, %q, %v, want AccessDenied with a hint", err, code, hint, ok)
+	}
+
+	err = &url.Error{Op: "Get", URL: "https://s3.example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	if code, hint, ok = ErrorHint(err); code != "" || hint != networkHint || !ok {
+		t.Errorf("ErrorHint(%v) = %q, %q, %v, want the network hint", err, code, hint, ok)
+	}
+	if _, _, ok = ErrorHint(errors.New("something else")); ok {
+		t.Errorf("ErrorHint() of unknown error found a hint")
+	}
+}
+
+func TestCheckVolumeMeta(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "vol", Mounter: "geesefs"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckVolumeMeta("bucket", "vol"); err != nil {
+		t.Errorf("CheckVolumeMeta() error = %v", err)
+	}
+	// No metadata to compare
+	if err := client.CheckVolumeMeta("bucket", "other"); err != nil {
+		t.Errorf("CheckVolumeMeta() without metadata error = %v", err)
+	}
+	if err := client.CheckVolumeMeta("missing", "vol"); err != nil {
+		t.Errorf("CheckVolumeMeta() without bucket error = %v", err)
+	}
+
+	fake.put("bucket", "copy/.metadata.json", `{"Name":"bucket","Prefix":"vol"}`)
+	if err := client.CheckVolumeMeta("bucket", "copy"); !errors.Is(err, ErrMetadataMismatch) {
+		t.Errorf("CheckVolumeMeta() error = %v, want ErrMetadataMismatch", err)
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	for _, prefix := range []string{"a", "b"} {
+		fake.put("bucket", prefix+"/", "")
+		if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: prefix, Mounter: "geesefs"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.put("bucket", "nometa/", "")
+
+	volumes, err := client.ExportAllMetadata("bucket")
+	if err != nil {
+		t.Fatalf("ExportAllMetadata() error = %v", err)
+	}
+	if len(volumes) != 2 || volumes[0].Prefix != "a" || volumes[1].Prefix != "b" {
+		t.Fatalf("ExportAllMetadata() = %+v, want volumes a and b", volumes)
+	}
+
+	fake.Lock()
+	delete(fake.buckets["bucket"], "a/.metadata.json")
+	fake.Unlock()
+	volumes[1].Mounter = "rclone"
+	if err = client.ImportMetadata(volumes); err != nil {
+		t.Fatalf("ImportMetadata() error = %v", err)
+	}
+	for _, want := range volumes {
+		meta, err := client.ReadMeta("bucket", want.Prefix)
+		if err != nil || meta.Mounter != want.Mounter {
+			t.Errorf("ReadMeta(%s) = %+v, %v, want mounter %s", want.Prefix, meta, err, want.Mounter)
+		}
+	}
+}
+
+func TestRegionRedirectRetry(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "key", "data")
+	fake.regions = map[string]string{"bucket": "eu-west-1"}
+	client.Config.RegionDiscovery = true
+	// The bucket moved since its region was cached
+	client.setCachedRegion("bucket", "us-east-1")
+
+	obj, err := client.GetObject("bucket", "key")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	obj.Close()
+	if region, _ := client.cachedRegion("bucket"); region != "eu-west-1" {
+		t.Errorf("cached region = %q, want eu-west-1", region)
+	}
+	if exists, err := client.BucketExists("bucket"); !exists || err != nil {
+		t.Errorf("BucketExists() = %v, %v, want true", exists, err)
+	}
+
+	// Without region discovery, the redirect is returned
+	client.Config.RegionDiscovery = false
+	if _, err = client.GetObject("bucket", "key"); err == nil {
+		t.Error("GetObject() without region discovery succeeded, want redirect error")
+	}
+}
+
+func TestBucketExistsCache(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.BucketCacheTTL = time.Minute
+	if exists, err := client.BucketExists("bucket"); !exists || err != nil {
+		t.Fatalf("BucketExists() = %v, %v, want true", exists, err)
+	}
+	// Removed behind the driver's back, the cached result is returned
+	fake.Lock()
+	delete(fake.buckets, "bucket")
+	fake.Unlock()
+	if exists, _ := client.BucketExists("bucket"); !exists {
+		t.Error("BucketExists() = false, want cached true")
+	}
+
+	// Creating or removing a bucket drops its cached result
+	if exists, _ := client.BucketExists("other"); exists {
+		t.Fatal("BucketExists() = true for a missing bucket")
+	}
+	if created, err := client.CreateBucket("other"); err != nil || !created {
+		t.Fatalf("CreateBucket() = %v, %v, want created", created, err)
+	}
+	if created, err := client.CreateBucket("other"); err != nil || created {
+		t.Errorf("CreateBucket() of own bucket = %v, %v, want not created", created, err)
+	}
+	if exists, _ := client.BucketExists("other"); !exists {
+		t.Error("BucketExists() = false after CreateBucket()")
+	}
+	if _, err := client.RemoveBucket("other"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := client.BucketExists("other"); exists {
+		t.Error("BucketExists() = true after RemoveBucket()")
+	}
+}
+
+func TestCreateBucketLocation(t *testing.T) {
+	client, fake := newTestClient(t)
+
+	for _, tc := range []struct {
+		bucket       string
+		region       string
+		noConstraint bool
+		want         string
+	}{
+		{"unset", "", false, ""},
+		{"us-east-1", "us-east-1", false, ""},
+		{"eu-west-1", "eu-west-1", false, "eu-west-1"},
+		{"omitted", "eu-west-1", true, ""},
+	} {
+		client.Config.Region = tc.region
+		client.Config.SigningRegion = tc.region
+		client.Config.NoLocationConstraint = tc.noConstraint
+		if _, err := client.CreateBucket(tc.bucket); err != nil {
+			t.Fatalf("CreateBucket(%s) error = %v", tc.bucket, err)
+		}
+		if got := fake.locations[tc.bucket]; got != tc.want {
+			t.Errorf("CreateBucket(%s) location = %q, want %q", tc.bucket, got, tc.want)
+		}
+	}
+}
+
+func TestValidateBucketName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		lenient bool
+		rule    string
+	}{
+		{"my-bucket.data", false, ""},
+		{"ab", false, "3 to 63 characters"},
+		{strings.Repeat("a", 64), false, "3 to 63 characters"},
+		{"My-Bucket", false, "uppercase"},
+		{"my_bucket", false, "underscores"},
+		{"my bucket", false, "only lowercase letters"},
+		{"-bucket", false, "start and end"},
+		{"bucket.", false, "start and end"},
+		{"my..bucket", false, `contains ".."`},
+		{"my-.bucket", false, `contains "-."`},
+		{"192.168.1.1", false, "IP address"},
+		{"xn--bucket", false, "reserved"},
+		{"bucket--ol-s3", false, "reserved"},
+		{"xn--bucket", true, ""},
+		{"My-Bucket", true, "uppercase"},
+	} {
+		err := ValidateBucketName(tc.name, tc.lenient)
+		if tc.rule == "" {
+			if err != nil {
+				t.Errorf("ValidateBucketName(%q, %v) error = %v", tc.name, tc.lenient, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidBucketName) || !strings.Contains(err.Error(), tc.rule) {
+			t.Errorf("ValidateBucketName(%q, %v) error = %v, want ErrInvalidBucketName about %q", tc.name, tc.lenient, err, tc.rule)
+		}
+	}
+
+	client, fake := newTestClient(t)
+	if _, err := client.CreateBucket("Volumes_2024"); !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("CreateBucket() of invalid name error = %v, want ErrInvalidBucketName", err)
+	}
+	if _, ok := fake.buckets["Volumes_2024"]; ok {
+		t.Error("bucket with invalid name was created")
+	}
+}
+
+func TestAllowedBuckets(t *testing.T) {
+	if _, err := ParseAllowedBuckets("team-[x"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("ParseAllowedBuckets() with bad pattern error = %v, want ErrInvalidConfig", err)
+	}
+	patterns, err := ParseAllowedBuckets("team-xyz-*, shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, fake := newTestClient(t, "other")
+	client.Config.AllowedBuckets = patterns
+
+	if _, err = client.CreateBucket("team-xyz-data"); err != nil {
+		t.Errorf("CreateBucket() of allowed bucket error = %v", err)
+	}
+	if _, err = client.CreateBucket("team-abc-data"); !errors.Is(err, ErrBucketNotAllowed) {
+		t.Errorf("CreateBucket() error = %v, want ErrBucketNotAllowed", err)
+	}
+	fake.put("other", "vol/file", "data")
+	if _, err = client.RemovePrefix("other", "vol"); !errors.Is(err, ErrBucketNotAllowed) {
+		t.Errorf("RemovePrefix() error = %v, want ErrBucketNotAllowed", err)
+	}
+	if _, err = client.RemoveBucket("other"); !errors.Is(err, ErrBucketNotAllowed) {
+		t.Errorf("RemoveBucket() error = %v, want ErrBucketNotAllowed", err)
+	}
+	if keys := fake.keys("other"); len(keys) != 1 {
+		t.Errorf("objects after refused removal = %v, want vol/file", keys)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	fake := &fakeS3{buckets: map[string]map[string]*fakeObject{"bucket": {}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cfg := &Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: server.URL, Region: "us-east-1"}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.PutObject("bucket", "a", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if ua := fake.buckets["bucket"]["a"].header.Get("User-Agent"); !strings.Contains(ua, " k8s-csi-s3/") {
+		t.Errorf("User-Agent = %q, want k8s-csi-s3/<version>", ua)
+	}
+
+	cfg = &Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: server.URL, Region: "us-east-1",
+		AppName: "acme-storage", AppVersion: "2.0"}
+	if client, err = NewClient(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.PutObject("bucket", "b", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if ua := fake.buckets["bucket"]["b"].header.Get("User-Agent"); !strings.HasSuffix(ua, " acme-storage/2.0") {
+		t.Errorf("User-Agent = %q, want acme-storage/2.0", ua)
+	}
+}
+
+func TestIsPrefixEmpty(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	if err := client.CreatePrefix("bucket", "vol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.put("bucket", "vol/.lock.json", "{}")
+	fake.put("bucket", "vol/.csi-s3-probe-node", "probe")
+	fake.put("bucket", "vol10/file", "data")
+	if empty, err := client.IsPrefixEmpty("bucket", "vol"); !empty || err != nil {
+		t.Errorf("IsPrefixEmpty() of new volume = %v, %v, want true", empty, err)
+	}
+
+	fake.put("bucket", "vol/dir/file", "data")
+	if empty, err := client.IsPrefixEmpty("bucket", "vol"); empty || err != nil {
+		t.Errorf("IsPrefixEmpty() of volume with data = %v, %v, want false", empty, err)
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	secret := map[string]string{
+		"endpoint":        "https://s3.example.com",
+		"accessKeyID":     "key",
+		"secretAccessKey": "secret",
+	}
+	if errs := ValidateSecret(secret); len(errs) != 0 {
+		t.Errorf("ValidateSecret() of valid secret = %v", errs)
+	}
+
+	secret = map[string]string{
+		"endpoint":          "ftp://s3.example.com",
+		"accessKeyID":       "key",
+		"profile":           "default",
+		"requestTimeout":    "soon",
+		"requesterPays":     "yes",
+		"partSize":          "1",
+		"fallbackEndpoints": "https://s3-2.example.com,ftp://s3-3.example.com",
+		"deleteMaxBytes":    "1Ti",
+	}
+	errs := ValidateSecret(secret)
+	// endpoint, fallbackEndpoints, requesterPays, requestTimeout, partSize,
+	// deleteMaxBytes, missing secret key, profile with keys
+	if len(errs) != 8 {
+		t.Errorf("ValidateSecret() = %d errors %v, want 8", len(errs), errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ValidateSecret() error %v does not wrap ErrInvalidConfig", err)
+		}
+	}
+	_, err := NewClientFromSecret(secret)
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "8 problems") {
+		t.Errorf("NewClientFromSecret() error = %v, want all 8 problems", err)
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	for _, region := range []string{"", "us-east-1", "ru-central1", "fr-par", "nyc3", "auto", "my_region", "eu.west"} {
+		if err := ValidateRegion("region", region); err != nil {
+			t.Errorf("ValidateRegion(%q) error = %v", region, err)
+		}
+	}
+	for _, region := range []string{"us-east-1\n", " us-east-1", "us east 1", "https://s3.us-east-1.amazonaws.com", "-us-east-1", "eu/west"} {
+		if err := ValidateRegion("region", region); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "region") {
+			t.Errorf("ValidateRegion(%q) error = %v, want ErrInvalidConfig naming the key", region, err)
+		}
+	}
+}
+
+func TestNewClientErrors(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Endpoint: "https://-bad.example.com"}, "host -bad.example.com, ssl true"},
+		{Config{Endpoint: "http://minio:70000"}, "port 70000"},
+		{Config{Endpoint: "http://minio:9000", Region: "us east"}, "region"},
+		{Config{Endpoint: "http://minio:9000", SigningRegion: "http://minio"}, "signingRegion"},
+	} {
+		cfg := tc.cfg
+		_, err := NewClient(&cfg)
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("NewClient(%+v) error = %v, want ErrInvalidConfig mentioning %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	for _, key := range []string{"vol/a", "vol/b", "vol/dir/c"} {
+		fake.put("bucket", key, "data")
+	}
+
+	for _, max := range []int{1, 10} {
+		if err := client.Warmup("bucket", "vol", max); err != nil {
+			t.Errorf("Warmup() with max %d error = %v", max, err)
+		}
+	}
+	if err := client.Warmup("missing", "vol", 10); err == nil {
+		t.Errorf("Warmup() of missing bucket succeeded")
+	}
+}
+
+func TestAuditVolumes(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	for _, meta := range []*FSMeta{
+		{BucketName: "bucket", Prefix: "ok", Mounter: "geesefs", MountOptions: []string{"--memory-limit", "1000"}},
+		{BucketName: "bucket", Prefix: "default"},
+		{BucketName: "bucket", Prefix: "goofys", Mounter: "goofys"},
+		{BucketName: "bucket", Prefix: "options", Mounter: "s3fs", MountOptions: []string{"-o", ""}},
+	} {
+		if err := client.WriteMeta(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.put("bucket", "nometa/file", "data")
+	fake.put("bucket", "broken/.metadata.json", "{")
+	fake.put("bucket", "copy/.metadata.json", `{"Name":"bucket","Prefix":"ok"}`)
+
+	findings, err := client.AuditVolumes("bucket")
+	if err != nil {
+		t.Fatalf("AuditVolumes() error = %v", err)
+	}
+	got := make(map[string]AuditProblem)
+	for _, f := range findings {
+		got[f.Prefix] = f.Problem
+	}
+	want := map[string]AuditProblem{
+		"nometa":  AuditMissingMetadata,
+		"broken":  AuditInvalidMetadata,
+		"copy":    AuditMetadataMismatch,
+		"goofys":  AuditUnsupportedMounter,
+		"options": AuditInvalidMountOption,
+	}
+	if !reflect.DeepEqual(got, want) || len(findings) != len(want) {
+		t.Errorf("AuditVolumes() = %v, want %v", findings, want)
+	}
+}
+
+func TestSetBucketReplication(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	cfg := ReplicationConfig{
+		Role:              "arn:aws:iam::123456789012:role/replication",
+		DestinationBucket: "dr-bucket",
+		StorageClass:      "STANDARD_IA",
+	}
+
+	if err := client.SetBucketReplication("bucket", cfg); !errors.Is(err, ErrVersioningRequired) {
+		t.Fatalf("SetBucketReplication() without versioning error = %v, want ErrVersioningRequired", err)
+	}
+	if err := client.SetBucketVersioning("bucket", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetBucketReplication("bucket", cfg); err != nil {
+		t.Fatalf("SetBucketReplication() error = %v", err)
+	}
+	config := fake.replication["bucket"]
+	for _, want := range []string{
+		"<Role>arn:aws:iam::123456789012:role/replication</Role>",
+		"<Bucket>arn:aws:s3:::dr-bucket</Bucket>",
+		"<StorageClass>STANDARD_IA</StorageClass>",
+		"<Status>Enabled</Status>",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("replication configuration %s lacks %s", config, want)
+		}
+	}
+
+	for _, invalid := range []ReplicationConfig{
+		{DestinationBucket: "dr-bucket"},
+		{Role: "arn:aws:iam::123456789012:user/replication", DestinationBucket: "dr-bucket"},
+		{Role: cfg.Role, DestinationBucket: "arn:aws:s3:dr-bucket"},
+	} {
+		if err := ValidateReplication(invalid); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ValidateReplication(%+v) error = %v, want ErrInvalidConfig", invalid, err)
+		}
+	}
+}
+
+func TestSetBucketNotification(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+
+	cfg := NotificationConfig{Target: "arn:aws:sqs:us-east-1:123456789012:uploads"}
+	if err := client.SetBucketNotification("bucket", cfg); err != nil {
+		t.Fatalf("SetBucketNotification() error = %v", err)
+	}
+	config := fake.notifications["bucket"]
+	for _, want := range []string{
+		"<QueueConfiguration><Id>csi-s3</Id>",
+		"<Queue>arn:aws:sqs:us-east-1:123456789012:uploads</Queue>",
+		"<Event>s3:ObjectCreated:*</Event><Event>s3:ObjectRemoved:*</Event>",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("notification configuration %s lacks %s", config, want)
+		}
+	}
+
+	cfg = NotificationConfig{Target: "arn:minio:sqs::primary:webhook", Events: []string{"s3:ObjectCreated:Put"}}
+	if err := client.SetBucketNotification("bucket", cfg); err != nil {
+		t.Fatalf("SetBucketNotification() of MinIO webhook error = %v", err)
+	}
+	config = fake.notifications["bucket"]
+	if !strings.Contains(config, "<Queue>arn:minio:sqs::primary:webhook</Queue>") || strings.Contains(config, "ObjectRemoved") {
+		t.Errorf("notification configuration = %s, want only created objects sent to the webhook", config)
+	}
+	cfg = NotificationConfig{Target: "arn:aws:sns:us-east-1:123456789012:uploads"}
+	if err := client.SetBucketNotification("bucket", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if config = fake.notifications["bucket"]; !strings.Contains(config, "<Topic>arn:aws:sns:us-east-1:123456789012:uploads</Topic>") {
+		t.Errorf("notification configuration = %s, want the topic", config)
+	}
+
+	for _, invalid := range []NotificationConfig{
+		{},
+		{Target: "https://hooks.example.com/uploads"},
+		{Target: "arn:aws:s3:::bucket"},
+		{Target: "arn:aws:sqs:us-east-1:123456789012"},
+		{Target: cfg.Target, Events: []string{"ObjectCreated"}},
+	} {
+		if err := ValidateNotification(invalid); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ValidateNotification(%+v) error = %v, want ErrInvalidConfig", invalid, err)
+		}
+	}
+}
+
+func TestEndpointSubPath(t *testing.T) {
+	fake := &fakeS3{buckets: map[string]map[string]*fakeObject{"bucket": {}}}
+	// Like a gateway serving S3 under a sub-path
+	server := httptest.NewServer(http.StripPrefix("/storage/s3", fake))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&Config{AccessKeyID: "key", SecretAccessKey: "secret", Region: "us-east-1",
+		Endpoint: server.URL + "/storage/s3/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config.Endpoint != server.URL+"/storage/s3" {
+		t.Errorf("endpoint = %s, want the path kept for the mounters", client.Config.Endpoint)
+	}
+	if err = client.PutObject("bucket", "dir/file name", []byte("data")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if got, want := fake.keys("bucket"), []string{"dir/file name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestCustomHeaders(t *testing.T) {
+	fake := &fakeS3{buckets: map[string]map[string]*fakeObject{"bucket": {}}}
+	// Like a gateway requiring a tenant ID on every request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant-Id") != "tenant-1" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClientFromSecret(map[string]string{
+		"endpoint":        server.URL,
+		"region":          "us-east-1",
+		"accessKeyID":     "key",
+		"secretAccessKey": "secret",
+		"customHeaders":   "x-tenant-id=tenant-1",
+	})
+	if err != nil {
+		t.Fatalf("NewClientFromSecret() error = %v", err)
+	}
+	if err = client.PutObject("bucket", "file", []byte("data")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if exists, err := client.BucketExists("bucket"); err != nil || !exists {
+		t.Errorf("BucketExists() = %v, %v, want true", exists, err)
+	}
+
+	for _, value := range []string{"x-tenant-id", "x-tenant-id:secret-key", "host=secret-key", "x-amz-tenant=secret-key", "x-api-key=secret\x00key"} {
+		_, err := ParseCustomHeaders(value)
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ParseCustomHeaders(%q) error = %v, want ErrInvalidConfig", value, err)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("ParseCustomHeaders(%q) error %q contains the value", value, err)
+		}
+	}
+}
+
+func TestSwapMetadata(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	for _, meta := range []*FSMeta{
+		{BucketName: "bucket", Prefix: "prod", Mounter: "s3fs", CapacityBytes: 1 << 30},
+		{BucketName: "bucket", Prefix: "staging", Mounter: "geesefs", CapacityBytes: 2 << 30},
+	} {
+		if err := client.WriteMeta(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := client.SwapMetadata("bucket", "prod", "staging"); err != nil {
+		t.Fatalf("SwapMetadata() error = %v", err)
+	}
+	prod, err := client.ReadMeta("bucket", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := client.ReadMeta("bucket", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.Prefix != "prod" || prod.Mounter != "geesefs" || prod.CapacityBytes != 2<<30 {
+		t.Errorf("metadata of prod = %+v, want the one of staging", prod)
+	}
+	if staging.Prefix != "staging" || staging.Mounter != "s3fs" || staging.CapacityBytes != 1<<30 {
+		t.Errorf("metadata of staging = %+v, want the one of prod", staging)
+	}
+	for _, key := range fake.keys("bucket") {
+		if strings.HasSuffix(key, lockName) {
+			t.Errorf("lock %s left behind", key)
+		}
+	}
+
+	// A failed second write rolls back the first one
+	fake.denyPuts = "staging/" + metadataName
+	if err = client.SwapMetadata("bucket", "prod", "staging"); err == nil {
+		t.Fatal("SwapMetadata() with failing write succeeded")
+	}
+	prod, err = client.ReadMeta("bucket", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.Mounter != "geesefs" {
+		t.Errorf("metadata of prod after failed swap = %+v, want it unchanged", prod)
+	}
+	fake.denyPuts = ""
+
+	// Swaps wait for volumes locked by others
+	other := NewClientWithMinio(client.Config, client.minio)
+	if err = other.AcquireLock("bucket", "staging", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SwapMetadata("bucket", "prod", "staging"); !errors.Is(err, ErrLocked) {
+		t.Errorf("SwapMetadata() of locked volume error = %v, want ErrLocked", err)
+	}
+	if err = client.SwapMetadata("bucket", "prod", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SwapMetadata() with missing metadata error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	t.Cleanup(func() { SetDefaults(Defaults{}) })
+	err := SetDefaults(Defaults{Endpoint: "https://s3.example.com", Region: "eu-west-1", Mounter: "rclone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := map[string]string{"accessKeyID": "key", "secretAccessKey": "secret"}
+	client, err := NewClientFromSecret(secret)
+	if err != nil {
+		t.Fatalf("NewClientFromSecret() without endpoint error = %v", err)
+	}
+	if cfg := client.Config; cfg.Endpoint != "https://s3.example.com" || cfg.Region != "eu-west-1" || cfg.Mounter != "rclone" {
+		t.Errorf("config = %s, %s, %s, want the defaults", cfg.Endpoint, cfg.Region, cfg.Mounter)
+	}
+	if _, ok := secret["endpoint"]; ok {
+		t.Error("defaults written to the secret")
+	}
+
+	secret["endpoint"] = "https://storage.example.org"
+	secret["region"] = "us-east-2"
+	if client, err = NewClientFromSecret(secret); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := client.Config; cfg.Endpoint != "https://storage.example.org" || cfg.Region != "us-east-2" {
+		t.Errorf("config = %s, %s, want those of the secret", cfg.Endpoint, cfg.Region)
+	}
+
+	// The storage class comes between the secret and the defaults
+	params := map[string]string{"endpoint": "https://class.example.net", "region": "ap-south-1"}
+	if client, err = NewClientFromSecret(WithStorageClass(secret, params)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := client.Config; cfg.Endpoint != "https://storage.example.org" || cfg.Region != "us-east-2" {
+		t.Errorf("config = %s, %s, want those of the secret over the storage class", cfg.Endpoint, cfg.Region)
+	}
+	delete(secret, "endpoint")
+	if client, err = NewClientFromSecret(WithStorageClass(secret, params)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := client.Config; cfg.Endpoint != "https://class.example.net" || cfg.Region != "us-east-2" {
+		t.Errorf("config = %s, %s, want the endpoint of the storage class", cfg.Endpoint, cfg.Region)
+	}
+	delete(secret, "region")
+	if client, err = NewClientFromSecret(WithStorageClass(secret, map[string]string{"region": "ap-south-1"})); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := client.Config; cfg.Endpoint != "https://s3.example.com" || cfg.Region != "ap-south-1" {
+		t.Errorf("config = %s, %s, want the default endpoint and the region of the storage class", cfg.Endpoint, cfg.Region)
+	}
+	if _, ok := secret["region"]; ok {
+		t.Error("storage class written to the secret")
+	}
+
+	if err = SetDefaults(Defaults{Endpoint: "ftp://s3.example.com"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SetDefaults() with invalid endpoint error = %v, want ErrInvalidConfig", err)
+	}
+	if err = SetDefaults(Defaults{Mounter: "goofys"}); !errors.Is(err, ErrInvalidMounter) {
+		t.Errorf("SetDefaults() with unknown mounter error = %v, want ErrInvalidMounter", err)
+	}
+}
@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// maxErrorBodySize bounds how much of an error response is read to look for
+// a clock skew error
+const maxErrorBodySize = 64 << 10
+
+// skewErrorResponse is the part of an S3 error response minio reports, plus
+// the times AWS adds to RequestTimeTooSkewed errors
+type skewErrorResponse struct {
+	XMLName     xml.Name `xml:"Error"`
+	Code        string
+	Message     string
+	BucketName  string `xml:",omitempty"`
+	Key         string `xml:",omitempty"`
+	RequestID   string `xml:"RequestId,omitempty"`
+	HostID      string `xml:"HostId,omitempty"`
+	RequestTime string `xml:",omitempty"`
+	ServerTime  string `xml:",omitempty"`
+}
+
+// skewTransport rewrites the message of RequestTimeTooSkewed errors to point
+// at the clock of the node, as they otherwise look much like a problem with
+// the credentials. minio doesn't keep the server time of error responses, so
+// this is done before it parses them.
+type skewTransport struct {
+	http.RoundTripper
+}
+
+func (t *skewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var errResp skewErrorResponse
+	if xml.Unmarshal(body, &errResp) == nil && errResp.Code == "RequestTimeTooSkewed" {
+		errResp.Message = clockSkewMessage(errResp, resp.Header.Get("Date"), time.Now())
+		if rewritten, err := xml.Marshal(&errResp); err == nil {
+			body = rewritten
+		}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// clockSkewMessage describes a RequestTimeTooSkewed error, with the offset of
+// the local clock if the server reported its time
+func clockSkewMessage(errResp skewErrorResponse, date string, now time.Time) string {
+	serverTime, err := time.Parse(time.RFC3339, errResp.ServerTime)
+	if err != nil {
+		serverTime, err = http.ParseTime(date)
+	}
+	if err != nil {
+		return fmt.Sprintf("request time too skewed: the clock of this node differs too much "+
+			"from the one of the server, check that it is synchronized with NTP (%s)", errResp.Message)
+	}
+	return fmt.Sprintf("request time too skewed: the clock of this node is off by %s from "+
+		"the server time %s, check that it is synchronized with NTP",
+		now.Sub(serverTime).Round(time.Second), serverTime.UTC().Format(time.RFC3339))
+}
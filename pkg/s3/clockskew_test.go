@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type responseTransport struct {
+	status int
+	body   string
+}
+
+func (t *responseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: t.status,
+		Header:     http.Header{"Date": []string{"Mon, 02 Jan 2006 15:04:05 GMT"}},
+		Body:       ioutil.NopCloser(strings.NewReader(t.body)),
+	}, nil
+}
+
+func TestSkewTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "server time",
+			body: `<Error><Code>RequestTimeTooSkewed</Code><Message>The difference is too large.</Message>` +
+				`<RequestTime>20060102T160405Z</RequestTime><ServerTime>2006-01-02T15:04:05Z</ServerTime></Error>`,
+			want: "server time 2006-01-02T15:04:05Z",
+		},
+		{
+			name: "date header",
+			body: `<Error><Code>RequestTimeTooSkewed</Code><Message>The difference is too large.</Message></Error>`,
+			want: "server time 2006-01-02T15:04:05Z",
+		},
+		{
+			name: "other error",
+			body: `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`,
+			want: "Access Denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &skewTransport{&responseTransport{http.StatusForbidden, tt.body}}
+			req, _ := http.NewRequest(http.MethodGet, "https://s3.amazonaws.com/bucket", nil)
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var errResp skewErrorResponse
+			if err = xml.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(errResp.Message, tt.want) {
+				t.Errorf("Message = %q, want it to contain %q", errResp.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestClockSkewMessage(t *testing.T) {
+	now := time.Date(2006, 1, 2, 15, 20, 5, 0, time.UTC)
+	got := clockSkewMessage(skewErrorResponse{ServerTime: "2006-01-02T15:04:05Z"}, "", now)
+	if !strings.Contains(got, "off by 16m0s") || !strings.Contains(got, "NTP") {
+		t.Errorf("clockSkewMessage() = %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxCopyErrorKeys is the number of failed keys named in the message of a
+// CopyError, all of them are in its Keys
+const maxCopyErrorKeys = 10
+
+// CopyError is returned when some objects of a prefix couldn't be copied.
+// Keys are the sorted keys of these objects in the source bucket, so that a
+// retry can be limited to them.
+type CopyError struct {
+	Bucket string
+	Prefix string
+	Keys   []string
+	Total  int64
+}
+
+func (e *CopyError) Error() string {
+	keys := e.Keys
+	more := ""
+	if len(keys) > maxCopyErrorKeys {
+		more = fmt.Sprintf(" and %d more", len(keys)-maxCopyErrorKeys)
+		keys = keys[:maxCopyErrorKeys]
+	}
+	return fmt.Sprintf("failed to copy %d out of %d objects of %s/%s: %s%s",
+		len(e.Keys), e.Total, e.Bucket, e.Prefix, strings.Join(keys, ", "), more)
+}
+
+// CopyPrefix copies the objects of the volume at srcBucket/srcPrefix to
+// dstBucket/dstPrefix with server-side copies, e.g. to clone a volume. The
+// data is copied first, then the prefix placeholder and the metadata,
+// rewritten for the new volume, so that an interrupted copy doesn't look like
+// a complete volume. Copies overwrite, so a failed copy can be completed by
+// calling CopyPrefix again. The lock, delete checkpoint and probe objects of
+// the source volume are not copied.
+func (client *s3Client) CopyPrefix(srcBucket, srcPrefix, dstBucket, dstPrefix string) error {
+	if client.Config.Anonymous {
+		return fmt.Errorf("cannot copy %s/%s: %w", srcBucket, srcPrefix, ErrAnonymousAccess)
+	}
+	if err := client.CheckBucketAllowed(dstBucket); err != nil {
+		return fmt.Errorf("cannot copy %s/%s: %w", srcBucket, srcPrefix, err)
+	}
+	done, err := client.begin("copy " + srcBucket + "/" + srcPrefix)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if srcBucket == dstBucket {
+		if srcPrefix == dstPrefix {
+			return nil
+		}
+		// The copies would be listed and copied again, or overwrite the source
+		src, dst := prefixKey(srcPrefix, ""), prefixKey(dstPrefix, "")
+		if strings.HasPrefix(dst, src) || strings.HasPrefix(src, dst) {
+			return fmt.Errorf("cannot copy %s/%s to %s, one contains the other", srcBucket, srcPrefix, dstPrefix)
+		}
+	}
+	skip := func(key string) bool {
+		return client.isDriverObject(srcPrefix, key)
+	}
+	if err := client.copyObjects(srcBucket, srcPrefix, dstBucket, dstPrefix, skip); err != nil {
+		return err
+	}
+
+	if dstPrefix != "" && !client.Config.NoPlaceholder {
+		if _, err := client.putObject(client.ctx, dstBucket, dstPrefix+"/", []byte{}, client.objectOptions()); err != nil {
+			return fmt.Errorf("failed to create placeholder of %s/%s: %w", dstBucket, dstPrefix, err)
+		}
+	}
+	if client.Config.DisableMetadata {
+		return nil
+	}
+	meta, err := client.ReadMeta(srcBucket, srcPrefix)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta.BucketName = dstBucket
+	meta.Prefix = dstPrefix
+	meta.ETag = ""
+	return client.WriteMeta(meta)
+}
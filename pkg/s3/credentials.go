@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// CredentialProvider returns the credentials to sign a request with. It is
+// called for every request, so that short-lived credentials e.g. from Vault
+// don't have to be kept in the config.
+type CredentialProvider func() (credentials.Value, error)
+
+// callbackProvider implements credentials.Provider for a CredentialProvider.
+// The credentials are never cached.
+type callbackProvider struct {
+	fetch CredentialProvider
+}
+
+func (p *callbackProvider) Retrieve() (credentials.Value, error) {
+	return p.fetch()
+}
+
+func (p *callbackProvider) IsExpired() bool {
+	return true
+}
+
+// newCredentials returns the credentials to sign requests with for cfg
+func newCredentials(cfg *Config) *credentials.Credentials {
+	switch {
+	case cfg.CredentialProvider != nil:
+		return credentials.New(&callbackProvider{cfg.CredentialProvider})
+	case cfg.Anonymous:
+		return credentials.NewStatic("", "", "", credentials.SignatureAnonymous)
+	case cfg.UseIAM:
+		return credentials.NewIAM(cfg.IAMEndpoint)
+	case cfg.usesProfile():
+		return credentials.NewFileAWSCredentials(cfg.CredentialsFile, cfg.Profile)
+	case cfg.RoleARN != "":
+		return credentials.New(&assumeRoleProvider{opts: AssumeRoleOptions{
+			STSEndpoint: cfg.STSEndpoint,
+			Region:      cfg.Region,
+			AccessKey:   cfg.AccessKeyID,
+			SecretKey:   cfg.SecretAccessKey,
+			RoleARN:     cfg.RoleARN,
+			ExternalID:  cfg.ExternalID,
+			TokenFile:   cfg.TokenFile,
+		}})
+	default:
+		return credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+}
+
+// usesProfile reports whether the credentials are read from a shared
+// credentials file
+func (cfg *Config) usesProfile() bool {
+	return cfg.Profile != "" || cfg.CredentialsFile != ""
+}
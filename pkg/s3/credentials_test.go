@@ -0,0 +1,175 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfileCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	err := ioutil.WriteFile(file, []byte("[default]\naws_access_key_id = default\naws_secret_access_key = secret\n"+
+		"[csi]\naws_access_key_id = csikey\naws_secret_access_key = csisecret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClientFromSecret(map[string]string{
+		"endpoint":        "http://minio:9000",
+		"credentialsFile": file,
+		"profile":         "csi",
+	})
+	if err != nil {
+		t.Fatalf("NewClientFromSecret() error = %v", err)
+	}
+	if client.Config.Anonymous {
+		t.Errorf("client with profile is anonymous")
+	}
+	if client.Config.AccessKeyID != "csikey" || client.Config.SecretAccessKey != "csisecret" {
+		t.Errorf("keys = %q, %q, want those of profile csi", client.Config.AccessKeyID, client.Config.SecretAccessKey)
+	}
+
+	_, err = NewClientFromSecret(map[string]string{
+		"endpoint":    "http://minio:9000",
+		"accessKeyID": "key",
+		"profile":     "csi",
+	})
+	if err == nil {
+		t.Errorf("NewClientFromSecret() accepted both a profile and keys")
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sts/aws4_request") {
+			t.Errorf("Authorization = %q, want STS signature", r.Header.Get("Authorization"))
+		}
+		if r.Form.Get("ExternalId") != "ext" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+			`<AccessKeyId>temp</AccessKeyId><SecretAccessKey>tempsecret</SecretAccessKey><SessionToken>token</SessionToken>`+
+			`<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer sts.Close()
+
+	opts := AssumeRoleOptions{
+		STSEndpoint: sts.URL,
+		AccessKey:   "key",
+		SecretKey:   "secret",
+		RoleARN:     "arn:aws:iam::123456789012:role/csi",
+		ExternalID:  "ext",
+	}
+	value, expiration, err := AssumeRole(opts)
+	if err != nil {
+		t.Fatalf("AssumeRole() error = %v", err)
+	}
+	if value.AccessKeyID != "temp" || value.SessionToken != "token" || expiration.Year() != 2030 {
+		t.Errorf("AssumeRole() = %+v, %v", value, expiration)
+	}
+
+	opts.ExternalID = ""
+	if _, _, err = AssumeRole(opts); !errors.Is(err, ErrAssumeRole) || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("AssumeRole() without external ID error = %v, want AccessDenied", err)
+	}
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	var gotToken string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Header.Get("Authorization") != "" {
+			t.Errorf("Action = %q, Authorization = %q, want unsigned AssumeRoleWithWebIdentity", r.Form.Get("Action"), r.Header.Get("Authorization"))
+		}
+		gotToken = r.Form.Get("WebIdentityToken")
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleWithWebIdentityResult><Credentials>`+
+			`<AccessKeyId>temp</AccessKeyId><SecretAccessKey>tempsecret</SecretAccessKey><SessionToken>token</SessionToken>`+
+			`<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret := map[string]string{"roleArn": "arn:aws:iam::123456789012:role/csi", "tokenFile": tokenFile}
+	if got, err := webIdentityTokenFile(secret); got != tokenFile || err != nil {
+		t.Fatalf("webIdentityTokenFile() = %q, %v, want %q", got, err, tokenFile)
+	}
+	opts := AssumeRoleOptions{STSEndpoint: sts.URL, RoleARN: secret["roleArn"], TokenFile: tokenFile}
+	value, _, err := AssumeRole(opts)
+	if err != nil {
+		t.Fatalf("AssumeRole() error = %v", err)
+	}
+	if value.AccessKeyID != "temp" || gotToken != "jwt1" {
+		t.Errorf("AssumeRole() = %+v with token %q", value, gotToken)
+	}
+
+	// The rotated token is used for the next call
+	ioutil.WriteFile(tokenFile, []byte("jwt2"), 0600)
+	if _, _, err = AssumeRole(opts); err != nil || gotToken != "jwt2" {
+		t.Errorf("AssumeRole() after rotation used token %q, %v, want jwt2", gotToken, err)
+	}
+
+	ioutil.WriteFile(tokenFile, nil, 0600)
+	if _, err = webIdentityTokenFile(secret); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("webIdentityTokenFile() with empty token error = %v, want ErrInvalidConfig", err)
+	}
+	if _, _, err = AssumeRole(opts); !errors.Is(err, ErrAssumeRole) {
+		t.Errorf("AssumeRole() with empty token error = %v, want ErrAssumeRole", err)
+	}
+}
+
+func TestIAMCredentials(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "csi-role")
+		case "/latest/meta-data/iam/security-credentials/csi-role":
+			fmt.Fprint(w, `{"Code":"Success","AccessKeyId":"instance","SecretAccessKey":"instancesecret",`+
+				`"Token":"token","Expiration":"2030-01-01T00:00:00Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer imds.Close()
+
+	client, err := NewClientFromSecret(map[string]string{
+		"endpoint":    "http://minio:9000",
+		"useIAM":      "true",
+		"iamEndpoint": imds.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewClientFromSecret() error = %v", err)
+	}
+	if client.Config.Anonymous {
+		t.Errorf("client using IAM is anonymous")
+	}
+	value, err := client.creds.Get()
+	if err != nil {
+		t.Fatalf("credentials error = %v", err)
+	}
+	if value.AccessKeyID != "instance" || value.SessionToken != "token" {
+		t.Errorf("credentials = %+v, want those of the instance role", value)
+	}
+
+	for _, secret := range []map[string]string{
+		{"endpoint": "http://minio:9000", "useIAM": "true", "accessKeyID": "key", "secretAccessKey": "secret"},
+		{"endpoint": "http://minio:9000", "useIAM": "true", "profile": "csi"},
+		{"endpoint": "http://minio:9000", "useIAM": "true", "anonymous": "true"},
+		{"endpoint": "http://minio:9000", "useIAM": "true", "iamEndpoint": "169.254.169.254"},
+		{"endpoint": "http://minio:9000", "iamEndpoint": imds.URL},
+	} {
+		if _, err := NewClientFromSecret(secret); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewClientFromSecret(%v) error = %v, want ErrInvalidConfig", secret, err)
+		}
+	}
+}
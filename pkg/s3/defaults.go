@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Defaults are cluster-wide settings of the driver, used for secrets which
+// don't set them
+type Defaults struct {
+	// Endpoint and Region are used for secrets without endpoint and region
+	Endpoint string
+	Region   string
+	// Mounter is used for volumes whose storage class doesn't set one,
+	// GeeseFS if empty
+	Mounter string
+}
+
+var defaults = struct {
+	sync.RWMutex
+	Defaults
+}{}
+
+// SetDefaults sets the defaults of all clients created from secrets
+// afterwards, after checking them
+func SetDefaults(d Defaults) error {
+	if d.Endpoint != "" {
+		endpoint, err := normalizeEndpoint(d.Endpoint)
+		if err != nil {
+			return err
+		}
+		if _, _, err = parseEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	if d.Mounter != "" && !supportedMounters[d.Mounter] {
+		return fmt.Errorf("%w: default mounter %q", ErrInvalidMounter, d.Mounter)
+	}
+	defaults.Lock()
+	defer defaults.Unlock()
+	defaults.Defaults = d
+	return nil
+}
+
+// withDefaults returns a copy of secret with the keys it doesn't set taken
+// from the defaults
+func withDefaults(secret map[string]string) map[string]string {
+	defaults.RLock()
+	d := defaults.Defaults
+	defaults.RUnlock()
+	merged := make(map[string]string, len(secret)+2)
+	for key, value := range secret {
+		merged[key] = value
+	}
+	if merged["endpoint"] == "" {
+		merged["endpoint"] = d.Endpoint
+	}
+	if merged["region"] == "" {
+		merged["region"] = d.Region
+	}
+	return merged
+}
+
+// storageClassKeys are the secret keys a storage class can set for the
+// volumes of its class, as parameters of the same name
+var storageClassKeys = []string{"endpoint", "region"}
+
+// WithStorageClass returns a copy of secret with the endpoint and region it
+// doesn't set taken from the parameters of a storage class, or the volume
+// context holding them. Those set by neither are then taken from the
+// defaults, so the secret takes precedence over the storage class, and the
+// storage class over the defaults.
+func WithStorageClass(secret, params map[string]string) map[string]string {
+	merged := make(map[string]string, len(secret)+len(storageClassKeys))
+	for key, value := range secret {
+		merged[key] = value
+	}
+	for _, key := range storageClassKeys {
+		if merged[key] == "" && params[key] != "" {
+			merged[key] = params[key]
+		}
+	}
+	return merged
+}
+
+// defaultMounter returns the mounter of volumes without one
+func defaultMounter() string {
+	defaults.RLock()
+	defer defaults.RUnlock()
+	return defaults.Mounter
+}
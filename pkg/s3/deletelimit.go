@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// ReclaimBucket allows removing whole buckets, for volumes with their
+	// own bucket
+	ReclaimBucket = "bucket"
+	// ReclaimPrefixOnly only allows removing prefixes, for buckets shared by
+	// prefix volumes
+	ReclaimPrefixOnly = "prefixOnly"
+)
+
+// ValidateReclaimPolicy checks the reclaim policy of a secret
+func ValidateReclaimPolicy(policy string) error {
+	switch policy {
+	case "", ReclaimBucket, ReclaimPrefixOnly:
+		return nil
+	}
+	return fmt.Errorf("%w: reclaimPolicy: %q is neither %s nor %s", ErrInvalidConfig, policy, ReclaimBucket, ReclaimPrefixOnly)
+}
+
+// checkDeleteLimits refuses to remove the objects under prefix if there are
+// more of them, or they are larger, than the limits of the secret allow,
+// unless the removal is confirmed. Listing stops as soon as a limit is
+// exceeded.
+func (client *s3Client) checkDeleteLimits(bucketName, prefix string) error {
+	maxObjects, maxBytes := client.Config.DeleteMaxObjects, client.Config.DeleteMaxBytes
+	if (maxObjects <= 0 && maxBytes <= 0) || client.Config.ConfirmLargeDelete {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	var objects, bytes int64
+	for object := range client.list(ctx, bucketName, client.listOptions(prefix, true)) {
+		if object.Err != nil {
+			if isNoSuchBucket(object.Err) {
+				// Nothing to remove
+				return nil
+			}
+			return object.Err
+		}
+		objects++
+		bytes += object.Size
+		if (maxObjects > 0 && objects > maxObjects) || (maxBytes > 0 && bytes > maxBytes) {
+			return fmt.Errorf("%w: %s holds more than %d objects or %d bytes, set confirmLargeDelete to remove it",
+				ErrDeleteTooLarge, FormatVolumeID(bucketName, prefix), maxObjects, maxBytes)
+		}
+	}
+	return nil
+}
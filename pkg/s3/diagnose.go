@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Stages of Diagnose
+const (
+	StageDNS     = "dns"
+	StageConnect = "connect"
+	StageAuth    = "auth"
+)
+
+// DiagnosticResult is the outcome of one stage of Diagnose
+type DiagnosticResult struct {
+	Stage    string
+	OK       bool
+	Skipped  bool
+	Duration time.Duration
+	Message  string
+}
+
+func (r DiagnosticResult) String() string {
+	state := "ok"
+	if r.Skipped {
+		state = "skipped"
+	} else if !r.OK {
+		state = "FAILED"
+	}
+	return fmt.Sprintf("%-8s %-7s %-8v %s", r.Stage, state, r.Duration.Round(time.Millisecond), r.Message)
+}
+
+// Diagnose checks step by step whether the endpoint can be used: that its
+// host name resolves, that a TCP connection (and TLS handshake for https)
+// can be established, and that the credentials are accepted. Stages after a
+// failed one are skipped. The error is that of the failed stage.
+func (client *s3Client) Diagnose() ([]DiagnosticResult, error) {
+	endpoint, ssl, err := parseEndpoint(client.Config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = endpoint, "80"
+		if ssl {
+			port = "443"
+		}
+	}
+	stages := []struct {
+		name  string
+		check func() (string, error)
+	}{
+		{StageDNS, func() (string, error) { return client.diagnoseDNS(host) }},
+		{StageConnect, func() (string, error) { return client.diagnoseConnect(host, port, ssl) }},
+		{StageAuth, client.diagnoseAuth},
+	}
+
+	var results []DiagnosticResult
+	var failed error
+	for _, stage := range stages {
+		if failed != nil {
+			results = append(results, DiagnosticResult{Stage: stage.name, Skipped: true, Message: "skipped after failure"})
+			continue
+		}
+		start := time.Now()
+		message, err := stage.check()
+		result := DiagnosticResult{Stage: stage.name, OK: err == nil, Duration: time.Since(start), Message: message}
+		if err != nil {
+			result.Message = err.Error()
+			failed = fmt.Errorf("%s check failed: %w", stage.name, err)
+		}
+		results = append(results, result)
+	}
+	return results, failed
+}
+
+func (client *s3Client) diagnoseDNS(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return "endpoint is an IP address", nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(client.ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s resolves to %v", host, addrs), nil
+}
+
+func (client *s3Client) diagnoseConnect(host, port string, ssl bool) (string, error) {
+	timeout := client.Config.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !ssl {
+		conn, err := dialer.DialContext(client.ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "connected without TLS", nil
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), &tls.Config{ServerName: host})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	return fmt.Sprintf("TLS handshake with %s succeeded, certificate of %s",
+		host, state.PeerCertificates[0].Subject.CommonName), nil
+}
+
+// diagnoseAuth lists the buckets, which any valid credentials may try. Being
+// denied the listing still means that the credentials were accepted.
+func (client *s3Client) diagnoseAuth() (string, error) {
+	if client.Config.Anonymous {
+		return "anonymous access, no credentials to check", nil
+	}
+	_, err := client.minio.ListBuckets(client.ctx)
+	switch code := minio.ToErrorResponse(err).Code; {
+	case err == nil:
+		return "credentials accepted", nil
+	case code == "AccessDenied":
+		return "credentials accepted, but not allowed to list buckets", nil
+	case code == "RequestTimeTooSkewed":
+		// Not a problem of the credentials
+		return "", err
+	case code != "":
+		return "", fmt.Errorf("credentials rejected: %v", err)
+	default:
+		return "", err
+	}
+}
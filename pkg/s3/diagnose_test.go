@@ -0,0 +1,29 @@
+package s3
+
+import "testing"
+
+func TestDiagnose(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	results, err := client.Diagnose()
+	if err != nil {
+		t.Fatalf("Diagnose() error = %v, results %v", err, results)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Diagnose() = %v, want 3 stages", results)
+	}
+	for _, r := range results {
+		if !r.OK {
+			t.Errorf("stage %s failed: %s", r.Stage, r.Message)
+		}
+	}
+
+	// Nothing listens on the port any more
+	client.Config.Endpoint = "http://127.0.0.1:1"
+	results, err = client.Diagnose()
+	if err == nil {
+		t.Fatal("Diagnose() error = nil, want failed connection")
+	}
+	if results[1].OK || !results[2].Skipped {
+		t.Errorf("Diagnose() = %v, want connect failed and auth skipped", results)
+	}
+}
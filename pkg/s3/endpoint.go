@@ -0,0 +1,103 @@
+package s3
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// normalizeEndpoint returns the endpoint URL with a scheme and without
+// trailing slashes. Endpoints given as a bare host[:port] default to https,
+// and so do s3:// URLs as used by the AWS CLI.
+func normalizeEndpoint(endpoint string) (string, error) {
+	endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/")
+	if endpoint == "" {
+		return "", fmt.Errorf("%w: endpoint is empty", ErrInvalidConfig)
+	}
+	i := strings.Index(endpoint, "://")
+	if i < 0 {
+		glog.Warningf("Endpoint %s has no scheme, assuming https", endpoint)
+		return "https://" + endpoint, nil
+	}
+	switch scheme := strings.ToLower(endpoint[:i]); scheme {
+	case "http", "https":
+		return scheme + endpoint[i:], nil
+	case "s3":
+		return "https" + endpoint[i:], nil
+	default:
+		return "", fmt.Errorf("%w: endpoint %s: unsupported scheme %s", ErrInvalidConfig, endpoint, scheme)
+	}
+}
+
+// endpointRegion returns the region of a regional AWS endpoint like
+// s3.us-west-2.amazonaws.com, or "" for any other endpoint
+func endpointRegion(host string) string {
+	m := awsEndpointRegex.FindStringSubmatch(host)
+	if m == nil || m[1] == "" {
+		return ""
+	}
+	region := m[1][1:]
+	switch {
+	case region == "external-1":
+		return "us-east-1"
+	case strings.HasPrefix(region, "accelerate"):
+		return ""
+	}
+	return region
+}
+
+// parseEndpoint splits a normalized endpoint URL into the host[:port] minio
+// connects to and whether to use TLS. IPv6 addresses are kept in brackets,
+// e.g. [2001:db8::1]:9000.
+func parseEndpoint(endpoint string) (string, bool, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: endpoint %s: %v", ErrInvalidConfig, endpoint, err)
+	}
+	if u.Hostname() == "" {
+		return "", false, fmt.Errorf("%w: endpoint %s: no host name", ErrInvalidConfig, endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", false, fmt.Errorf("%w: endpoint %s: query strings and fragments are not supported", ErrInvalidConfig, endpoint)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", false, fmt.Errorf("%w: endpoint %s: port %s is not between 1 and 65535", ErrInvalidConfig, endpoint, port)
+		}
+	}
+	ssl := u.Scheme == "https"
+	host := u.Hostname()
+	switch {
+	case u.Port() != "":
+		host = net.JoinHostPort(host, u.Port())
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]"
+	}
+	return host, ssl, nil
+}
+
+// endpointPath returns the path of a normalized endpoint URL, unescaped and
+// escaped, for gateways serving S3 under a sub-path like
+// https://gateway.example.com/storage/s3. Both are empty if there is none.
+func endpointPath(endpoint string) (string, string) {
+	u, err := url.Parse(endpoint)
+	if err != nil || strings.Trim(u.Path, "/") == "" {
+		return "", ""
+	}
+	return strings.TrimRight(u.Path, "/"), strings.TrimRight(u.EscapedPath(), "/")
+}
+
+// dualStackEndpoint returns the AWS dual-stack endpoint of region, reachable
+// over IPv6 as well as IPv4, for an AWS endpoint. The plain AWS endpoints are
+// IPv4 only. Other endpoints are returned unchanged.
+func dualStackEndpoint(endpoint, region string) string {
+	m := awsEndpointRegex.FindStringSubmatch(endpoint)
+	if m == nil || region == "" {
+		return endpoint
+	}
+	return "s3.dualstack." + region + ".amazonaws.com" + m[2]
+}
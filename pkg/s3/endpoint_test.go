@@ -0,0 +1,125 @@
+package s3
+
+import "testing"
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "https://storage.yandexcloud.net/", want: "https://storage.yandexcloud.net"},
+		{endpoint: "http://minio:9000", want: "http://minio:9000"},
+		{endpoint: "HTTPS://s3.amazonaws.com", want: "https://s3.amazonaws.com"},
+		{endpoint: "s3.amazonaws.com", want: "https://s3.amazonaws.com"},
+		{endpoint: "s3://s3.us-west-2.amazonaws.com", want: "https://s3.us-west-2.amazonaws.com"},
+		{endpoint: "ftp://example.com", wantErr: true},
+		{endpoint: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEndpoint(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeEndpoint(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeEndpoint(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointRegion(t *testing.T) {
+	tests := map[string]string{
+		"s3.us-west-2.amazonaws.com":        "us-west-2",
+		"s3-eu-west-1.amazonaws.com":        "eu-west-1",
+		"s3.eu-central-1.amazonaws.com:443": "eu-central-1",
+		"s3-external-1.amazonaws.com":       "us-east-1",
+		"s3-accelerate.amazonaws.com":       "",
+		"s3.amazonaws.com":                  "",
+		"storage.yandexcloud.net":           "",
+	}
+	for host, want := range tests {
+		if got := endpointRegion(host); got != want {
+			t.Errorf("endpointRegion(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantSSL  bool
+		wantErr  bool
+	}{
+		{endpoint: "https://storage.yandexcloud.net", want: "storage.yandexcloud.net", wantSSL: true},
+		{endpoint: "http://minio:9000", want: "minio:9000"},
+		{endpoint: "https://[2001:db8::1]:9000", want: "[2001:db8::1]:9000", wantSSL: true},
+		{endpoint: "http://[2001:db8::1]", want: "[2001:db8::1]"},
+		{endpoint: "http://127.0.0.1:9000", want: "127.0.0.1:9000"},
+		{endpoint: "https://", wantErr: true},
+		{endpoint: "https://gw.example.com/s3?bucket=x", wantErr: true},
+		{endpoint: "http://minio:0", wantErr: true},
+		{endpoint: "http://minio:99999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, ssl, err := parseEndpoint(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEndpoint(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || ssl != tt.wantSSL {
+			t.Errorf("parseEndpoint(%q) = %q, %v, want %q, %v", tt.endpoint, got, ssl, tt.want, tt.wantSSL)
+		}
+	}
+}
+
+func TestDualStackEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint, region, want string
+	}{
+		{"s3.amazonaws.com", "eu-west-1", "s3.dualstack.eu-west-1.amazonaws.com"},
+		{"s3.us-west-2.amazonaws.com:443", "us-west-2", "s3.dualstack.us-west-2.amazonaws.com:443"},
+		{"s3.amazonaws.com", "", "s3.amazonaws.com"},
+		{"[2001:db8::1]:9000", "us-east-1", "[2001:db8::1]:9000"},
+	}
+	for _, tt := range tests {
+		if got := dualStackEndpoint(tt.endpoint, tt.region); got != tt.want {
+			t.Errorf("dualStackEndpoint(%q, %q) = %q, want %q", tt.endpoint, tt.region, got, tt.want)
+		}
+	}
+	if got := regionalEndpoint("s3.dualstack.us-east-1.amazonaws.com", "eu-west-1"); got != "s3.dualstack.eu-west-1.amazonaws.com" {
+		t.Errorf("regionalEndpoint() of a dual-stack endpoint = %q", got)
+	}
+	if got := endpointRegion("s3.dualstack.eu-west-1.amazonaws.com"); got != "eu-west-1" {
+		t.Errorf("endpointRegion() of a dual-stack endpoint = %q, want eu-west-1", got)
+	}
+}
+
+func TestNewClientIPv6(t *testing.T) {
+	client, err := NewClient(&Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: "https://[2001:db8::1]:9000"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if host := client.minio.EndpointURL().Host; host != "[2001:db8::1]:9000" {
+		t.Errorf("minio endpoint = %q, want [2001:db8::1]:9000", host)
+	}
+}
+
+func TestEndpointPath(t *testing.T) {
+	tests := []struct {
+		endpoint, path, rawPath string
+	}{
+		{"https://s3.example.com", "", ""},
+		{"https://s3.example.com/", "", ""},
+		{"https://gw.example.com/storage/s3", "/storage/s3", "/storage/s3"},
+		{"https://gw.example.com/storage/s3/", "/storage/s3", "/storage/s3"},
+		{"https://gw.example.com/my%20s3", "/my s3", "/my%20s3"},
+	}
+	for _, tt := range tests {
+		path, rawPath := endpointPath(tt.endpoint)
+		if path != tt.path || rawPath != tt.rawPath {
+			t.Errorf("endpointPath(%q) = %q, %q, want %q, %q", tt.endpoint, path, rawPath, tt.path, tt.rawPath)
+		}
+	}
+}
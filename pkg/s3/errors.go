@@ -0,0 +1,107 @@
+package s3
+
+import "errors"
+
+// Errors returned by the client, possibly wrapped with details. Check for
+// them with errors.Is.
+var (
+	// ErrInvalidConfig is returned for invalid secret keys or volume
+	// parameters
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// ErrAssumeRole is returned when the role to access S3 with can't be
+	// assumed
+	ErrAssumeRole = errors.New("failed to assume role")
+
+	// ErrAnonymousAccess is returned for operations that modify buckets or
+	// objects by clients without credentials
+	ErrAnonymousAccess = errors.New("not allowed with anonymous access")
+
+	// ErrPrefixNotEmpty is returned by CreatePrefix and RenamePrefix when the
+	// prefix already holds data other than the driver's own placeholder and
+	// metadata objects
+	ErrPrefixNotEmpty = errors.New("prefix already exists and is not empty")
+
+	// ErrBucketOwnedByOther is returned by CreateBucket when the bucket name
+	// is already taken by another account
+	ErrBucketOwnedByOther = errors.New("bucket already exists and is owned by someone else")
+
+	// ErrInvalidBucketName is returned by CreateBucket for names breaking
+	// the naming rules of S3
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+
+	// ErrBucketNotAllowed is returned when creating or deleting a bucket, or a
+	// volume in it, that doesn't match the allow-list of the secret
+	ErrBucketNotAllowed = errors.New("bucket is not allowed")
+
+	// ErrObjectsNotRemoved is returned by RemovePrefix and RemoveBucket when
+	// some of the objects couldn't be removed
+	ErrObjectsNotRemoved = errors.New("failed to remove objects")
+
+	// ErrDeleteTooLarge is returned by RemovePrefix and RemoveBucket when the
+	// data to remove exceeds the limits of the secret
+	ErrDeleteTooLarge = errors.New("refusing to remove large volume")
+
+	// ErrBucketNotFound is returned when the bucket of a volume doesn't exist
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrNotFound is returned by GetObject and StatObject when the requested
+	// object doesn't exist
+	ErrNotFound = errors.New("object not found")
+
+	// ErrObjectArchived is returned when reading objects which are in an
+	// archive storage class and not restored, and by RestoreObjects while
+	// restores are pending
+	ErrObjectArchived = errors.New("object is archived and not restored")
+
+	// ErrInvalidVolumeID is returned by ParseVolumeID for IDs which don't
+	// name a bucket and prefix
+	ErrInvalidVolumeID = errors.New("invalid volume ID")
+
+	// ErrInvalidMounter is returned for a mounter name the driver doesn't know
+	ErrInvalidMounter = errors.New("invalid mounter")
+
+	// ErrNotWritable is returned by CheckWritable when the credentials may not
+	// write or delete objects of a volume
+	ErrNotWritable = errors.New("no permission to write to volume")
+
+	// ErrMetadataMismatch is returned by CheckVolumeMeta when the metadata of
+	// a volume names another bucket or prefix
+	ErrMetadataMismatch = errors.New("volume metadata doesn't match")
+
+	// ErrInvalidMetadata is returned by ReadMeta for metadata objects that
+	// can't be decoded
+	ErrInvalidMetadata = errors.New("failed to decode metadata")
+
+	// ErrMetadataTooLarge is returned when writing metadata larger than
+	// any real volume needs, e.g. because of a huge list of mount options
+	ErrMetadataTooLarge = errors.New("volume metadata is too large")
+
+	// ErrMetadataConflict is returned by WriteMeta when the metadata object
+	// has changed since the metadata was read
+	ErrMetadataConflict = errors.New("volume metadata was changed concurrently")
+
+	// ErrInvalidExpiry is returned for presigned URLs with a lifetime S3
+	// doesn't accept
+	ErrInvalidExpiry = errors.New("invalid expiry")
+
+	// ErrLocked is returned by AcquireLock when another operation holds the
+	// lock
+	ErrLocked = errors.New("volume is locked by another operation")
+
+	// ErrVersioningRequired is returned by SetBucketReplication for buckets
+	// without versioning
+	ErrVersioningRequired = errors.New("bucket versioning must be enabled")
+
+	// ErrBucketPreserved is returned by RemoveBucket when the reclaim policy
+	// only allows removing prefixes
+	ErrBucketPreserved = errors.New("bucket is preserved by the reclaim policy")
+
+	// ErrClosed is returned for long-running operations started after the
+	// client was closed or the driver began to shut down
+	ErrClosed = errors.New("client is shutting down")
+
+	// ErrDirectoryBucketUnsupported is returned for S3 Express One Zone
+	// directory buckets, which the driver can't access
+	ErrDirectoryBucketUnsupported = errors.New("directory buckets are not supported")
+)
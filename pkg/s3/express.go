@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// directoryBucketSuffix ends the names of S3 Express One Zone directory
+	// buckets, e.g. "bucket--usw2-az1--x-s3"
+	directoryBucketSuffix = "--x-s3"
+)
+
+// IsDirectoryBucket reports whether the bucket is an S3 Express One Zone
+// directory bucket, judging by its name
+func IsDirectoryBucket(bucketName string) bool {
+	return strings.HasSuffix(bucketName, directoryBucketSuffix)
+}
+
+// CheckDirectoryBucket rejects directory buckets. Their objects can only be
+// accessed with the session credentials of CreateSession, signed for the
+// s3express service, which neither the S3 client of the driver nor the
+// mounters implement.
+func CheckDirectoryBucket(bucketName string) error {
+	if IsDirectoryBucket(bucketName) {
+		return fmt.Errorf("%w: %s is an S3 Express One Zone directory bucket, use a general purpose bucket",
+			ErrDirectoryBucketUnsupported, bucketName)
+	}
+	return nil
+}
+
+// checkGeneralPurposeBucket returns an error naming the operation if it is
+// attempted on a directory bucket. Directory buckets have no versioning,
+// lifecycle, tagging or policy support of their own.
+func checkGeneralPurposeBucket(bucketName, operation string) error {
+	if IsDirectoryBucket(bucketName) {
+		return fmt.Errorf("%w: %s on %s", ErrDirectoryBucketUnsupported, operation, bucketName)
+	}
+	return nil
+}
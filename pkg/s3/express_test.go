@@ -0,0 +1,20 @@
+package s3
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckDirectoryBucket(t *testing.T) {
+	if err := CheckDirectoryBucket("bucket"); err != nil {
+		t.Errorf("CheckDirectoryBucket() of general purpose bucket error = %v", err)
+	}
+	if err := CheckDirectoryBucket("bucket--usw2-az1--x-s3"); !errors.Is(err, ErrDirectoryBucketUnsupported) {
+		t.Errorf("CheckDirectoryBucket() of directory bucket error = %v, want ErrDirectoryBucketUnsupported", err)
+	}
+
+	client, _ := newTestClient(t)
+	if _, err := client.CreateBucket("bucket--usw2-az1--x-s3"); !errors.Is(err, ErrDirectoryBucketUnsupported) {
+		t.Errorf("CreateBucket() of directory bucket error = %v, want ErrDirectoryBucketUnsupported", err)
+	}
+}
@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// failoverTTL is how long new clients keep using a fallback endpoint after
+// the primary one failed, before trying the primary one again
+const failoverTTL = 5 * time.Minute
+
+// activeEndpoints holds the endpoint clients use instead of their primary one
+// after a failover, keyed by the primary endpoint. Like bucketRegions it is
+// shared by all clients, as a new client is created for every request.
+var activeEndpoints = struct {
+	sync.Mutex
+	entries map[string]activeEndpoint
+}{entries: make(map[string]activeEndpoint)}
+
+type activeEndpoint struct {
+	endpoint string
+	expires  time.Time
+}
+
+// ParseFallbackEndpoints splits a comma separated list of endpoints and
+// normalizes them like the primary endpoint
+func ParseFallbackEndpoints(value string) ([]string, error) {
+	var endpoints []string
+	for _, endpoint := range strings.Split(value, ",") {
+		if strings.TrimSpace(endpoint) == "" {
+			continue
+		}
+		normalized, err := normalizeEndpoint(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err = parseEndpoint(normalized); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, normalized)
+	}
+	return endpoints, nil
+}
+
+// cachedEndpoint returns the endpoint to use instead of the primary one, if a
+// client failed over recently
+func (client *s3Client) cachedEndpoint() (string, bool) {
+	activeEndpoints.Lock()
+	defer activeEndpoints.Unlock()
+	entry, ok := activeEndpoints.entries[client.primaryEndpoint]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(activeEndpoints.entries, client.primaryEndpoint)
+		return "", false
+	}
+	return entry.endpoint, true
+}
+
+func (client *s3Client) setCachedEndpoint(endpoint string) {
+	activeEndpoints.Lock()
+	defer activeEndpoints.Unlock()
+	if endpoint == client.primaryEndpoint {
+		delete(activeEndpoints.entries, client.primaryEndpoint)
+		return
+	}
+	activeEndpoints.entries[client.primaryEndpoint] = activeEndpoint{
+		endpoint: endpoint,
+		expires:  time.Now().Add(failoverTTL),
+	}
+}
+
+// failover switches the client to the endpoint after failed if err means
+// that failed is down. failed is the endpoint the request was sent to: if
+// another request failed over in the meantime, the client is left as is. It
+// returns true if the request is worth retrying on the endpoint now in use.
+func (client *s3Client) failover(failed string, err error) bool {
+	if len(client.Config.FallbackEndpoints) == 0 || !isEndpointFailure(err) {
+		return false
+	}
+	client.failoverMutex.Lock()
+	defer client.failoverMutex.Unlock()
+	if client.endpoint() != failed {
+		return true
+	}
+	endpoints := append([]string{client.primaryEndpoint}, client.Config.FallbackEndpoints...)
+	next := endpoints[0]
+	for i, endpoint := range endpoints {
+		if endpoint == failed {
+			next = endpoints[(i+1)%len(endpoints)]
+			break
+		}
+	}
+	client.log().Warningf("Endpoint %s failed, failing over to %s: %v", failed, next, err)
+	if err := client.useEndpoint(next); err != nil {
+		client.log().Errorf("Failed to create client for endpoint %s: %v", next, err)
+		return false
+	}
+	client.setCachedEndpoint(next)
+	return true
+}
+
+// useEndpoint rebuilds the minio clients for another endpoint. The clients
+// are swapped under regionMutex, so requests running concurrently keep the
+// client they started with and later ones use the new endpoint.
+func (client *s3Client) useEndpoint(endpoint string) error {
+	host, ssl, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	client.regionMutex.Lock()
+	defer client.regionMutex.Unlock()
+	// Mounters connect to it too, and the transport takes its path from it
+	previous := client.Config.Endpoint
+	client.Config.Endpoint = endpoint
+	minioClient, err := client.newMinio(host, ssl, client.Config.SigningRegion)
+	if err != nil {
+		client.Config.Endpoint = previous
+		return err
+	}
+	client.minio = minioClient
+	client.regionClients = nil
+	return nil
+}
+
+// endpoint returns the endpoint in use, which a failover may change while
+// requests are running
+func (client *s3Client) endpoint() string {
+	client.regionMutex.Lock()
+	defer client.regionMutex.Unlock()
+	return client.Config.Endpoint
+}
+
+// defaultClient returns the minio client for the endpoint in use
+func (client *s3Client) defaultClient() *minio.Client {
+	client.regionMutex.Lock()
+	defer client.regionMutex.Unlock()
+	return client.minio
+}
+
+// isEndpointFailure reports whether err means that the endpoint can't be
+// reached or fails to serve requests, as opposed to refusing them, e.g. for
+// invalid credentials
+func isEndpointFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	resp := minio.ToErrorResponse(err)
+	// SlowDown is a 503 too, but from a healthy endpoint asking to back off
+	return resp.StatusCode >= http.StatusInternalServerError && resp.Code != "SlowDown"
+}
@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestFailover(t *testing.T) {
+	// Don't wait for minio's retries of the failing endpoint
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	t.Cleanup(func() { minio.MaxRetry = maxRetry })
+
+	base, _ := newTestClient(t, "bucket")
+	fallback := base.Config.Endpoint
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(down.Close)
+	t.Cleanup(func() {
+		activeEndpoints.Lock()
+		delete(activeEndpoints.entries, down.URL)
+		activeEndpoints.Unlock()
+	})
+	newConfig := func() *Config {
+		return &Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: down.URL, Region: "us-east-1",
+			FallbackEndpoints: []string{fallback}}
+	}
+
+	client, err := NewClient(newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.BucketExists("bucket")
+	if err != nil || !exists {
+		t.Fatalf("BucketExists() = %v, %v, want true after failover", exists, err)
+	}
+	if client.Config.Endpoint != fallback {
+		t.Errorf("endpoint after failover = %s, want %s", client.Config.Endpoint, fallback)
+	}
+
+	client, err = NewClient(newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config.Endpoint != fallback {
+		t.Errorf("endpoint of new client = %s, want %s", client.Config.Endpoint, fallback)
+	}
+}
+
+// newFlakyEndpoint returns an endpoint passing requests on to target, except
+// for those fails matches, which it answers with a server error
+func newFlakyEndpoint(t *testing.T, target string, fails func(r *http.Request) bool) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fails(r) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() {
+		activeEndpoints.Lock()
+		delete(activeEndpoints.entries, server.URL)
+		activeEndpoints.Unlock()
+	})
+	return server.URL
+}
+
+func TestFailoverRequests(t *testing.T) {
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	t.Cleanup(func() { minio.MaxRetry = maxRetry })
+
+	for _, tc := range []struct {
+		name  string
+		fails func(r *http.Request) bool
+		op    func(client *s3Client) error
+	}{
+		{
+			name: "list",
+			fails: func(r *http.Request) bool {
+				return r.Method == http.MethodGet && hasParam(r.URL.Query(), "list-type")
+			},
+			op: func(client *s3Client) error {
+				empty, err := client.IsPrefixEmpty("bucket", "src")
+				if err == nil && empty {
+					err = fmt.Errorf("prefix src listed as empty")
+				}
+				return err
+			},
+		},
+		{
+			name: "copy",
+			fails: func(r *http.Request) bool {
+				return r.Header.Get("X-Amz-Copy-Source") != ""
+			},
+			op: func(client *s3Client) error {
+				return client.CopyPrefix("bucket", "src", "bucket", "dst")
+			},
+		},
+		{
+			name: "policy",
+			fails: func(r *http.Request) bool {
+				return hasParam(r.URL.Query(), "policy")
+			},
+			op: func(client *s3Client) error {
+				return client.SetPrefixPolicy("bucket", "src", "arn:aws:iam::123456789012:user/volume")
+			},
+		},
+		{
+			name: "make bucket",
+			fails: func(r *http.Request) bool {
+				return r.Method == http.MethodPut && r.URL.Path == "/new-bucket/"
+			},
+			op: func(client *s3Client) error {
+				_, err := client.CreateBucket("new-bucket")
+				return err
+			},
+		},
+		{
+			name: "remove object",
+			fails: func(r *http.Request) bool {
+				return r.Method == http.MethodDelete
+			},
+			op: func(client *s3Client) error {
+				return client.CheckWritable("bucket", "src")
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, _ := newTestClient(t, "bucket")
+			if err := base.PutObject("bucket", "src/file", []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			fallback := base.Config.Endpoint
+			client, err := NewClient(&Config{AccessKeyID: "key", SecretAccessKey: "secret", Region: "us-east-1",
+				Endpoint: newFlakyEndpoint(t, fallback, tc.fails), FallbackEndpoints: []string{fallback}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tc.op(client); err != nil {
+				t.Fatalf("%s failed: %v", tc.name, err)
+			}
+			if endpoint := client.endpoint(); endpoint != fallback {
+				t.Errorf("endpoint after failover = %s, want %s", endpoint, fallback)
+			}
+		})
+	}
+}
+
+func TestFailoverConcurrent(t *testing.T) {
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	t.Cleanup(func() { minio.MaxRetry = maxRetry })
+
+	base, fake := newTestClient(t, "bucket")
+	fallback := base.Config.Endpoint
+	down := newFlakyEndpoint(t, fallback, func(r *http.Request) bool { return true })
+	client, err := NewClient(&Config{AccessKeyID: "key", SecretAccessKey: "secret", Region: "us-east-1",
+		Endpoint: down, FallbackEndpoints: []string{fallback}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The requests failing at once must fail over only once, and not back to
+	// the endpoint that is down
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = client.PutObject("bucket", fmt.Sprintf("file%d", i), []byte("data"))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("PutObject(file%d) failed: %v", i, err)
+		}
+	}
+	if endpoint := client.endpoint(); endpoint != fallback {
+		t.Errorf("endpoint after failover = %s, want %s", endpoint, fallback)
+	}
+	if n := len(fake.buckets["bucket"]); n != len(errs) {
+		t.Errorf("%d objects written, want %d", n, len(errs))
+	}
+}
+
+func TestIsEndpointFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusBadGateway}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusInternalServerError, Code: "InternalError"}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"}, false},
+		{minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "SignatureDoesNotMatch"}, false},
+	} {
+		if got := isEndpointFailure(tc.err); got != tc.want {
+			t.Errorf("isEndpointFailure(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
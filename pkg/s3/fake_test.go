@@ -0,0 +1,589 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// fakeObject is an object stored by fakeS3
+type fakeObject struct {
+	data   []byte
+	header http.Header
+}
+
+// fakeS3 is a minimal in-memory S3 server implementing the requests made by
+// s3Client, using path-style addressing
+type fakeS3 struct {
+	sync.Mutex
+	buckets map[string]map[string]*fakeObject
+	// versioning holds the versioning status of the buckets. Versions
+	// themselves are not kept.
+	versioning map[string]string
+	// throttleDeletes makes that many multi-object deletes fail with
+	// SlowDown for every object
+	throttleDeletes int
+	// deleteBatches records the number of objects of every multi-object
+	// delete
+	deleteBatches []int
+	// rejectListV2 makes ListObjectsV2 fail like on old gateways
+	rejectListV2 bool
+	// failListPages makes listings fail after their first page
+	failListPages bool
+	// denyPuts makes writes and copies to keys with this suffix fail with
+	// AccessDenied
+	denyPuts string
+	// denyDeletes makes removals of keys with this suffix fail with
+	// AccessDenied, like objects under a legal hold
+	denyDeletes string
+	// regions makes requests to these buckets signed for another region
+	// fail with a redirect naming the bucket's region
+	regions map[string]string
+	// uploads holds the keys of incomplete multipart uploads by bucket and
+	// upload ID
+	uploads map[string]map[string]string
+	// locations holds the location constraints sent when creating buckets
+	locations map[string]string
+	// replication holds the replication configurations of the buckets
+	replication map[string]string
+	// notifications holds the notification configurations of the buckets
+	notifications map[string]string
+	// policies holds the policies of the buckets
+	policies map[string]string
+	// encrypted makes the ETags of objects differ from the MD5 of their data,
+	// like those of objects encrypted with SSE-KMS or SSE-C
+	encrypted bool
+	// copyLimit makes copies, and parts of multipart copies, larger than
+	// this fail, like on gateways limiting the size of parts
+	copyLimit int
+	// parts holds the parts copied into multipart uploads by upload ID
+	parts map[string]map[int][]byte
+}
+
+// newTestClient starts a fakeS3 with the given buckets and returns a client
+// connected to it
+func newTestClient(t *testing.T, buckets ...string) (*s3Client, *fakeS3) {
+	fake := &fakeS3{buckets: make(map[string]map[string]*fakeObject)}
+	for _, bucket := range buckets {
+		fake.buckets[bucket] = make(map[string]*fakeObject)
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	minioClient, err := minio.New(u.Host, &minio.Options{
+		Creds:     credentials.NewStaticV4("key", "secret", ""),
+		Region:    "us-east-1",
+		Transport: &headerTransport{http.DefaultTransport},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: server.URL}
+	return NewClientWithMinio(cfg, minioClient), fake
+}
+
+// keys returns the sorted keys of a bucket
+func (f *fakeS3) keys(bucket string) []string {
+	f.Lock()
+	defer f.Unlock()
+	keys := make([]string, 0)
+	for key := range f.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// put stores an object directly
+func (f *fakeS3) put(bucket, key string, data string) {
+	f.Lock()
+	defer f.Unlock()
+	f.buckets[bucket][key] = &fakeObject{data: []byte(data), header: make(http.Header)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucketName := parts[0]
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+	bucket, bucketExists := f.buckets[bucketName]
+	if region, ok := f.regions[bucketName]; ok {
+		if signed, _ := signingRegion(r); signed != region {
+			w.Header().Set("X-Amz-Bucket-Region", region)
+			writeError(w, http.StatusMovedPermanently, "PermanentRedirect")
+			return
+		}
+	}
+
+	if bucketName == "" {
+		// ListBuckets
+		fmt.Fprint(w, `<ListAllMyBucketsResult><Buckets>`)
+		for name := range f.buckets {
+			fmt.Fprintf(w, `<Bucket><Name>%s</Name></Bucket>`, name)
+		}
+		fmt.Fprint(w, `</Buckets></ListAllMyBucketsResult>`)
+		return
+	}
+	if key == "" {
+		switch {
+		case r.Method == http.MethodPut && bucketExists && hasParam(query, "replication"):
+			data, _ := ioutil.ReadAll(r.Body)
+			if f.replication == nil {
+				f.replication = make(map[string]string)
+			}
+			f.replication[bucketName] = string(data)
+		case r.Method == http.MethodPut && bucketExists && hasParam(query, "notification"):
+			data, _ := ioutil.ReadAll(r.Body)
+			if f.notifications == nil {
+				f.notifications = make(map[string]string)
+			}
+			f.notifications[bucketName] = string(data)
+		case bucketExists && hasParam(query, "policy"):
+			f.policy(w, r, bucketName)
+		case r.Method == http.MethodPut && !hasParam(query, "versioning"):
+			if bucketExists {
+				writeError(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+				return
+			}
+			var config struct{ LocationConstraint string }
+			xml.NewDecoder(r.Body).Decode(&config)
+			if f.locations == nil {
+				f.locations = make(map[string]string)
+			}
+			f.locations[bucketName] = config.LocationConstraint
+			f.buckets[bucketName] = make(map[string]*fakeObject)
+		case !bucketExists:
+			writeError(w, http.StatusNotFound, "NoSuchBucket")
+		case r.Method == http.MethodHead:
+		case r.Method == http.MethodDelete:
+			if len(bucket) > 0 || len(f.uploads[bucketName]) > 0 {
+				writeError(w, http.StatusConflict, "BucketNotEmpty")
+				return
+			}
+			delete(f.buckets, bucketName)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && hasParam(query, "versioning"):
+			fmt.Fprintf(w, `<VersioningConfiguration><Status>%s</Status></VersioningConfiguration>`, f.versioning[bucketName])
+		case r.Method == http.MethodPut && hasParam(query, "versioning"):
+			var config struct{ Status string }
+			xml.NewDecoder(r.Body).Decode(&config)
+			if f.versioning == nil {
+				f.versioning = make(map[string]string)
+			}
+			f.versioning[bucketName] = config.Status
+		case r.Method == http.MethodGet && hasParam(query, "location"):
+			fmt.Fprint(w, `<LocationConstraint>us-east-1</LocationConstraint>`)
+		case r.Method == http.MethodGet && hasParam(query, "uploads"):
+			f.listUploads(w, bucketName, query.Get("prefix"))
+		case r.Method == http.MethodGet && f.rejectListV2 && query.Get("list-type") == "2":
+			writeError(w, http.StatusNotImplemented, "NotImplemented")
+		case r.Method == http.MethodGet:
+			f.list(w, bucket, query)
+		case r.Method == http.MethodPost && hasParam(query, "delete"):
+			f.deleteMulti(w, r, bucket)
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+
+	if !bucketExists {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if f.denyPuts != "" && strings.HasSuffix(key, f.denyPuts) {
+			writeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			if uploadID := query.Get("uploadId"); uploadID != "" {
+				f.copyPart(w, r, uploadID, source)
+				return
+			}
+			f.copy(w, bucketName, key, source)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data = decodeChunked(data)
+		}
+		if r.Header.Get("If-None-Match") == "*" && bucket[key] != nil {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" {
+			if bucket[key] == nil {
+				writeError(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			// S3 accepts ETags with or without quotes
+			if strings.Trim(f.etag(bucket[key].data), `"`) != strings.Trim(match, `"`) {
+				writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		bucket[key] = &fakeObject{data: data, header: r.Header.Clone()}
+		w.Header().Set("ETag", f.etag(data))
+	case http.MethodPost:
+		if hasParam(query, "uploads") {
+			f.startUpload(w, bucketName, key)
+			return
+		}
+		if uploadID := query.Get("uploadId"); uploadID != "" {
+			f.completeUpload(w, bucketName, key, uploadID)
+			return
+		}
+		if !hasParam(query, "restore") || bucket[key] == nil {
+			writeError(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		if bucket[key].header.Get("X-Amz-Restore") != "" {
+			writeError(w, http.StatusConflict, "RestoreAlreadyInProgress")
+			return
+		}
+		bucket[key].header.Set("X-Amz-Restore", `ongoing-request="true"`)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet, http.MethodHead:
+		obj := bucket[key]
+		if obj == nil {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		restore := obj.header.Get("X-Amz-Restore")
+		if r.Method == http.MethodGet && isArchived(obj) && !strings.Contains(restore, `ongoing-request="false"`) {
+			writeError(w, http.StatusForbidden, "InvalidObjectState")
+			return
+		}
+		if restore != "" {
+			w.Header().Set("X-Amz-Restore", restore)
+		}
+		contentType := obj.header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "binary/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", f.etag(obj.data))
+		w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		if uploadID := query.Get("uploadId"); uploadID != "" {
+			delete(f.uploads[bucketName], uploadID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if f.denyDeletes != "" && strings.HasSuffix(key, f.denyDeletes) {
+			writeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// hasParam reports whether a query has a parameter, with or without a value,
+// like versioning in ?versioning. url.Values.Has needs Go 1.17.
+func hasParam(query url.Values, key string) bool {
+	_, ok := query[key]
+	return ok
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket map[string]*fakeObject, query url.Values) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Prefix                string
+		Delimiter             string
+		KeyCount              int
+		IsTruncated           bool
+		NextMarker            string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{Prefix: query.Get("prefix"), Delimiter: query.Get("delimiter")}
+	marker := query.Get("marker") + query.Get("start-after") + query.Get("continuation-token")
+	if f.failListPages && marker != "" {
+		// Not one of the errors minio retries
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	maxKeys := 1000
+	fmt.Sscan(query.Get("max-keys"), &maxKeys)
+
+	keys := make([]string, 0, len(bucket))
+	for key := range bucket {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, result.Prefix) || key <= marker {
+			continue
+		}
+		// Keys rolled up into the common prefix ending the previous page
+		if result.Delimiter != "" && strings.HasSuffix(marker, result.Delimiter) && strings.HasPrefix(key, marker) {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if result.Delimiter != "" {
+			rest := key[len(result.Prefix):]
+			if i := strings.Index(rest, result.Delimiter); i >= 0 {
+				p := result.Prefix + rest[:i+len(result.Delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, content{key, int64(len(bucket[key].data)), f.etag(bucket[key].data),
+			bucket[key].header.Get("X-Amz-Storage-Class")})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	if result.IsTruncated && len(result.Contents) > 0 {
+		result.NextMarker = result.Contents[len(result.Contents)-1].Key
+	}
+	if n := len(result.CommonPrefixes); result.IsTruncated && n > 0 && result.CommonPrefixes[n-1].Prefix > result.NextMarker {
+		result.NextMarker = result.CommonPrefixes[n-1].Prefix
+	}
+	result.NextContinuationToken = result.NextMarker
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) policy(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if f.policies == nil {
+		f.policies = make(map[string]string)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		policy, ok := f.policies[bucketName]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchBucketPolicy")
+			return
+		}
+		fmt.Fprint(w, policy)
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.policies[bucketName] = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(f.policies, bucketName)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// addUpload starts a multipart upload which is never completed
+func (f *fakeS3) addUpload(bucket, key, uploadID string) {
+	f.Lock()
+	defer f.Unlock()
+	if f.uploads == nil {
+		f.uploads = make(map[string]map[string]string)
+	}
+	if f.uploads[bucket] == nil {
+		f.uploads[bucket] = make(map[string]string)
+	}
+	f.uploads[bucket][uploadID] = key
+}
+
+func (f *fakeS3) listUploads(w http.ResponseWriter, bucketName, prefix string) {
+	fmt.Fprint(w, `<ListMultipartUploadsResult>`)
+	for uploadID, key := range f.uploads[bucketName] {
+		if strings.HasPrefix(key, prefix) {
+			fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId></Upload>`, key, uploadID)
+		}
+	}
+	fmt.Fprint(w, `<IsTruncated>false</IsTruncated></ListMultipartUploadsResult>`)
+}
+
+func (f *fakeS3) copy(w http.ResponseWriter, bucketName, key, source string) {
+	source, _ = url.PathUnescape(strings.TrimPrefix(source, "/"))
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 || f.buckets[parts[0]] == nil || f.buckets[parts[0]][parts[1]] == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	obj := f.buckets[parts[0]][parts[1]]
+	if f.copyLimit > 0 && len(obj.data) > f.copyLimit {
+		writeError(w, http.StatusBadRequest, "EntityTooLarge")
+		return
+	}
+	f.buckets[bucketName][key] = &fakeObject{data: obj.data, header: obj.header.Clone()}
+	fmt.Fprintf(w, `<CopyObjectResult><ETag>%s</ETag><LastModified>%s</LastModified></CopyObjectResult>`,
+		f.etag(obj.data), time.Unix(0, 0).UTC().Format(time.RFC3339))
+}
+
+// startUpload starts a multipart upload that parts can be copied into
+func (f *fakeS3) startUpload(w http.ResponseWriter, bucketName, key string) {
+	if f.uploads == nil {
+		f.uploads = make(map[string]map[string]string)
+	}
+	if f.uploads[bucketName] == nil {
+		f.uploads[bucketName] = make(map[string]string)
+	}
+	if f.parts == nil {
+		f.parts = make(map[string]map[int][]byte)
+	}
+	uploadID := fmt.Sprintf("upload-%d", len(f.parts)+1)
+	f.uploads[bucketName][uploadID] = key
+	f.parts[uploadID] = make(map[int][]byte)
+	fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`,
+		bucketName, key, uploadID)
+}
+
+// completeUpload joins the parts of a multipart upload into its object
+func (f *fakeS3) completeUpload(w http.ResponseWriter, bucketName, key, uploadID string) {
+	if f.parts[uploadID] == nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var data []byte
+	for i := 1; i <= len(f.parts[uploadID]); i++ {
+		data = append(data, f.parts[uploadID][i]...)
+	}
+	delete(f.uploads[bucketName], uploadID)
+	delete(f.parts, uploadID)
+	f.buckets[bucketName][key] = &fakeObject{data: data, header: make(http.Header)}
+	fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>`,
+		bucketName, key, f.etag(data))
+}
+
+// copyPart copies the range of the source object in x-amz-copy-source-range
+// into a part of a multipart upload
+func (f *fakeS3) copyPart(w http.ResponseWriter, r *http.Request, uploadID, source string) {
+	source, _ = url.PathUnescape(strings.TrimPrefix(source, "/"))
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 || f.buckets[parts[0]] == nil || f.buckets[parts[0]][parts[1]] == nil || f.parts[uploadID] == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	data := f.buckets[parts[0]][parts[1]].data
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); err == nil {
+		data = data[start : end+1]
+	}
+	if f.copyLimit > 0 && len(data) > f.copyLimit {
+		writeError(w, http.StatusBadRequest, "EntityTooLarge")
+		return
+	}
+	partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	f.parts[uploadID][partNumber] = data
+	fmt.Fprintf(w, `<CopyPartResult><ETag>%s</ETag><LastModified>%s</LastModified></CopyPartResult>`,
+		f.etag(data), time.Unix(0, 0).UTC().Format(time.RFC3339))
+}
+
+func (f *fakeS3) deleteMulti(w http.ResponseWriter, r *http.Request, bucket map[string]*fakeObject) {
+	var req struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	f.deleteBatches = append(f.deleteBatches, len(req.Objects))
+	if f.throttleDeletes > 0 {
+		f.throttleDeletes--
+		fmt.Fprint(w, `<DeleteResult>`)
+		for _, obj := range req.Objects {
+			fmt.Fprintf(w, `<Error><Key>%s</Key><Code>SlowDown</Code><Message>Slow down</Message></Error>`, obj.Key)
+		}
+		fmt.Fprint(w, `</DeleteResult>`)
+		return
+	}
+	fmt.Fprint(w, `<DeleteResult>`)
+	for _, obj := range req.Objects {
+		if f.denyDeletes != "" && strings.HasSuffix(obj.Key, f.denyDeletes) {
+			fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, obj.Key)
+			continue
+		}
+		delete(bucket, obj.Key)
+	}
+	fmt.Fprint(w, `</DeleteResult>`)
+}
+
+// isArchived reports whether an object has to be restored before reading it
+func isArchived(obj *fakeObject) bool {
+	return archiveStorageClasses[obj.header.Get("X-Amz-Storage-Class")]
+}
+
+// decodeChunked strips the chunk signatures of a streaming signed upload
+func decodeChunked(body []byte) []byte {
+	var data []byte
+	for len(body) > 0 {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			break
+		}
+		var size int
+		fmt.Sscanf(string(body[:i]), "%x;", &size)
+		body = body[i+2:]
+		if size == 0 || size > len(body) {
+			break
+		}
+		data = append(data, body[:size]...)
+		body = body[size+2:]
+	}
+	return data
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// etag returns the ETag of an object with the given data
+func (f *fakeS3) etag(data []byte) string {
+	if f.encrypted {
+		return etag(append([]byte("encrypted:"), data...))
+	}
+	return etag(data)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
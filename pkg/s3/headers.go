@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// reservedHeaders are set by minio or the HTTP client, or are part of the
+// signature, so they can't be custom headers
+var reservedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Md5":       true,
+	"Content-Type":      true,
+	"Date":              true,
+	"Expect":            true,
+	"Host":              true,
+	"Range":             true,
+	"Transfer-Encoding": true,
+	"User-Agent":        true,
+}
+
+// ParseCustomHeaders parses the custom headers of a secret, given as a comma
+// separated list of name=value pairs. Errors never contain the values, which
+// may be API keys.
+func ParseCustomHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" {
+			return nil, fmt.Errorf("%w: customHeaders: expected name=value pairs", ErrInvalidConfig)
+		}
+		headers[name] = strings.TrimSpace(kv[1])
+	}
+	if err := ValidateCustomHeaders(headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// ValidateCustomHeaders checks the names and values of custom headers.
+// x-amz-* headers are refused as S3 requires them to be signed, which the
+// custom headers aren't.
+func ValidateCustomHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		switch {
+		case !httpguts.ValidHeaderFieldName(name):
+			return fmt.Errorf("%w: custom header %q is not a valid header name", ErrInvalidConfig, name)
+		case reservedHeaders[canonical]:
+			return fmt.Errorf("%w: custom header %s is set by the driver", ErrInvalidConfig, canonical)
+		case strings.HasPrefix(canonical, "X-Amz-"):
+			return fmt.Errorf("%w: custom header %s can't be an x-amz-* header, those have to be signed", ErrInvalidConfig, canonical)
+		case !httpguts.ValidHeaderFieldValue(value):
+			return fmt.Errorf("%w: value of custom header %s contains invalid characters", ErrInvalidConfig, canonical)
+		}
+	}
+	return nil
+}
+
+// customHeaderTransport adds the custom headers of the config to every
+// request. They are added after minio has signed the request: gateways in
+// front of S3 check them, S3 doesn't need them signed.
+type customHeaderTransport struct {
+	http.RoundTripper
+	headers map[string]string
+}
+
+func (t *customHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,47 @@
+package s3
+
+import (
+	"errors"
+	"net"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// errorHints tell users how to fix the S3 errors they are most likely to
+// run into when setting up volumes, by error code
+var errorHints = map[string]string{
+	"AccessDenied":                       "check that the credentials in the secret are allowed to access the bucket",
+	"InvalidAccessKeyId":                 "check accessKeyID in the secret",
+	"SignatureDoesNotMatch":              "check secretAccessKey in the secret, and signingRegion for gateways with a fixed region",
+	"ExpiredToken":                       "the session credentials have expired, check the credentials in the secret",
+	"InvalidToken":                       "check the session token of the credentials in the secret",
+	"RequestTimeTooSkewed":               "the clock of the node is off, check its time synchronization",
+	"AuthorizationHeaderMalformed":       "check region in the secret, or set regionDiscovery",
+	"PermanentRedirect":                  "the bucket is in another region, check region in the secret, or set regionDiscovery",
+	"AllAccessDisabled":                  "access to the account has been disabled by the provider",
+	"BucketAlreadyExists":                "the bucket name is taken by another account, choose another bucket",
+	"InvalidBucketName":                  "bucket names must be 3 to 63 lowercase letters, digits, dots and hyphens",
+	"TooManyBuckets":                     "the account has reached its limit of buckets, create volumes in a shared bucket instead",
+	"InvalidLocationConstraint":          "check region in the secret, or set noLocationConstraint for backends without regions",
+	"IllegalLocationConstraintException": "check region in the secret, or set noLocationConstraint for backends without regions",
+	"NoSuchBucket":                       "the bucket doesn't exist, check the bucket of the volume",
+	"SlowDown":                           "the endpoint throttles requests, the request will be retried",
+}
+
+// networkHint is the hint for errors reaching the endpoint
+const networkHint = "check that the endpoint in the secret is reachable from the driver"
+
+// ErrorHint returns the S3 error code of err, if any, and a hint on how to
+// fix it, for errors shown to users. ok is false if there is no hint for err.
+func ErrorHint(err error) (code, hint string, ok bool) {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		hint, ok = errorHints[resp.Code]
+		return resp.Code, hint, ok
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "", networkHint, true
+	}
+	return "", "", false
+}
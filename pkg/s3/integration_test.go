@@ -0,0 +1,242 @@
+//go:build integration
+// +build integration
+
+package s3
+
+// The integration tests run against a real S3 backend, MinIO by default:
+//
+//	go test -tags integration ./pkg/s3
+//
+// They use the endpoint in S3_TEST_ENDPOINT, with the keys in
+// S3_TEST_ACCESS_KEY and S3_TEST_SECRET_KEY, or start a MinIO container
+// with docker, from the image in S3_TEST_MINIO_IMAGE if set.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	integrationAccessKey  = "integration"
+	integrationSecretKey  = "integration-secret"
+	integrationMinioImage = "minio/minio"
+	// integrationStartTimeout bounds the wait for the MinIO container
+	integrationStartTimeout = time.Minute
+)
+
+// integrationEndpoint is the endpoint of the backend all tests use
+var integrationEndpoint, integrationAccess, integrationSecret string
+
+func TestMain(m *testing.M) {
+	stop, err := startBackend()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start S3 backend: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startBackend sets up the endpoint of the tests and returns the function
+// stopping the backend
+func startBackend() (func(), error) {
+	if endpoint := os.Getenv("S3_TEST_ENDPOINT"); endpoint != "" {
+		integrationEndpoint = endpoint
+		integrationAccess = os.Getenv("S3_TEST_ACCESS_KEY")
+		integrationSecret = os.Getenv("S3_TEST_SECRET_KEY")
+		return func() {}, nil
+	}
+	image := os.Getenv("S3_TEST_MINIO_IMAGE")
+	if image == "" {
+		image = integrationMinioImage
+	}
+	out, err := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::9000",
+		"--env", "MINIO_ROOT_USER="+integrationAccessKey, "--env", "MINIO_ROOT_PASSWORD="+integrationSecretKey,
+		image, "server", "/data").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker run: %v", err)
+	}
+	container := strings.TrimSpace(string(out))
+	stop := func() {
+		exec.Command("docker", "rm", "--force", container).Run()
+	}
+	out, err = exec.Command("docker", "port", container, "9000/tcp").Output()
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("docker port: %v", err)
+	}
+	// One line per address family, the first one is enough
+	address := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	integrationEndpoint = "http://" + address
+	integrationAccess, integrationSecret = integrationAccessKey, integrationSecretKey
+
+	deadline := time.Now().Add(integrationStartTimeout)
+	for {
+		resp, err := http.Get(integrationEndpoint + "/minio/health/ready")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return stop, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("MinIO at %s not ready after %v", integrationEndpoint, integrationStartTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// newIntegrationClient returns a client of the backend and the name of a
+// new bucket, which is removed after the test
+func newIntegrationClient(t *testing.T, versioned bool) (*s3Client, string) {
+	client, err := NewClient(&Config{
+		AccessKeyID:     integrationAccess,
+		SecretAccessKey: integrationSecret,
+		Endpoint:        integrationEndpoint,
+		Region:          "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	bucketName := "csi-s3-test-" + hex.EncodeToString(b)
+	if _, err = client.CreateBucket(bucketName); err != nil {
+		t.Fatalf("CreateBucket(%s) error = %v", bucketName, err)
+	}
+	t.Cleanup(func() {
+		if _, err := client.RemoveBucket(bucketName); err != nil {
+			t.Errorf("RemoveBucket(%s) error = %v", bucketName, err)
+		}
+	})
+	if versioned {
+		if err = client.SetBucketVersioning(bucketName, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client, bucketName
+}
+
+// putObjects writes objects with the given keys, twice in versioned
+// buckets, so that there are older versions to remove as well
+func putObjects(t *testing.T, client *s3Client, bucketName string, keys ...string) {
+	for _, key := range keys {
+		for i := 0; i < 2; i++ {
+			data := []byte(fmt.Sprintf("%s %d", key, i))
+			_, err := client.minio.PutObject(client.ctx, bucketName, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// remainingKeys returns the keys of all objects, versions and delete markers
+// under prefix
+func remainingKeys(t *testing.T, client *s3Client, bucketName, prefix string) []string {
+	var keys []string
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: true}
+	for object := range client.minio.ListObjects(client.ctx, bucketName, opts) {
+		if object.Err != nil {
+			t.Fatal(object.Err)
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+func TestIntegrationCreateBucket(t *testing.T) {
+	client, bucketName := newIntegrationClient(t, false)
+	exists, err := client.BucketExists(bucketName)
+	if err != nil || !exists {
+		t.Errorf("BucketExists(%s) = %v, %v, want true", bucketName, exists, err)
+	}
+	if _, err = client.CreateBucket(bucketName); err == nil {
+		t.Errorf("CreateBucket() of existing bucket succeeded")
+	}
+}
+
+func TestIntegrationRemovePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		versioned bool
+		resumable bool
+	}{
+		{"unversioned", false, false},
+		{"unversioned resumable", false, true},
+		{"versioned", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, bucketName := newIntegrationClient(t, tc.versioned)
+			client.Config.ResumableDelete = tc.resumable
+			client.Config.ListMaxKeys = 2
+
+			if err := client.CreatePrefix(bucketName, "vol"); err != nil {
+				t.Fatalf("CreatePrefix() error = %v", err)
+			}
+			if err := client.CreatePrefix(bucketName, "vol"); err != nil {
+				t.Errorf("CreatePrefix() of empty existing prefix error = %v", err)
+			}
+			putObjects(t, client, bucketName, "vol/a", "vol/b", "vol/dir/c", "vol/dir/d", "vol10/e")
+			if err := client.CreatePrefix(bucketName, "vol"); err == nil {
+				t.Errorf("CreatePrefix() of prefix with data succeeded")
+			}
+
+			stats, err := client.RemovePrefix(bucketName, "vol")
+			if err != nil {
+				t.Fatalf("RemovePrefix() error = %v", err)
+			}
+			if stats.Failed != 0 {
+				t.Errorf("RemovePrefix() failed to remove %d objects", stats.Failed)
+			}
+			if keys := remainingKeys(t, client, bucketName, "vol/"); len(keys) != 0 {
+				t.Errorf("keys left in prefix = %v, want none", keys)
+			}
+			// Another volume sharing the name as a prefix is kept
+			if keys := remainingKeys(t, client, bucketName, "vol10/"); len(keys) == 0 {
+				t.Error("vol10 was removed along with vol")
+			}
+		})
+	}
+}
+
+func TestIntegrationRemoveObjects(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		versioned bool
+		oneByOne  bool
+	}{
+		{"bulk", false, false},
+		{"bulk versioned", true, false},
+		{"one by one", false, true},
+		{"one by one versioned", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, bucketName := newIntegrationClient(t, tc.versioned)
+			putObjects(t, client, bucketName, "vol/a", "vol/b", "vol/dir/c")
+
+			remove := client.removeObjects
+			if tc.oneByOne {
+				remove = client.removeObjectsOneByOne
+			}
+			if _, err := remove(bucketName, "vol/"); err != nil {
+				t.Fatalf("remove error = %v", err)
+			}
+			if keys := remainingKeys(t, client, bucketName, "vol/"); len(keys) != 0 {
+				t.Errorf("keys left = %v, want none", keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	lockName = ".lock.json"
+)
+
+type lockInfo struct {
+	Owner   string    `json:"Owner"`
+	Expires time.Time `json:"Expires"`
+}
+
+// AcquireLock takes a lock object under the prefix of a volume, so that
+// concurrent CreateVolume/DeleteVolume calls for the same volume are
+// serialized. The lock is created with a conditional write (If-None-Match),
+// a lock older than its TTL is taken over. Backends which ignore conditional
+// writes make the lock a no-op.
+func (client *s3Client) AcquireLock(bucketName, prefix string, ttl time.Duration) error {
+	key := path.Join(prefix, lockName)
+	err := client.putLock(bucketName, key, ttl, map[string]string{"If-None-Match": "*"})
+	if err == nil || minio.ToErrorResponse(err).Code != "PreconditionFailed" {
+		return err
+	}
+
+	// Lock is held, check if it's stale. The lock and its ETag are read with
+	// a single request, as it may be released at any time.
+	var obj io.ReadCloser
+	var stat minio.ObjectInfo
+	err = client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		obj, stat, _, err = minio.Core{Client: c}.GetObject(client.ctx, bucketName, key, minio.GetObjectOptions{})
+		return err
+	})
+	if isNotFound(err) {
+		// Released in the meantime, try again
+		return client.putLock(bucketName, key, ttl, map[string]string{"If-None-Match": "*"})
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	var lock lockInfo
+	if err = json.NewDecoder(obj).Decode(&lock); err != nil {
+		return fmt.Errorf("failed to decode lock %s/%s: %v", bucketName, key, err)
+	}
+	if lock.Owner == client.lockOwner() {
+		// Already ours, extend it
+		return client.putLock(bucketName, key, ttl, map[string]string{"If-Match": stat.ETag})
+	}
+	if time.Now().Before(lock.Expires) {
+		return fmt.Errorf("%w: %s/%s held by %s until %s", ErrLocked, bucketName, prefix, lock.Owner, lock.Expires.Format(time.RFC3339))
+	}
+	client.log().Warningf("Taking over stale lock %s/%s of %s, expired at %s", bucketName, key, lock.Owner, lock.Expires.Format(time.RFC3339))
+	err = client.putLock(bucketName, key, ttl, map[string]string{"If-Match": stat.ETag})
+	if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+		// Someone else took it over first
+		return fmt.Errorf("%w: %s/%s", ErrLocked, bucketName, prefix)
+	}
+	return err
+}
+
+// ReleaseLock removes the lock taken by AcquireLock if it's still held by
+// this client
+func (client *s3Client) ReleaseLock(bucketName, prefix string) error {
+	key := path.Join(prefix, lockName)
+	obj, err := client.GetObject(bucketName, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Removed along with the prefix
+			return nil
+		}
+		return err
+	}
+	var lock lockInfo
+	err = json.NewDecoder(obj).Decode(&lock)
+	obj.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode lock %s/%s: %v", bucketName, key, err)
+	}
+	if lock.Owner != client.lockOwner() {
+		client.log().Warningf("Lock %s/%s was taken over by %s, not releasing it", bucketName, key, lock.Owner)
+		return nil
+	}
+	return client.removeObject(client.ctx, bucketName, key, minio.RemoveObjectOptions{})
+}
+
+// removeLock removes the lock of a prefix, with all its versions, once the
+// prefix is removed, whoever holds it
+func (client *s3Client) removeLock(bucketName, prefix string) error {
+	key := path.Join(prefix, lockName)
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	objectsCh, listResult := client.listObjects(ctx, bucketName, client.removeListOptions(bucketName, key))
+	for object := range objectsCh {
+		if object.Key != key {
+			continue
+		}
+		err := client.removeObject(client.ctx, bucketName, key,
+			minio.RemoveObjectOptions{VersionID: object.VersionID, GovernanceBypass: true})
+		if err != nil && !isNotFound(err) {
+			cancel()
+			listResult()
+			return err
+		}
+	}
+	if err := listResult(); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (client *s3Client) putLock(bucketName, key string, ttl time.Duration, headers map[string]string) error {
+	data, err := json.Marshal(&lockInfo{
+		Owner:   client.lockOwner(),
+		Expires: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return client.withRetry(bucketName, func(c *minio.Client) error {
+		_, err := c.PutObject(
+			withHeaders(client.ctx, headers), bucketName, key,
+			bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: metadataContentType},
+		)
+		return err
+	})
+}
+
+// lockOwner returns the random ID identifying the locks taken by this client
+func (client *s3Client) lockOwner() string {
+	if client.lockID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		client.lockID = hex.EncodeToString(b)
+	}
+	return client.lockID
+}
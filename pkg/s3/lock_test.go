@@ -0,0 +1,93 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	other := NewClientWithMinio(client.Config, client.minio)
+
+	if err := client.AcquireLock("bucket", "vol", time.Minute); err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+	if err := client.AcquireLock("bucket", "vol", time.Minute); err != nil {
+		t.Errorf("AcquireLock() of own lock error = %v", err)
+	}
+	if err := other.AcquireLock("bucket", "vol", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Errorf("AcquireLock() of held lock error = %v, want ErrLocked", err)
+	}
+
+	// An expired lock is taken over
+	fake.put("bucket", "stale/"+lockName, fmt.Sprintf(`{"Owner":"gone","Expires":%q}`,
+		time.Now().Add(-time.Minute).Format(time.RFC3339)))
+	if err := client.AcquireLock("bucket", "stale", time.Minute); err != nil {
+		t.Fatalf("AcquireLock() of stale lock error = %v", err)
+	}
+	if err := other.AcquireLock("bucket", "stale", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Errorf("AcquireLock() of taken over lock error = %v, want ErrLocked", err)
+	}
+}
+
+func TestReleaseLock(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	other := NewClientWithMinio(client.Config, client.minio)
+
+	if err := client.AcquireLock("bucket", "vol", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// Locks of others are left alone
+	if err := other.ReleaseLock("bucket", "vol"); err != nil {
+		t.Errorf("ReleaseLock() of lock held by another error = %v", err)
+	}
+	if got, want := fake.keys("bucket"), []string{"vol/" + lockName}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after ReleaseLock() by another = %v, want %v", got, want)
+	}
+	if err := client.ReleaseLock("bucket", "vol"); err != nil {
+		t.Errorf("ReleaseLock() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys after ReleaseLock() = %v, want none", got)
+	}
+	if err := client.ReleaseLock("bucket", "vol"); err != nil {
+		t.Errorf("ReleaseLock() of missing lock error = %v", err)
+	}
+}
+
+func TestRemovePrefixKeepsLock(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	other := NewClientWithMinio(client.Config, client.minio)
+	fake.put("bucket", "vol/", "")
+	fake.put("bucket", "vol/a", "data")
+	fake.put("bucket", "vol/held", "data")
+	if err := client.AcquireLock("bucket", "vol", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// The volume stays locked until its contents are gone
+	fake.denyDeletes = "held"
+	if _, err := client.RemovePrefix("bucket", "vol"); err == nil {
+		t.Fatal("RemovePrefix() with objects left succeeded")
+	}
+	if got, want := fake.keys("bucket"), []string{"vol/" + lockName, "vol/held"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after failed RemovePrefix() = %v, want %v", got, want)
+	}
+	if err := other.AcquireLock("bucket", "vol", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Errorf("AcquireLock() during removal error = %v, want ErrLocked", err)
+	}
+
+	fake.denyDeletes = ""
+	if _, err := client.RemovePrefix("bucket", "vol"); err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys after RemovePrefix() = %v, want none", got)
+	}
+	if err := client.ReleaseLock("bucket", "vol"); err != nil {
+		t.Errorf("ReleaseLock() after RemovePrefix() error = %v", err)
+	}
+}
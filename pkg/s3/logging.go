@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// NewOperationID returns a random ID to correlate the log lines of an
+// operation
+func NewOperationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetOperationID sets the ID the log lines of the client start with, so the
+// lines of concurrent operations can be told apart. As a client is created
+// for every request, the ID identifies the request.
+func (client *s3Client) SetOperationID(id string) {
+	client.opID = id
+}
+
+// OperationID returns the ID set with SetOperationID
+func (client *s3Client) OperationID() string {
+	return client.opID
+}
+
+// logger logs like glog, with the operation ID of a client in front
+type logger struct {
+	prefix string
+}
+
+func (client *s3Client) log() logger {
+	if client.opID == "" {
+		return logger{}
+	}
+	return logger{prefix: "[" + client.opID + "] "}
+}
+
+func (l logger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, l.prefix+fmt.Sprintf(format, args...))
+}
+
+func (l logger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, l.prefix+fmt.Sprintf(format, args...))
+}
+
+func (l logger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, l.prefix+fmt.Sprintf(format, args...))
+}
+
+// V is like glog.V
+func (l logger) V(level glog.Level) verboseLogger {
+	return verboseLogger{prefix: l.prefix, enabled: bool(glog.V(level))}
+}
+
+type verboseLogger struct {
+	prefix  string
+	enabled bool
+}
+
+func (v verboseLogger) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		glog.InfoDepth(1, v.prefix+fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// maxMetadataSize bounds the JSON of the metadata of a volume. Real
+	// metadata takes a few hundred bytes, anything near the limit comes from
+	// a malformed storage class, e.g. a huge list of mount options.
+	maxMetadataSize = 64 << 10
+	// gzipContentType is the content type of compressed metadata objects
+	gzipContentType = "application/gzip"
+)
+
+// gzipMagic starts every gzip stream and can't start JSON, so compressed and
+// plain metadata objects are told apart by their first bytes
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ValidateMeta checks that the metadata of a volume can be stored, i.e. that
+// its JSON doesn't exceed maxMetadataSize
+func ValidateMeta(meta *FSMeta) error {
+	_, _, err := encodeMeta(meta, false)
+	return err
+}
+
+// encodeMeta returns the content of the metadata object of a volume and its
+// content type, the JSON of meta, gzipped if compress is set
+func encodeMeta(meta *FSMeta, compress bool) ([]byte, string, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxMetadataSize {
+		return nil, "", fmt.Errorf("%w: metadata of %s/%s takes %d bytes, more than %d, with %d mount options",
+			ErrMetadataTooLarge, meta.BucketName, meta.Prefix, len(data), maxMetadataSize, len(meta.MountOptions))
+	}
+	if !compress {
+		return data, metadataContentType, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err != nil {
+		return nil, "", err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), gzipContentType, nil
+}
+
+// decodeMeta reads the metadata of a volume written by encodeMeta,
+// compressed or not. Reading stops at maxMetadataSize, so that a bloated
+// object can't exhaust the memory of the driver.
+func decodeMeta(r io.Reader, meta *FSMeta) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMetadataSize {
+		return fmt.Errorf("metadata is larger than %d bytes", maxMetadataSize)
+	}
+	return json.Unmarshal(data, meta)
+}
@@ -0,0 +1,108 @@
+package s3
+
+import (
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// minPartSize and maxPartsCount are the limits of S3 multipart uploads,
+	// the largest part is maxCopySize
+	minPartSize   = 5 << 20
+	maxPartsCount = 10000
+)
+
+// ValidatePartSize checks that a multipart part size is within the limits
+// of S3. The mounters take it in MiB, so it must be a multiple of that.
+// Zero leaves it to minio and the mounters.
+func ValidatePartSize(size uint64) error {
+	if size == 0 {
+		return nil
+	}
+	if size < minPartSize || size > maxCopySize {
+		return fmt.Errorf("%w: part size %d must be between 5 MiB and 5 GiB", ErrInvalidConfig, size)
+	}
+	if size%(1<<20) != 0 {
+		return fmt.Errorf("%w: part size %d is not a multiple of 1 MiB", ErrInvalidConfig, size)
+	}
+	return nil
+}
+
+// copyPartSize returns the size of the largest object copied in a single
+// request, and of the parts larger ones are copied in: Config.PartSize for
+// backends limiting the size of parts, otherwise the largest S3 allows
+func (client *s3Client) copyPartSize() int64 {
+	if client.Config.PartSize == 0 {
+		return maxCopySize
+	}
+	return int64(client.Config.PartSize)
+}
+
+// copySources splits an object too large for a single copy into ranges of
+// copyPartSize, each of which ComposeObject copies as one part. The part
+// size is raised if the object would need more parts than S3 allows.
+func (client *s3Client) copySources(bucketName string, object minio.ObjectInfo) []minio.CopySrcOptions {
+	size := object.Size
+	partSize := client.copyPartSize()
+	if parts := (size + partSize - 1) / partSize; parts > maxPartsCount {
+		partSize = (size + maxPartsCount - 1) / maxPartsCount
+		// Round up to whole MiB
+		partSize = (partSize + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	var sources []minio.CopySrcOptions
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		sources = append(sources, minio.CopySrcOptions{
+			Bucket:     bucketName,
+			Object:     object.Key,
+			MatchRange: true,
+			Start:      start,
+			End:        end,
+		})
+	}
+	return sources
+}
+
+// removeIncompleteUploads aborts the multipart uploads under prefix which
+// were never completed, e.g. by a crashed mounter. Their parts take up space
+// but aren't listed as objects, and keep the bucket from being removed.
+// Backends without multipart listing are skipped.
+func (client *s3Client) removeIncompleteUploads(bucketName, prefix string) error {
+	filter := client.newRemoveFilter(bucketName, prefix)
+	keys := make(map[string]bool)
+	for upload := range client.bucketClient(bucketName).ListIncompleteUploads(client.ctx, bucketName, prefix, true) {
+		if upload.Err != nil {
+			switch minio.ToErrorResponse(upload.Err).Code {
+			case "NoSuchBucket":
+				return nil
+			case "NotImplemented":
+				client.log().V(4).Infof("Listing multipart uploads is not implemented by %s", client.endpoint())
+				return nil
+			}
+			return upload.Err
+		}
+		ok, err := filter.includes(upload.Key)
+		if err != nil {
+			return err
+		}
+		keys[upload.Key] = ok
+	}
+	// RemoveIncompleteUpload aborts all uploads of a key
+	for key, ok := range keys {
+		if !ok {
+			continue
+		}
+		client.log().V(4).Infof("Aborting incomplete uploads of %s/%s", bucketName, key)
+		err := client.withRetry(bucketName, func(c *minio.Client) error {
+			return c.RemoveIncompleteUpload(client.ctx, bucketName, key)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to abort incomplete uploads of %s/%s: %w", bucketName, key, err)
+		}
+	}
+	return nil
+}
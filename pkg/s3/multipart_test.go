@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestValidatePartSize(t *testing.T) {
+	tests := []struct {
+		size    uint64
+		wantErr bool
+	}{
+		{size: 0},
+		{size: 5 << 20},
+		{size: 5 << 30},
+		{size: 4 << 20, wantErr: true},
+		{size: 6<<30 + 1, wantErr: true},
+		{size: 8<<20 + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidatePartSize(tt.size); (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePartSize(%d) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCopySources(t *testing.T) {
+	client := &s3Client{Config: &Config{PartSize: 1 << 30}}
+	sources := client.copySources("bucket", minio.ObjectInfo{Key: "big", Size: 5<<30 + 1})
+	if len(sources) != 6 {
+		t.Fatalf("got %d sources, want 6", len(sources))
+	}
+	if last := sources[5]; last.Start != 5<<30 || last.End != 5<<30 {
+		t.Errorf("last source = %d-%d, want the last byte", last.Start, last.End)
+	}
+
+	// Too many parts raise the part size
+	client.Config.PartSize = 5 << 20
+	sources = client.copySources("bucket", minio.ObjectInfo{Key: "huge", Size: 100 << 30})
+	if len(sources) > maxPartsCount {
+		t.Errorf("got %d sources, want at most %d", len(sources), maxPartsCount)
+	}
+	for i, src := range sources[:len(sources)-1] {
+		if size := src.End - src.Start + 1; size%(1<<20) != 0 {
+			t.Fatalf("source %d has size %d, want whole MiB", i, size)
+		}
+	}
+}
+
+func TestRemoveIncompleteUploads(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/file", "data")
+	fake.addUpload("bucket", "vol/big", "1")
+	fake.addUpload("bucket", "vol/big", "2")
+	fake.addUpload("bucket", "other/big", "3")
+
+	if _, err := client.RemovePrefix("bucket", "vol"); err != nil {
+		t.Fatalf("RemovePrefix() error = %v", err)
+	}
+	if uploads := fake.uploads["bucket"]; len(uploads) != 1 || uploads["3"] != "other/big" {
+		t.Errorf("uploads after RemovePrefix() = %v, want only other/big", uploads)
+	}
+
+	if _, err := client.RemoveBucket("bucket"); err != nil {
+		t.Fatalf("RemoveBucket() error = %v", err)
+	}
+	if _, ok := fake.buckets["bucket"]; ok {
+		t.Error("bucket still exists after RemoveBucket()")
+	}
+}
+
+func TestCopyInParts(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	// The gateway refuses copies and parts larger than the part size
+	client.Config.PartSize = minPartSize
+	fake.copyLimit = minPartSize
+	big := strings.Repeat("0123456789", minPartSize/5)
+	fake.put("bucket", "src/big", big)
+	fake.put("bucket", "src/small", "data")
+
+	if err := client.CopyPrefix("bucket", "src", "bucket", "dst"); err != nil {
+		t.Fatalf("CopyPrefix() error = %v", err)
+	}
+	fake.Lock()
+	defer fake.Unlock()
+	if got := fake.buckets["bucket"]["dst/big"]; got == nil || !bytes.Equal(got.data, []byte(big)) {
+		t.Errorf("object copied in parts differs from its source")
+	}
+	if got := fake.buckets["bucket"]["dst/small"]; got == nil || string(got.data) != "data" {
+		t.Errorf("small object was not copied")
+	}
+}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// notificationID is the ID of the notification configuration set by the
+// driver
+const notificationID = "csi-s3"
+
+// defaultNotificationEvents are the events notified if none are configured
+var defaultNotificationEvents = []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"}
+
+// NotificationConfig describes the events of a bucket to send to a queue,
+// topic or function, e.g. to trigger a pipeline processing new objects
+type NotificationConfig struct {
+	// Target is the ARN of an SQS queue, SNS topic or Lambda function. With
+	// MinIO it's the ARN of a target configured on the server, such as a
+	// webhook, e.g. "arn:minio:sqs::primary:webhook".
+	Target string
+	// Events are the event types to send, e.g. "s3:ObjectCreated:*",
+	// created and removed objects if empty
+	Events []string
+}
+
+// ValidateNotification checks the target and events of a notification
+// configuration. Whether the target exists and accepts events from the
+// bucket is checked by S3 when the configuration is set.
+func ValidateNotification(cfg NotificationConfig) error {
+	if _, err := notificationTarget(cfg.Target); err != nil {
+		return err
+	}
+	for _, event := range cfg.Events {
+		if !strings.HasPrefix(event, "s3:") || strings.Count(event, ":") < 2 {
+			return fmt.Errorf("%w: notification event %q is not an S3 event type like s3:ObjectCreated:*", ErrInvalidConfig, event)
+		}
+	}
+	return nil
+}
+
+// notificationTarget parses the ARN of a notification target
+func notificationTarget(target string) (notification.Arn, error) {
+	parts := strings.Split(target, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[1] == "" || parts[5] == "" {
+		return notification.Arn{}, fmt.Errorf("%w: notification target %q is not an ARN", ErrInvalidConfig, target)
+	}
+	switch parts[2] {
+	case "sqs", "sns", "lambda":
+	default:
+		return notification.Arn{}, fmt.Errorf("%w: notification target %q is not the ARN of an SQS queue, SNS topic or Lambda function", ErrInvalidConfig, target)
+	}
+	return notification.NewArn(parts[1], parts[2], parts[3], parts[4], parts[5]), nil
+}
+
+// SetBucketNotification makes a bucket send its events to the configured
+// target, replacing any notification configuration it has
+func (client *s3Client) SetBucketNotification(bucketName string, cfg NotificationConfig) error {
+	if err := checkGeneralPurposeBucket(bucketName, "set bucket notification"); err != nil {
+		return err
+	}
+	if err := ValidateNotification(cfg); err != nil {
+		return err
+	}
+	arn, _ := notificationTarget(cfg.Target)
+	target := notification.NewConfig(arn)
+	target.ID = notificationID
+	events := cfg.Events
+	if len(events) == 0 {
+		events = defaultNotificationEvents
+	}
+	for _, event := range events {
+		target.AddEvents(notification.EventType(event))
+	}
+	var config notification.Configuration
+	switch arn.Service {
+	case "sqs":
+		config.AddQueue(target)
+	case "sns":
+		config.AddTopic(target)
+	case "lambda":
+		config.AddLambda(target)
+	}
+	err := client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.SetBucketNotification(client.ctx, bucketName, config)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set notification of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const userMetadataPrefix = "x-amz-meta-"
+
+// userMetadataKeyRegex matches the names allowed for user-defined metadata,
+// which are sent as x-amz-meta-<name> headers
+var userMetadataKeyRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ParseObjectMetadata parses user-defined object metadata given as a comma
+// separated list of key=value pairs. Keys may be given with or without the
+// x-amz-meta- prefix and are returned without it.
+func ParseObjectMetadata(s string) (map[string]string, error) {
+	pairs, err := ParseTags(s)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(pairs))
+	for k, v := range pairs {
+		name := k
+		if strings.HasPrefix(strings.ToLower(name), userMetadataPrefix) {
+			name = name[len(userMetadataPrefix):]
+		}
+		if !userMetadataKeyRegex.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			return nil, fmt.Errorf("%w: metadata key %q", ErrInvalidConfig, k)
+		}
+		for _, c := range v {
+			if c < ' ' || c > '~' {
+				return nil, fmt.Errorf("%w: value of metadata key %q, only printable ASCII is allowed", ErrInvalidConfig, k)
+			}
+		}
+		result[name] = v
+	}
+	return result, nil
+}
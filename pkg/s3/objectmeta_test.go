@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseObjectMetadata(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: map[string]string{}},
+		{in: "team=data, x-amz-meta-owner=alice", want: map[string]string{"team": "data", "owner": "alice"}},
+		{in: "X-Amz-Meta-Build.Id=42", want: map[string]string{"Build.Id": "42"}},
+		{in: "x-amz-acl=private", wantErr: true},
+		{in: "bad key=1", wantErr: true},
+		{in: "x-amz-meta-=1", wantErr: true},
+		{in: "team=café", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseObjectMetadata(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseObjectMetadata(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseObjectMetadata(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestObjectContentType(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.ObjectContentType = "application/x-directory"
+	if err := client.CreatePrefix("bucket", "vol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"vol/":               "application/x-directory",
+		"vol/.metadata.json": "application/json",
+	}
+	for key, want := range tests {
+		if got := fake.buckets["bucket"][key].header.Get("Content-Type"); got != want {
+			t.Errorf("Content-Type of %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestObjectTags(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	client.Config.ObjectTags = map[string]string{"pvc-name": "data"}
+	if err := client.CreatePrefix("bucket", "vol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: "vol"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"vol/", "vol/.metadata.json"} {
+		if got := fake.buckets["bucket"][key].header.Get("X-Amz-Tagging"); got != "pvc-name=data" {
+			t.Errorf("X-Amz-Tagging of %s = %q, want pvc-name=data", key, got)
+		}
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tooMany[fmt.Sprint("key", i)] = "value"
+	}
+	for _, tagMap := range []map[string]string{tooMany, {strings.Repeat("k", 129): "v"}, {"k": strings.Repeat("v", 257)}} {
+		if err := ValidateObjectTags(tagMap); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ValidateObjectTags() error = %v, want ErrInvalidConfig", err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package s3
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	policyVersion = "2012-10-17"
+	// policySidPrefix marks the statements managed by the driver. Sids may
+	// only contain alphanumeric characters, so the prefix is hashed.
+	policySidPrefix = "CsiS3"
+	// policyLockTTL bounds how long a crashed controller keeps the policy of
+	// a bucket locked
+	policyLockTTL = time.Minute
+)
+
+// policyLockTimeout bounds the wait for the policy lock of a bucket held by
+// another volume
+var policyLockTimeout = 30 * time.Second
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []json.RawMessage `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid       string                 `json:"Sid,omitempty"`
+	Effect    string                 `json:"Effect"`
+	Principal map[string]interface{} `json:"Principal,omitempty"`
+	Action    []string               `json:"Action"`
+	Resource  []string               `json:"Resource"`
+	Condition map[string]interface{} `json:"Condition,omitempty"`
+}
+
+// SetPrefixPolicy grants principalARN read/write access to the objects under
+// prefix, and nothing else, by adding statements to the bucket policy.
+// Statements of other prefixes and any statements not managed by the driver
+// are kept, so many prefix volumes can share a bucket.
+func (client *s3Client) SetPrefixPolicy(bucketName, prefix, principalARN string) error {
+	if err := checkGeneralPurposeBucket(bucketName, "set bucket policy"); err != nil {
+		return err
+	}
+	if prefix == "" || principalARN == "" {
+		return fmt.Errorf("prefix and principal are required for a prefix policy")
+	}
+	unlock, err := client.lockPolicy(bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	doc, err := client.getPolicy(bucketName)
+	if err != nil {
+		return err
+	}
+	doc.Statement = removePrefixStatements(doc.Statement, prefix)
+	for _, stmt := range prefixStatements(bucketName, prefix, principalARN) {
+		raw, err := json.Marshal(stmt)
+		if err != nil {
+			return err
+		}
+		doc.Statement = append(doc.Statement, raw)
+	}
+	return client.putPolicy(bucketName, doc)
+}
+
+// GetPrefixPolicy returns the principal granted access to prefix by
+// SetPrefixPolicy, or an empty string if there is none
+func (client *s3Client) GetPrefixPolicy(bucketName, prefix string) (string, error) {
+	doc, err := client.getPolicy(bucketName)
+	if err != nil {
+		return "", err
+	}
+	for _, raw := range doc.Statement {
+		var stmt policyStatement
+		if json.Unmarshal(raw, &stmt) != nil || stmt.Sid != prefixSid("Objects", prefix) {
+			continue
+		}
+		if arn, ok := stmt.Principal["AWS"].(string); ok {
+			return arn, nil
+		}
+	}
+	return "", nil
+}
+
+// RemovePrefixPolicy removes the statements added by SetPrefixPolicy. The
+// bucket is only locked if the policy has statements of the prefix, as it is
+// called for every deleted volume.
+func (client *s3Client) RemovePrefixPolicy(bucketName, prefix string) error {
+	if IsDirectoryBucket(bucketName) {
+		return nil
+	}
+	doc, err := client.getPolicy(bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(errors.Unwrap(err)).Code == "NotImplemented" {
+			// Backend without bucket policies, nothing to remove
+			return nil
+		}
+		return err
+	}
+	if len(removePrefixStatements(doc.Statement, prefix)) == len(doc.Statement) {
+		return nil
+	}
+	unlock, err := client.lockPolicy(bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Read it again, it may have changed before it was locked
+	if doc, err = client.getPolicy(bucketName); err != nil {
+		return err
+	}
+	statements := removePrefixStatements(doc.Statement, prefix)
+	if len(statements) == len(doc.Statement) {
+		return nil
+	}
+	doc.Statement = statements
+	return client.putPolicy(bucketName, doc)
+}
+
+// lockPolicy takes the lock of the whole bucket, as changing the statements
+// of a prefix rewrites the whole policy: volumes of a bucket provisioned at
+// the same time would otherwise drop each other's statements. It waits for
+// the lock up to policyLockTimeout and returns the function releasing it.
+func (client *s3Client) lockPolicy(bucketName string) (func(), error) {
+	deadline := time.Now().Add(policyLockTimeout)
+	delay := 50 * time.Millisecond
+	for {
+		err := client.AcquireLock(bucketName, "", policyLockTTL)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock policy of bucket %s: %w", bucketName, err)
+		}
+		// Jittered, so that waiting volumes don't retry in lockstep
+		select {
+		case <-client.ctx.Done():
+			return nil, client.ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(delay))) + delay/2):
+		}
+		if delay *= 2; delay > 2*time.Second {
+			delay = 2 * time.Second
+		}
+	}
+	return func() {
+		if err := client.ReleaseLock(bucketName, ""); err != nil {
+			client.log().Warningf("Failed to release policy lock of bucket %s: %v", bucketName, err)
+		}
+	}, nil
+}
+
+func (client *s3Client) getPolicy(bucketName string) (*policyDocument, error) {
+	var policy string
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		policy, err = c.GetBucketPolicy(client.ctx, bucketName)
+		return err
+	})
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucketPolicy" {
+		return nil, fmt.Errorf("failed to get policy of bucket %s: %w", bucketName, err)
+	}
+	doc := &policyDocument{Version: policyVersion}
+	if policy != "" {
+		if err = json.Unmarshal([]byte(policy), doc); err != nil {
+			return nil, fmt.Errorf("failed to parse policy of bucket %s: %v", bucketName, err)
+		}
+	}
+	return doc, nil
+}
+
+func (client *s3Client) putPolicy(bucketName string, doc *policyDocument) error {
+	if len(doc.Statement) == 0 {
+		// An empty policy string deletes the policy
+		return client.setPolicy(bucketName, "")
+	}
+	policy, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return client.setPolicy(bucketName, string(policy))
+}
+
+func (client *s3Client) setPolicy(bucketName, policy string) error {
+	return client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.SetBucketPolicy(client.ctx, bucketName, policy)
+	})
+}
+
+func prefixStatements(bucketName, prefix, principalARN string) []policyStatement {
+	principal := map[string]interface{}{"AWS": principalARN}
+	return []policyStatement{
+		{
+			Sid:       prefixSid("Objects", prefix),
+			Effect:    "Allow",
+			Principal: principal,
+			Action:    []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload"},
+			Resource:  []string{fmt.Sprintf("arn:aws:s3:::%s/%s/*", bucketName, prefix)},
+		},
+		{
+			Sid:       prefixSid("List", prefix),
+			Effect:    "Allow",
+			Principal: principal,
+			Action:    []string{"s3:ListBucket"},
+			Resource:  []string{fmt.Sprintf("arn:aws:s3:::%s", bucketName)},
+			Condition: map[string]interface{}{
+				"StringLike": map[string]interface{}{"s3:prefix": []string{prefix, prefix + "/*"}},
+			},
+		},
+	}
+}
+
+func removePrefixStatements(statements []json.RawMessage, prefix string) []json.RawMessage {
+	sids := map[string]bool{prefixSid("Objects", prefix): true, prefixSid("List", prefix): true}
+	kept := make([]json.RawMessage, 0, len(statements))
+	for _, raw := range statements {
+		var stmt policyStatement
+		if json.Unmarshal(raw, &stmt) == nil && sids[stmt.Sid] {
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	return kept
+}
+
+func prefixSid(kind, prefix string) string {
+	h := sha1.Sum([]byte(prefix))
+	return policySidPrefix + kind + strings.ToUpper(hex.EncodeToString(h[:8]))
+}
@@ -0,0 +1,100 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefixPolicy(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	// Statements not managed by the driver are kept
+	fake.policies = map[string]string{"bucket": `{"Version":"2012-10-17","Statement":[{"Sid":"Other","Effect":"Deny"}]}`}
+
+	if err := client.SetPrefixPolicy("bucket", "vol1", "arn:aws:iam::1:role/one"); err != nil {
+		t.Fatalf("SetPrefixPolicy() error = %v", err)
+	}
+	if err := client.SetPrefixPolicy("bucket", "vol2", "arn:aws:iam::1:role/two"); err != nil {
+		t.Fatalf("SetPrefixPolicy() of second prefix error = %v", err)
+	}
+	// Setting it again replaces the statements of the prefix
+	if err := client.SetPrefixPolicy("bucket", "vol1", "arn:aws:iam::1:role/three"); err != nil {
+		t.Fatalf("SetPrefixPolicy() again error = %v", err)
+	}
+	for prefix, want := range map[string]string{"vol1": "arn:aws:iam::1:role/three", "vol2": "arn:aws:iam::1:role/two", "vol3": ""} {
+		if got, err := client.GetPrefixPolicy("bucket", prefix); err != nil || got != want {
+			t.Errorf("GetPrefixPolicy(%s) = %q, %v, want %q", prefix, got, err, want)
+		}
+	}
+	doc, err := client.getPolicy("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Statement) != 5 {
+		t.Errorf("policy has %d statements, want 5: %s", len(doc.Statement), fake.policies["bucket"])
+	}
+	if got := fake.keys("bucket"); len(got) != 0 {
+		t.Errorf("keys after SetPrefixPolicy() = %v, want the lock released", got)
+	}
+
+	if err = client.RemovePrefixPolicy("bucket", "vol1"); err != nil {
+		t.Fatalf("RemovePrefixPolicy() error = %v", err)
+	}
+	if got, _ := client.GetPrefixPolicy("bucket", "vol2"); got != "arn:aws:iam::1:role/two" {
+		t.Errorf("GetPrefixPolicy(vol2) after removing vol1 = %q", got)
+	}
+	if err = client.RemovePrefixPolicy("bucket", "vol2"); err != nil {
+		t.Fatalf("RemovePrefixPolicy() error = %v", err)
+	}
+	if policy := fake.policies["bucket"]; !strings.Contains(policy, `"Other"`) || strings.Contains(policy, policySidPrefix) {
+		t.Errorf("policy after removing all prefixes = %s, want only the other statement", policy)
+	}
+
+	// The policy is deleted along with its last statement
+	if err = client.SetPrefixPolicy("bucket", "vol1", "arn:aws:iam::1:role/one"); err != nil {
+		t.Fatal(err)
+	}
+	delete(fake.policies, "bucket")
+	if err = client.RemovePrefixPolicy("bucket", "vol1"); err != nil {
+		t.Errorf("RemovePrefixPolicy() without policy error = %v", err)
+	}
+}
+
+func TestPrefixPolicyConcurrent(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+
+	// Volumes provisioned at the same time keep each other's statements
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			other := NewClientWithMinio(client.Config, client.minio)
+			errs[i] = other.SetPrefixPolicy("bucket", fmt.Sprintf("vol%d", i), fmt.Sprintf("arn:aws:iam::1:role/%d", i))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("SetPrefixPolicy(vol%d) error = %v", i, err)
+		}
+		if got, _ := client.GetPrefixPolicy("bucket", fmt.Sprintf("vol%d", i)); got == "" {
+			t.Errorf("statements of vol%d are missing: %s", i, fake.policies["bucket"])
+		}
+	}
+
+	// A lock held for longer fails after policyLockTimeout
+	defer func(old time.Duration) { policyLockTimeout = old }(policyLockTimeout)
+	policyLockTimeout = 100 * time.Millisecond
+	other := NewClientWithMinio(client.Config, client.minio)
+	if err := other.AcquireLock("bucket", "", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetPrefixPolicy("bucket", "vol0", "arn:aws:iam::1:role/0"); !errors.Is(err, ErrLocked) {
+		t.Errorf("SetPrefixPolicy() of locked bucket error = %v, want ErrLocked", err)
+	}
+}
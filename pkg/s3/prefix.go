@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// PrefixEncodingRaw uses volume names as prefixes as they are
+	PrefixEncodingRaw = "raw"
+	// PrefixEncodingURL percent-encodes all characters of volume names but
+	// letters, digits and "-._~", so that prefixes are safe for any backend,
+	// mounter and tool
+	PrefixEncodingURL = "url"
+
+	// maxKeyLength is the longest object key S3 accepts, in bytes
+	maxKeyLength = 1024
+)
+
+// ErrInvalidPrefix is returned for prefixes whose objects can't be created,
+// listed and removed consistently
+var ErrInvalidPrefix = errors.New("invalid prefix")
+
+// EncodePrefix returns the prefix to use for a volume name with the given
+// encoding, which is empty or one of the PrefixEncoding constants. Slashes
+// are kept, so that names with slashes are still nested prefixes.
+func EncodePrefix(name, encoding string) (string, error) {
+	switch encoding {
+	case "", PrefixEncodingRaw:
+		return name, nil
+	case PrefixEncodingURL:
+		segments := strings.Split(name, "/")
+		for i, segment := range segments {
+			segments[i] = escapeSegment(segment)
+		}
+		return strings.Join(segments, "/"), nil
+	default:
+		return "", fmt.Errorf("%w: unknown prefix encoding %q, use %s or %s",
+			ErrInvalidConfig, encoding, PrefixEncodingRaw, PrefixEncodingURL)
+	}
+}
+
+func escapeSegment(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// isUnreserved reports whether c is an unreserved character of URLs, RFC 3986
+// section 2.3
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// ValidatePrefix checks that the objects of a prefix have the same keys
+// however they are built. The metadata key is built with path.Join, which
+// cleans "." and ".." segments and doubled slashes while the placeholder and
+// the objects of the mounters keep them, so such prefixes are rejected along
+// with invalid UTF-8, which S3 rejects in keys, and control characters, which
+// some backends and mounters drop or mangle.
+func ValidatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if len(prefix) >= maxKeyLength {
+		return fmt.Errorf("%w: %q is longer than %d bytes", ErrInvalidPrefix, prefix, maxKeyLength-1)
+	}
+	if !utf8.ValidString(prefix) {
+		return fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidPrefix, prefix)
+	}
+	for _, r := range prefix {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: %q contains control characters", ErrInvalidPrefix, prefix)
+		}
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		switch segment {
+		case "":
+			return fmt.Errorf("%w: %q has an empty path segment", ErrInvalidPrefix, prefix)
+		case ".", "..":
+			return fmt.Errorf("%w: %q has a %q path segment", ErrInvalidPrefix, prefix, segment)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		want     string
+	}{
+		{"pvc-1", PrefixEncodingURL, "pvc-1"},
+		{"my volume", "", "my volume"},
+		{"my volume", PrefixEncodingRaw, "my volume"},
+		{"my volume", PrefixEncodingURL, "my%20volume"},
+		{"team/a+b", PrefixEncodingURL, "team/a%2Bb"},
+		{"100%", PrefixEncodingURL, "100%25"},
+		{"日本", PrefixEncodingURL, "%E6%97%A5%E6%9C%AC"},
+		{"a_b.c~d", PrefixEncodingURL, "a_b.c~d"},
+	} {
+		got, err := EncodePrefix(tc.name, tc.encoding)
+		if err != nil || got != tc.want {
+			t.Errorf("EncodePrefix(%q, %q) = %q, %v, want %q", tc.name, tc.encoding, got, err, tc.want)
+		}
+	}
+	if _, err := EncodePrefix("pvc-1", "base64"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("EncodePrefix() with unknown encoding error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestValidatePrefix(t *testing.T) {
+	for _, prefix := range []string{"", "pvc-1", "my volume", "日本/データ", "a+b&c=d;e?f#g", "100%", "dir.with.dots/..x"} {
+		if err := ValidatePrefix(prefix); err != nil {
+			t.Errorf("ValidatePrefix(%q) error = %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"/pvc-1", "pvc-1/", "a//b", "./pvc-1", "a/../b", "tab\there",
+		"new\nline", "\xff\xfe", strings.Repeat("a", maxKeyLength)} {
+		if err := ValidatePrefix(prefix); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("ValidatePrefix(%q) error = %v, want ErrInvalidPrefix", prefix, err)
+		}
+	}
+}
+
+func TestPrefixRoundTrip(t *testing.T) {
+	for _, name := range []string{"my volume", "trailing ", "日本", "a+b", "100%", "q?x#y", "a&b=c;d", "tilde~", "team/my volume"} {
+		for _, encoding := range []string{PrefixEncodingRaw, PrefixEncodingURL} {
+			client, fake := newTestClient(t, "bucket")
+			prefix, err := EncodePrefix(name, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.CreatePrefix("bucket", prefix); err != nil {
+				t.Errorf("CreatePrefix(%q) error = %v", prefix, err)
+				continue
+			}
+			if err := client.WriteMeta(&FSMeta{BucketName: "bucket", Prefix: prefix, Mounter: "geesefs"}); err != nil {
+				t.Errorf("WriteMeta(%q) error = %v", prefix, err)
+				continue
+			}
+			fake.put("bucket", prefix+"/file", "data")
+
+			exists, meta, err := client.VolumeExists("bucket", prefix)
+			if err != nil || !exists || meta == nil || meta.Prefix != prefix {
+				t.Errorf("VolumeExists(%q) = %v, %+v, %v, want the volume", prefix, exists, meta, err)
+			}
+			if _, err := client.RemovePrefix("bucket", prefix); err != nil {
+				t.Errorf("RemovePrefix(%q) error = %v", prefix, err)
+			}
+			if got := fake.keys("bucket"); len(got) != 0 {
+				t.Errorf("keys left after removing %q = %q, want none", prefix, got)
+			}
+		}
+	}
+}
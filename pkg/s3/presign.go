@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// minPresignExpiry and maxPresignExpiry are the bounds of the lifetime of
+	// presigned URLs, the latter being the longest S3 accepts
+	minPresignExpiry = time.Second
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// PresignGet returns a URL to download an object without credentials, valid
+// for expiry
+func (client *s3Client) PresignGet(bucketName, key string, expiry time.Duration) (*url.URL, error) {
+	if err := validatePresignExpiry(expiry); err != nil {
+		return nil, err
+	}
+	return client.bucketClient(bucketName).PresignedGetObject(client.ctx, bucketName, key, expiry, nil)
+}
+
+// PresignPut returns a URL to upload an object without credentials, valid
+// for expiry
+func (client *s3Client) PresignPut(bucketName, key string, expiry time.Duration) (*url.URL, error) {
+	if client.Config.Anonymous {
+		return nil, fmt.Errorf("cannot presign upload of %s/%s: %w", bucketName, key, ErrAnonymousAccess)
+	}
+	if err := validatePresignExpiry(expiry); err != nil {
+		return nil, err
+	}
+	return client.bucketClient(bucketName).PresignedPutObject(client.ctx, bucketName, key, expiry)
+}
+
+func validatePresignExpiry(expiry time.Duration) error {
+	if expiry < minPresignExpiry || expiry > maxPresignExpiry {
+		return fmt.Errorf("%w: expiry %v must be between %v and %v", ErrInvalidExpiry, expiry, minPresignExpiry, maxPresignExpiry)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package s3
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresign(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/file", "data")
+
+	u, err := client.PresignGet("bucket", "vol/file", time.Hour)
+	if err != nil {
+		t.Fatalf("PresignGet() error = %v", err)
+	}
+	if got := u.Query().Get("X-Amz-Expires"); got != "3600" {
+		t.Errorf("X-Amz-Expires = %q, want 3600", got)
+	}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "data" {
+		t.Errorf("GET presigned URL = %q, want data", data)
+	}
+
+	u, err = client.PresignPut("bucket", "vol/upload", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignPut() error = %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, u.String(), strings.NewReader("uploaded"))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := fake.buckets["bucket"]["vol/upload"]; got == nil || string(got.data) != "uploaded" {
+		t.Errorf("object after PUT to presigned URL = %v, want uploaded", got)
+	}
+
+	for _, expiry := range []time.Duration{0, 8 * 24 * time.Hour} {
+		if _, err = client.PresignGet("bucket", "vol/file", expiry); !errors.Is(err, ErrInvalidExpiry) {
+			t.Errorf("PresignGet() with expiry %v error = %v, want ErrInvalidExpiry", expiry, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package s3
+
+import (
+	"fmt"
+	"time"
+)
+
+// provisionBucketWait bounds the wait for a bucket created by ProvisionVolume
+// to become visible
+const provisionBucketWait = 30 * time.Second
+
+// ProvisionHooks are run by ProvisionVolume between its steps, for the
+// callers to configure and check the volume. A hook returning an error rolls
+// back the provisioning like a failed step, and its error is returned as is.
+type ProvisionHooks struct {
+	// SetupBucket configures a bucket that was missing, once it exists
+	SetupBucket func() error
+	// BeforePrefix runs once the bucket exists, before the prefix is
+	// created. created reports whether this call created the bucket.
+	BeforePrefix func(created bool) error
+	// BeforeMeta runs with the prefix in place, before the metadata is
+	// written
+	BeforeMeta func() error
+}
+
+// ProvisionVolume creates the bucket (if missing and allowed) and prefix of a
+// volume and writes its metadata. If a step fails, whatever this call
+// created is removed again, so the volume is either fully provisioned or
+// left as it was. A bucket is only removed if this call created it for a
+// volume of its own: buckets shared by prefixes may be in use by volumes
+// provisioned at the same time.
+func (client *s3Client) ProvisionVolume(meta *FSMeta, createBucketIfMissing bool, hooks ProvisionHooks) (err error) {
+	bucketName, prefix := meta.BucketName, meta.Prefix
+	bucketExists, err := client.BucketExists(bucketName)
+	if err != nil {
+		return err
+	}
+	if !bucketExists && !createBucketIfMissing {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+
+	bucketCreated, prefixMissing := false, false
+	defer func() {
+		if err == nil {
+			return
+		}
+		var rollbackErr error
+		switch {
+		case prefix == "" && bucketCreated:
+			_, rollbackErr = client.RemoveBucket(bucketName)
+		case prefix != "" && prefixMissing:
+			// Never roll back data written in the meantime
+			empty, checkErr := client.IsPrefixEmpty(bucketName, prefix)
+			if checkErr != nil || !empty {
+				client.log().Warningf("Not rolling back prefix %s/%s, it may hold data: %v", bucketName, prefix, checkErr)
+				return
+			}
+			_, rollbackErr = client.RemovePrefix(bucketName, prefix)
+		}
+		if rollbackErr != nil {
+			client.log().Errorf("Failed to roll back provisioning of %s/%s: %v", bucketName, prefix, rollbackErr)
+		}
+	}()
+
+	if !bucketExists {
+		// The bucket may have been created since, by a concurrent request or
+		// while BucketExists was answered from the cache
+		if bucketCreated, err = client.CreateBucket(bucketName); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+		}
+		if err = client.WaitForBucket(bucketName, provisionBucketWait); err != nil {
+			return err
+		}
+		if hooks.SetupBucket != nil {
+			if err = hooks.SetupBucket(); err != nil {
+				return err
+			}
+		}
+	}
+	if hooks.BeforePrefix != nil {
+		if err = hooks.BeforePrefix(bucketCreated); err != nil {
+			return err
+		}
+	}
+	if prefix != "" {
+		// Checked after the hook, which may lock the volume. The lock and
+		// a placeholder left by a failed attempt aren't data of the volume.
+		var existing *FSMeta
+		if _, existing, err = client.VolumeExists(bucketName, prefix); err != nil {
+			return err
+		}
+		if existing == nil {
+			if prefixMissing, err = client.IsPrefixEmpty(bucketName, prefix); err != nil {
+				return err
+			}
+		}
+	}
+	if err = client.CreatePrefix(bucketName, prefix); err != nil {
+		return fmt.Errorf("failed to create prefix %s: %w", prefix, err)
+	}
+	if hooks.BeforeMeta != nil {
+		if err = hooks.BeforeMeta(); err != nil {
+			return err
+		}
+	}
+	if err = client.WriteMeta(meta); err != nil {
+		return fmt.Errorf("failed to write metadata of volume %s: %w", FormatVolumeID(bucketName, prefix), err)
+	}
+	return nil
+}
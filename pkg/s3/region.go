@@ -0,0 +1,176 @@
+package s3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// bucketRegions caches the region of every bucket seen with region discovery
+// enabled, keyed by endpoint and bucket name. It is shared by all clients as
+// a new client is created for every request.
+var bucketRegions = struct {
+	sync.Mutex
+	regions map[string]string
+}{regions: make(map[string]string)}
+
+// awsEndpointRegex matches the global, regional and dual-stack AWS S3
+// endpoints
+var awsEndpointRegex = regexp.MustCompile(`^s3(?:\.dualstack)?([.-][a-z0-9-]+)?\.amazonaws\.com(:\d+)?$`)
+
+// regionRegex matches region names, e.g. us-east-1, ru-central1 or fr-par.
+// Providers name their regions differently, so it only rules out what can't
+// be a region, like URLs, spaces or a trailing newline from the secret.
+var regionRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidateRegion checks the name of a region of the secret, key is the name
+// of the secret key
+func ValidateRegion(key, region string) error {
+	if region == "" || regionRegex.MatchString(region) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s: %q is not a region name like us-east-1, only letters, digits, dots, hyphens and underscores are allowed",
+		ErrInvalidConfig, key, region)
+}
+
+// bucketClient returns the minio client to use for requests to a bucket. With
+// region discovery enabled, requests are signed for (and, on AWS, sent to)
+// the region the bucket actually lives in instead of the configured one.
+func (client *s3Client) bucketClient(bucketName string) *minio.Client {
+	if !client.Config.RegionDiscovery || bucketName == "" {
+		return client.defaultClient()
+	}
+	region, ok := client.cachedRegion(bucketName)
+	if !ok {
+		region = client.discoverRegion(bucketName)
+	}
+	if region == "" {
+		return client.defaultClient()
+	}
+	regional, err := client.regionClient(region)
+	if err != nil {
+		client.log().Warningf("Failed to create client for region %s of bucket %s, using the default one: %v", region, bucketName, err)
+		return client.defaultClient()
+	}
+	return regional
+}
+
+// discoverRegion finds out the region of a bucket. A HEAD request to a bucket
+// in another region is answered with a redirect naming the right region.
+func (client *s3Client) discoverRegion(bucketName string) string {
+	_, err := client.defaultClient().BucketExists(client.ctx, bucketName)
+	if err == nil {
+		client.setCachedRegion(bucketName, client.Config.Region)
+		return client.Config.Region
+	}
+	if region := minio.ToErrorResponse(err).Region; region != "" {
+		client.log().V(4).Infof("Bucket %s is located in region %s", bucketName, region)
+		client.setCachedRegion(bucketName, region)
+		return region
+	}
+	return ""
+}
+
+// learnRegion updates the cached region of a bucket from a redirect error.
+// It returns true if the region changed, i.e. the request is worth retrying.
+func (client *s3Client) learnRegion(bucketName string, err error) bool {
+	if !client.Config.RegionDiscovery || err == nil || bucketName == "" {
+		return false
+	}
+	errResp := minio.ToErrorResponse(err)
+	switch errResp.Code {
+	case "PermanentRedirect", "AuthorizationHeaderMalformed", "301 Moved Permanently":
+	default:
+		return false
+	}
+	cached, _ := client.cachedRegion(bucketName)
+	if errResp.Region == "" {
+		// Rediscover on next use
+		bucketRegions.Lock()
+		delete(bucketRegions.regions, client.regionKey(bucketName))
+		bucketRegions.Unlock()
+		return false
+	}
+	if errResp.Region == cached {
+		return false
+	}
+	client.log().Infof("Bucket %s moved to region %s", bucketName, errResp.Region)
+	client.setCachedRegion(bucketName, errResp.Region)
+	return true
+}
+
+// withRetry calls op with the client for a bucket. If the request was
+// redirected because the bucket lives in another region than cached, op is
+// called once more with the client for that region. If the endpoint is down,
+// op is called again on each fallback endpoint until one succeeds, so op must
+// be a single request, or safe to repeat as a whole.
+func (client *s3Client) withRetry(bucketName string, op func(*minio.Client) error) error {
+	endpoint := client.endpoint()
+	err := op(client.bucketClient(bucketName))
+	if client.learnRegion(bucketName, err) {
+		client.log().V(4).Infof("Retrying request to bucket %s in its new region", bucketName)
+		err = op(client.bucketClient(bucketName))
+	}
+	for i := 0; i < len(client.Config.FallbackEndpoints) && client.failover(endpoint, err); i++ {
+		endpoint = client.endpoint()
+		err = op(client.bucketClient(bucketName))
+	}
+	return err
+}
+
+func (client *s3Client) regionKey(bucketName string) string {
+	return client.endpoint() + "/" + bucketName
+}
+
+func (client *s3Client) cachedRegion(bucketName string) (string, bool) {
+	bucketRegions.Lock()
+	defer bucketRegions.Unlock()
+	region, ok := bucketRegions.regions[client.regionKey(bucketName)]
+	return region, ok
+}
+
+func (client *s3Client) setCachedRegion(bucketName, region string) {
+	bucketRegions.Lock()
+	defer bucketRegions.Unlock()
+	bucketRegions.regions[client.regionKey(bucketName)] = region
+}
+
+// regionClient returns a minio client signing requests for region
+func (client *s3Client) regionClient(region string) (*minio.Client, error) {
+	client.regionMutex.Lock()
+	defer client.regionMutex.Unlock()
+	if c, ok := client.regionClients[region]; ok {
+		return c, nil
+	}
+	endpoint, ssl, err := parseEndpoint(client.Config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint = regionalEndpoint(endpoint, region)
+	c, err := client.newMinio(endpoint, ssl, region)
+	if err != nil {
+		return nil, err
+	}
+	if client.regionClients == nil {
+		client.regionClients = make(map[string]*minio.Client)
+	}
+	client.regionClients[region] = c
+	return c, nil
+}
+
+// regionalEndpoint returns the endpoint serving region. AWS has a separate
+// endpoint per region, other backends are expected to serve all regions on
+// the configured endpoint. Dual-stack endpoints stay dual-stack.
+func regionalEndpoint(endpoint, region string) string {
+	m := awsEndpointRegex.FindStringSubmatch(endpoint)
+	if m == nil {
+		return endpoint
+	}
+	if strings.HasPrefix(endpoint, "s3.dualstack.") {
+		return dualStackEndpoint(endpoint, region)
+	}
+	return "s3." + region + ".amazonaws.com" + m[2]
+}
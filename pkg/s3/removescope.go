@@ -0,0 +1,178 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// RemoveAll removes everything under the prefix of a volume
+	RemoveAll = "all"
+	// RemoveVolume keeps the nested prefixes holding their own metadata,
+	// i.e. the volumes nested in the prefix
+	RemoveVolume = "volume"
+	// RemoveDirect only removes the objects directly under the prefix,
+	// keeping all nested prefixes
+	RemoveDirect = "direct"
+)
+
+// ValidateRemoveScope checks the remove scope of a secret
+func ValidateRemoveScope(scope string) error {
+	switch scope {
+	case "", RemoveAll, RemoveVolume, RemoveDirect:
+		return nil
+	}
+	return fmt.Errorf("%w: removeScope: %q is not %s, %s or %s", ErrInvalidConfig, scope, RemoveAll, RemoveVolume, RemoveDirect)
+}
+
+// removeFilter selects the keys under a prefix to remove, following the
+// RemoveScope of the client
+type removeFilter struct {
+	client     *s3Client
+	bucketName string
+	prefix     string
+	scope      string
+	// lockKey is the lock of the volume, which is kept until the prefix
+	// itself is removed so that the volume stays locked while it's emptied
+	lockKey string
+	// nested caches whether the sub-prefixes seen are volumes
+	nested map[string]bool
+}
+
+// newRemoveFilter returns the filter of the keys under prefix, which ends
+// with a slash. Buckets, i.e. an empty prefix, are always removed entirely.
+func (client *s3Client) newRemoveFilter(bucketName, prefix string) *removeFilter {
+	f := &removeFilter{
+		client:     client,
+		bucketName: bucketName,
+		prefix:     prefix,
+		scope:      client.Config.RemoveScope,
+		nested:     make(map[string]bool),
+	}
+	if f.scope == "" || prefix == "" {
+		f.scope = RemoveAll
+	}
+	if prefix != "" {
+		f.lockKey = prefix + lockName
+	}
+	return f
+}
+
+// removesAll reports whether all listed keys are removed, so that they
+// don't need to be filtered
+func (f *removeFilter) removesAll() bool {
+	return f.scope == RemoveAll && f.lockKey == ""
+}
+
+// includes reports whether the object at key is removed
+func (f *removeFilter) includes(key string) (bool, error) {
+	if key == f.lockKey {
+		return false, nil
+	}
+	rest := strings.TrimPrefix(key, f.prefix)
+	switch f.scope {
+	case RemoveDirect:
+		return !strings.Contains(rest, "/"), nil
+	case RemoveVolume:
+		// The first volume found from the top keeps the key
+		dir := f.prefix
+		for {
+			i := strings.Index(rest, "/")
+			if i < 0 {
+				return true, nil
+			}
+			dir, rest = dir+rest[:i+1], rest[i+1:]
+			nested, err := f.isVolume(dir)
+			if err != nil || nested {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// isVolume reports whether the sub-prefix dir has its own metadata
+func (f *removeFilter) isVolume(dir string) (bool, error) {
+	if nested, ok := f.nested[dir]; ok {
+		return nested, nil
+	}
+	_, err := f.client.StatObject(f.bucketName, f.client.metaKey(strings.TrimSuffix(dir, "/")))
+	if err != nil && !errors.Is(err, ErrNotFound) && !isNotFound(err) {
+		return false, fmt.Errorf("failed to check for a volume nested at %s/%s: %w", f.bucketName, dir, err)
+	}
+	nested := err == nil
+	if nested {
+		f.client.log().Infof("Keeping volume %s/%s nested in %s", f.bucketName, dir, f.prefix)
+	}
+	f.nested[dir] = nested
+	return nested, nil
+}
+
+// filter returns the objects of a page to remove
+func (f *removeFilter) filter(objects []minio.ObjectInfo) ([]minio.ObjectInfo, error) {
+	if f.removesAll() {
+		return objects, nil
+	}
+	kept := make([]minio.ObjectInfo, 0, len(objects))
+	for _, object := range objects {
+		ok, err := f.includes(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, object)
+		}
+	}
+	return kept, nil
+}
+
+// list streams the objects to remove, listed with opts, like listObjects
+func (f *removeFilter) list(ctx context.Context, opts minio.ListObjectsOptions) (<-chan minio.ObjectInfo, func() error) {
+	if f.removesAll() {
+		return f.client.listObjects(ctx, f.bucketName, opts)
+	}
+	// Without recursion, nested prefixes are listed as keys ending with a
+	// slash, which includes drops
+	opts.Recursive = f.scope != RemoveDirect
+	ctx, cancel := context.WithCancel(ctx)
+	listedCh, listResult := f.client.listObjects(ctx, f.bucketName, opts)
+	objectsCh := make(chan minio.ObjectInfo)
+	doneCh := make(chan struct{})
+	var filterErr error
+
+	go func() {
+		defer close(doneCh)
+		defer close(objectsCh)
+		defer cancel()
+
+		for object := range listedCh {
+			ok, err := f.includes(object.Key)
+			if err != nil {
+				filterErr = err
+				cancel()
+				for range listedCh {
+				}
+				return
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case objectsCh <- object:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return objectsCh, func() error {
+		<-doneCh
+		if filterErr != nil {
+			return filterErr
+		}
+		return listResult()
+	}
+}
@@ -0,0 +1,222 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// maxCopySize is the largest object S3 can copy in a single request,
+	// and the largest part of a multipart upload
+	maxCopySize = 5 << 30
+	// copyParallelism is the number of objects copied at the same time
+	copyParallelism = 16
+)
+
+// RenamePrefix moves the objects of a volume from oldPrefix to newPrefix
+// within a bucket, using server-side copies, and updates its metadata. The
+// old objects are only removed once all objects have been copied. The new
+// prefix must not hold data, except for the copies of an interrupted rename,
+// which is completed by calling RenamePrefix again, or RemovePrefix on the
+// old prefix if it failed while removing the old objects.
+func (client *s3Client) RenamePrefix(bucketName, oldPrefix, newPrefix string) error {
+	if client.Config.Anonymous {
+		return fmt.Errorf("cannot rename prefix %s in bucket %s: %w", oldPrefix, bucketName, ErrAnonymousAccess)
+	}
+	if oldPrefix == "" || newPrefix == "" {
+		return fmt.Errorf("cannot rename bucket %s to or from the bucket root", bucketName)
+	}
+	if oldPrefix == newPrefix {
+		return nil
+	}
+	if err := ValidatePrefix(newPrefix); err != nil {
+		return err
+	}
+	// The copies would be listed and copied again, and removing the old
+	// prefix would remove them too
+	src, dst := prefixKey(oldPrefix, ""), prefixKey(newPrefix, "")
+	if strings.HasPrefix(dst, src) || strings.HasPrefix(src, dst) {
+		return fmt.Errorf("cannot rename %s to %s in bucket %s, one contains the other", oldPrefix, newPrefix, bucketName)
+	}
+	done, err := client.begin("rename prefix " + oldPrefix)
+	if err != nil {
+		return err
+	}
+	defer done()
+	// Renaming again finds nothing left to move
+	exists, _, err := client.VolumeExists(bucketName, oldPrefix)
+	if err != nil || !exists {
+		return err
+	}
+	if err := client.checkRenameTarget(bucketName, oldPrefix, newPrefix); err != nil {
+		return err
+	}
+	// Only the objects of the driver at the top of the volume are its own,
+	// the lock and delete checkpoint are left behind, and the placeholder
+	// and metadata are written anew. Nested objects of the same names are
+	// data of the volume.
+	skip := func(key string) bool {
+		return client.isDriverObject(oldPrefix, key)
+	}
+	if err := client.copyObjects(bucketName, oldPrefix, bucketName, newPrefix, skip); err != nil {
+		return err
+	}
+	if !client.Config.NoPlaceholder {
+		if _, err := client.putObject(client.ctx, bucketName, newPrefix+"/", []byte{}, client.objectOptions()); err != nil {
+			return fmt.Errorf("failed to create placeholder of %s/%s: %w", bucketName, newPrefix, err)
+		}
+	}
+	if err := client.moveMeta(bucketName, oldPrefix, newPrefix); err != nil {
+		return err
+	}
+	_, err = client.RemovePrefix(bucketName, oldPrefix)
+	return err
+}
+
+// checkRenameTarget refuses to rename a volume onto a prefix holding data,
+// unless that data consists of copies of the volume's objects left by an
+// interrupted rename
+func (client *s3Client) checkRenameTarget(bucketName, oldPrefix, newPrefix string) error {
+	empty, err := client.IsPrefixEmpty(bucketName, newPrefix)
+	if err != nil || empty {
+		return err
+	}
+	sizes, err := client.userObjects(bucketName, oldPrefix)
+	if err != nil {
+		return err
+	}
+	copies, err := client.userObjects(bucketName, newPrefix)
+	if err != nil {
+		return err
+	}
+	for name, size := range copies {
+		if srcSize, ok := sizes[name]; !ok || srcSize != size {
+			return fmt.Errorf("cannot rename %s to %s in bucket %s: %w", oldPrefix, newPrefix, bucketName, ErrPrefixNotEmpty)
+		}
+	}
+	return nil
+}
+
+// userObjects returns the sizes of the objects under prefix that are not the
+// driver's own, by their names relative to prefix
+func (client *s3Client) userObjects(bucketName, prefix string) (map[string]int64, error) {
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	sizes := make(map[string]int64)
+	for object := range client.list(ctx, bucketName, client.listOptions(prefix+"/", true)) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !client.isDriverObject(prefix, object.Key) {
+			sizes[strings.TrimPrefix(object.Key, prefix+"/")] = object.Size
+		}
+	}
+	return sizes, nil
+}
+
+// copyObjects copies the objects under srcPrefix to dstPrefix in parallel,
+// except for those skip returns true for. At most copyParallelism copies are
+// in flight at a time. If some copies fail, a *CopyError names the keys of
+// their source objects.
+func (client *s3Client) copyObjects(srcBucket, srcPrefix, dstBucket, dstPrefix string, skip func(key string) bool) error {
+	guardCh := make(chan int, copyParallelism)
+	var wg sync.WaitGroup
+	var totalObjects int64
+	var failedMutex sync.Mutex
+	var failed []string
+
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	objectsCh, listResult := client.listObjects(ctx, srcBucket, client.listOptions(prefixKey(srcPrefix, ""), true))
+	copyCtx := ctx
+	if client.Config.ObjectStorageClass != "" {
+		// minio has no option for the storage class of copies
+		copyCtx = withSignedHeaders(ctx, map[string]string{storageClassHeader: client.Config.ObjectStorageClass})
+	}
+
+	for object := range objectsCh {
+		if skip(object.Key) {
+			continue
+		}
+		totalObjects++
+		guardCh <- 1
+		wg.Add(1)
+		go func(object minio.ObjectInfo) {
+			defer wg.Done()
+			newKey := prefixKey(dstPrefix, strings.TrimPrefix(object.Key, prefixKey(srcPrefix, "")))
+			dst := minio.CopyDestOptions{Bucket: dstBucket, Object: newKey}
+			err := client.withRetry(dstBucket, func(c *minio.Client) (err error) {
+				if object.Size > client.copyPartSize() {
+					// Copied in parts
+					_, err = c.ComposeObject(copyCtx, dst, client.copySources(srcBucket, object)...)
+				} else {
+					src := minio.CopySrcOptions{Bucket: srcBucket, Object: object.Key}
+					_, err = c.CopyObject(copyCtx, dst, src)
+				}
+				return err
+			})
+			if err != nil {
+				client.log().Errorf("Failed to copy object %s to %s, error: %s", object.Key, newKey, err)
+				failedMutex.Lock()
+				failed = append(failed, object.Key)
+				failedMutex.Unlock()
+			}
+			<-guardCh
+		}(object)
+	}
+	wg.Wait()
+
+	if listErr := listResult(); listErr != nil {
+		client.log().Errorf("Error listing objects: %v", listErr)
+		return listErr
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return &CopyError{Bucket: srcBucket, Prefix: srcPrefix, Keys: failed, Total: totalObjects}
+	}
+	return nil
+}
+
+// prefixKey returns the key of name under the prefix of a volume, which is
+// empty for volumes with their own bucket
+func prefixKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// moveMeta updates the prefix stored in the metadata of a renamed volume. A
+// detached metadata object is moved to the key of the new prefix.
+func (client *s3Client) moveMeta(bucketName, oldPrefix, newPrefix string) error {
+	if client.Config.DisableMetadata {
+		return nil
+	}
+	meta, err := client.ReadMeta(bucketName, oldPrefix)
+	if errors.Is(err, ErrNotFound) {
+		// Already moved by an earlier attempt, or there is none
+		meta, err = client.ReadMeta(bucketName, newPrefix)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta.Prefix = newPrefix
+	// The object at the new key is replaced, whatever its ETag
+	meta.ETag = ""
+	if err = client.WriteMeta(meta); err != nil {
+		return err
+	}
+	if err = client.removeDetachedMeta(bucketName, oldPrefix); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/replication"
+)
+
+// replicationRuleID is the ID of the replication rule set by the driver
+const replicationRuleID = "csi-s3"
+
+// ReplicationConfig describes the replication of all objects of a bucket to
+// another bucket, e.g. in another region for disaster recovery
+type ReplicationConfig struct {
+	// Role is the ARN of the IAM role S3 assumes to replicate objects
+	Role string
+	// DestinationBucket is the name or ARN of the bucket replicas are
+	// written to. It must have versioning enabled too.
+	DestinationBucket string
+	// StorageClass is the storage class of the replicas, that of the
+	// source objects if empty
+	StorageClass string
+}
+
+// ValidateReplication checks the role and destination of a replication
+// configuration
+func ValidateReplication(cfg ReplicationConfig) error {
+	if cfg.Role == "" || cfg.DestinationBucket == "" {
+		return fmt.Errorf("%w: replication requires both a role and a destination bucket", ErrInvalidConfig)
+	}
+	if parts := strings.Split(cfg.Role, ":"); len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || !strings.HasPrefix(parts[5], "role/") {
+		return fmt.Errorf("%w: replication role %q is not an IAM role ARN", ErrInvalidConfig, cfg.Role)
+	}
+	if strings.Contains(cfg.DestinationBucket, ":") {
+		if parts := strings.Split(cfg.DestinationBucket, ":"); len(parts) != 6 || parts[0] != "arn" || parts[2] != "s3" || parts[5] == "" {
+			return fmt.Errorf("%w: replication destination %q is neither a bucket name nor a bucket ARN", ErrInvalidConfig, cfg.DestinationBucket)
+		}
+	}
+	return nil
+}
+
+// SetBucketReplication makes a bucket replicate all of its objects as
+// configured, replacing any replication configuration it has. Replication
+// requires versioning, so the bucket must have it enabled already.
+func (client *s3Client) SetBucketReplication(bucketName string, cfg ReplicationConfig) error {
+	if err := checkGeneralPurposeBucket(bucketName, "set bucket replication"); err != nil {
+		return err
+	}
+	if err := ValidateReplication(cfg); err != nil {
+		return err
+	}
+	versioned, err := client.GetBucketVersioning(bucketName)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		return fmt.Errorf("%w: bucket %s", ErrVersioningRequired, bucketName)
+	}
+	var config replication.Config
+	err = config.AddRule(replication.Options{
+		Op:           replication.AddOption,
+		ID:           replicationRuleID,
+		RuleStatus:   "enable",
+		Priority:     "1",
+		RoleArn:      cfg.Role,
+		DestBucket:   cfg.DestinationBucket,
+		StorageClass: cfg.StorageClass,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: replication: %v", ErrInvalidConfig, err)
+	}
+	err = client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.SetBucketReplication(client.ctx, bucketName, config)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set replication of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	restoreHeader = "X-Amz-Restore"
+	// restoreTier is the retrieval tier of restore requests. Expedited
+	// retrieval isn't available for DEEP_ARCHIVE.
+	restoreTier = "Standard"
+	// restoreURLExpiry is the lifetime of the presigned URLs of restore and
+	// status requests, which are sent right away
+	restoreURLExpiry = time.Minute
+)
+
+// archiveStorageClasses are the storage classes whose objects have to be
+// restored before they can be read
+var archiveStorageClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// archivedReader maps the error of reading an archived object which isn't
+// restored to ErrObjectArchived. Only the GET is refused, which minio sends
+// on the first read, not on Stat.
+type archivedReader struct {
+	*minio.Object
+	bucketName, key string
+}
+
+func (r *archivedReader) Read(p []byte) (int, error) {
+	n, err := r.Object.Read(p)
+	if err != nil && minio.ToErrorResponse(err).Code == "InvalidObjectState" {
+		err = fmt.Errorf("%w: %s/%s", ErrObjectArchived, r.bucketName, r.key)
+	}
+	return n, err
+}
+
+// RestoreStatus counts the archived objects under a prefix by their restore
+// state. Archived objects are neither restored nor being restored.
+type RestoreStatus struct {
+	Archived  int
+	Restoring int
+	Restored  int
+}
+
+// Pending returns the number of archived objects that can't be read yet
+func (s RestoreStatus) Pending() int {
+	return s.Archived + s.Restoring
+}
+
+type restoreState int
+
+const (
+	stateArchived restoreState = iota
+	stateRestoring
+	stateRestored
+)
+
+func (s *RestoreStatus) count(state restoreState) {
+	switch state {
+	case stateArchived:
+		s.Archived++
+	case stateRestoring:
+		s.Restoring++
+	case stateRestored:
+		s.Restored++
+	}
+}
+
+// RestoreStatus checks the restore state of the archived objects under prefix
+func (client *s3Client) RestoreStatus(bucketName, prefix string) (RestoreStatus, error) {
+	var status RestoreStatus
+	err := client.walkArchived(bucketName, prefix, func(_ *http.Client, _ string, state restoreState) error {
+		status.count(state)
+		return nil
+	})
+	return status, err
+}
+
+// RestoreObjects requests the archived objects under prefix which are not
+// restored yet to be restored for days. It returns ErrObjectArchived as long
+// as any of them can't be read yet, so it can be called until it succeeds.
+func (client *s3Client) RestoreObjects(bucketName, prefix string, days int) error {
+	if client.Config.Anonymous {
+		return fmt.Errorf("cannot restore objects of %s/%s: %w", bucketName, prefix, ErrAnonymousAccess)
+	}
+	if days < 1 {
+		return fmt.Errorf("%w: objects must be restored for at least one day, not %d", ErrInvalidConfig, days)
+	}
+	var status RestoreStatus
+	err := client.walkArchived(bucketName, prefix, func(hc *http.Client, key string, state restoreState) error {
+		if state == stateArchived {
+			if err := client.restoreObject(hc, bucketName, key, days); err != nil {
+				return err
+			}
+			state = stateRestoring
+		}
+		status.count(state)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if pending := status.Pending(); pending > 0 {
+		return fmt.Errorf("%w: %d of %d objects under %s/%s are being restored",
+			ErrObjectArchived, pending, pending+status.Restored, bucketName, prefix)
+	}
+	return nil
+}
+
+// walkArchived calls fn with the restore state of every archived object under
+// prefix. minio has no requests for restores, so they are sent to presigned
+// URLs with the http.Client passed to fn.
+func (client *s3Client) walkArchived(bucketName, prefix string, fn func(hc *http.Client, key string, state restoreState) error) error {
+	if prefix != "" {
+		prefix += "/"
+	}
+	_, ssl, err := parseEndpoint(client.Config.Endpoint)
+	if err != nil {
+		return err
+	}
+	transport, err := newTransport(client.Config, ssl)
+	if err != nil {
+		return err
+	}
+	hc := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	for object := range client.list(ctx, bucketName, client.listOptions(prefix, true)) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if !archiveStorageClasses[object.StorageClass] {
+			continue
+		}
+		state, err := client.restoreState(hc, bucketName, object.Key)
+		if err != nil {
+			return err
+		}
+		if err = fn(hc, object.Key, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreState reads the restore state of an archived object from the
+// x-amz-restore header, which minio doesn't keep in ObjectInfo
+func (client *s3Client) restoreState(hc *http.Client, bucketName, key string) (restoreState, error) {
+	resp, err := client.presignedRequest(hc, http.MethodHead, bucketName, key, nil, nil)
+	if err != nil {
+		return stateArchived, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stateArchived, fmt.Errorf("failed to check restore state of %s/%s: %s", bucketName, key, resp.Status)
+	}
+	restore := resp.Header.Get(restoreHeader)
+	switch {
+	case restore == "":
+		return stateArchived, nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return stateRestoring, nil
+	default:
+		return stateRestored, nil
+	}
+}
+
+// restoreObject requests an archived object to be restored for days
+func (client *s3Client) restoreObject(hc *http.Client, bucketName, key string, days int) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"RestoreRequest"`
+		Days    int
+		Tier    string `xml:"GlacierJobParameters>Tier"`
+	}{Days: days, Tier: restoreTier})
+	if err != nil {
+		return err
+	}
+	resp, err := client.presignedRequest(hc, http.MethodPost, bucketName, key, url.Values{"restore": {""}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		client.log().V(4).Infof("Requested restore of %s/%s for %d days", bucketName, key, days)
+		return nil
+	}
+	var errResp minio.ErrorResponse
+	if xml.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Code == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return fmt.Errorf("failed to restore %s/%s: %s %s", bucketName, key, resp.Status, errResp.Code)
+}
+
+// presignedRequest sends a request to a presigned URL of an object
+func (client *s3Client) presignedRequest(hc *http.Client, method, bucketName, key string, params url.Values, body []byte) (*http.Response, error) {
+	u, err := client.bucketClient(bucketName).Presign(client.ctx, method, bucketName, key, restoreURLExpiry, params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(client.ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return hc.Do(req)
+}
@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRestoreObjects(t *testing.T) {
+	client, fake := newTestClient(t, "bucket")
+	fake.put("bucket", "vol/hot", "data")
+	fake.put("bucket", "vol/cold", "data")
+	fake.put("bucket", "vol/deep", "data")
+	fake.put("bucket", "other/cold", "data")
+	fake.buckets["bucket"]["vol/cold"].header.Set("X-Amz-Storage-Class", "GLACIER")
+	fake.buckets["bucket"]["vol/deep"].header.Set("X-Amz-Storage-Class", "DEEP_ARCHIVE")
+	fake.buckets["bucket"]["other/cold"].header.Set("X-Amz-Storage-Class", "GLACIER")
+
+	obj, err := client.GetObject("bucket", "vol/cold")
+	if err != nil {
+		t.Fatalf("GetObject() of archived object error = %v", err)
+	}
+	if _, err = ioutil.ReadAll(obj); !errors.Is(err, ErrObjectArchived) {
+		t.Errorf("reading archived object error = %v, want ErrObjectArchived", err)
+	}
+	obj.Close()
+	status, err := client.RestoreStatus("bucket", "vol")
+	if err != nil {
+		t.Fatalf("RestoreStatus() error = %v", err)
+	}
+	if want := (RestoreStatus{Archived: 2}); status != want {
+		t.Errorf("RestoreStatus() = %+v, want %+v", status, want)
+	}
+
+	if err = client.RestoreObjects("bucket", "vol", 0); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("RestoreObjects() for 0 days error = %v, want ErrInvalidConfig", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = client.RestoreObjects("bucket", "vol", 7); !errors.Is(err, ErrObjectArchived) {
+			t.Fatalf("RestoreObjects() while restoring error = %v, want ErrObjectArchived", err)
+		}
+	}
+	if status, _ = client.RestoreStatus("bucket", "vol"); status != (RestoreStatus{Restoring: 2}) {
+		t.Errorf("RestoreStatus() after RestoreObjects() = %+v, want 2 restoring", status)
+	}
+	if status, _ = client.RestoreStatus("bucket", "other"); status != (RestoreStatus{Archived: 1}) {
+		t.Errorf("RestoreStatus() of other prefix = %+v, want 1 archived", status)
+	}
+
+	fake.Lock()
+	fake.buckets["bucket"]["vol/cold"].header.Set("X-Amz-Restore", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	fake.buckets["bucket"]["vol/deep"].header.Set("X-Amz-Restore", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	fake.Unlock()
+	if err = client.RestoreObjects("bucket", "vol", 7); err != nil {
+		t.Errorf("RestoreObjects() after restore error = %v", err)
+	}
+	if obj, err = client.GetObject("bucket", "vol/cold"); err != nil {
+		t.Fatalf("GetObject() of restored object error = %v", err)
+	}
+	defer obj.Close()
+	if _, err = ioutil.ReadAll(obj); err != nil {
+		t.Errorf("reading restored object error = %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// secretBoolKeys are the secret keys taking "true" or "false"
+var secretBoolKeys = []string{
+	"anonymous", "noLocationConstraint", "disableMetadata", "regionDiscovery", "useDualStack", "listObjectsV1",
+	"resumableDelete", "requesterPays", "skipDeleteCheck", "forceDelete", "confirmLargeDelete", "useIAM",
+	"lenientBucketNames",
+}
+
+// secretDurationKeys are the secret keys taking a Go duration
+var secretDurationKeys = []string{"requestTimeout", "dialTimeout", "bucketCacheTTL"}
+
+// ValidateSecret checks all keys of a secret and returns every problem found,
+// each wrapping ErrInvalidConfig, instead of only the first one. Keys the
+// secret doesn't set are checked with the values of the driver defaults.
+func ValidateSecret(secret map[string]string) []error {
+	secret = withDefaults(secret)
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	endpoint, err := normalizeEndpoint(secret["endpoint"])
+	check(err)
+	if err == nil {
+		host, _, err := parseEndpoint(endpoint)
+		check(err)
+		if err == nil && secret["useDualStack"] == "true" && secret["region"] == "" && endpointRegion(host) == "" &&
+			awsEndpointRegex.MatchString(host) {
+			check(fmt.Errorf("%w: useDualStack requires region", ErrInvalidConfig))
+		}
+	}
+
+	_, err = ParseFallbackEndpoints(secret["fallbackEndpoints"])
+	check(err)
+	check(ValidateRegion("region", secret["region"]))
+	check(ValidateRegion("signingRegion", secret["signingRegion"]))
+
+	for _, key := range secretBoolKeys {
+		if v := secret[key]; v != "" && v != "true" && v != "false" {
+			check(fmt.Errorf("%w: %s: %q is neither true nor false", ErrInvalidConfig, key, v))
+		}
+	}
+	for _, key := range secretDurationKeys {
+		if secret[key] == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(secret[key]); err != nil {
+			check(fmt.Errorf("%w: %s: %v", ErrInvalidConfig, key, err))
+		} else if d < 0 {
+			check(fmt.Errorf("%w: %s: %s is negative", ErrInvalidConfig, key, secret[key]))
+		}
+	}
+	_, err = ParseAllowedBuckets(secret["allowedBuckets"])
+	check(err)
+	check(ValidateReclaimPolicy(secret["reclaimPolicy"]))
+	check(ValidateRemoveScope(secret["removeScope"]))
+	check(ValidateCannedACL(secret["cannedACL"]))
+	check(ValidateProxyURL(secret["proxyURL"]))
+	_, err = ParseCustomHeaders(secret["customHeaders"])
+	check(err)
+	if v := secret["listMaxKeys"]; v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			check(fmt.Errorf("%w: listMaxKeys: %s", ErrInvalidConfig, v))
+		}
+	}
+	for _, key := range []string{"deleteMaxObjects", "deleteMaxBytes"} {
+		if v := secret[key]; v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err != nil || n < 0 {
+				check(fmt.Errorf("%w: %s: %s", ErrInvalidConfig, key, v))
+			}
+		}
+	}
+	if v := secret["partSize"]; v != "" {
+		// In MiB, as the mounters take it
+		if size, err := strconv.ParseUint(v, 10, 32); err != nil {
+			check(fmt.Errorf("%w: partSize: %s", ErrInvalidConfig, v))
+		} else {
+			check(ValidatePartSize(size << 20))
+		}
+	}
+	if v := secret["uploadConcurrency"]; v != "" {
+		if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+			check(fmt.Errorf("%w: uploadConcurrency: %s", ErrInvalidConfig, v))
+		}
+	}
+
+	// Credentials
+	if (secret["accessKeyID"] == "") != (secret["secretAccessKey"] == "") {
+		check(fmt.Errorf("%w: accessKeyID and secretAccessKey must be set together", ErrInvalidConfig))
+	}
+	useProfile := secret["profile"] != "" || secret["credentialsFile"] != ""
+	if useProfile && (secret["accessKeyID"] != "" || secret["secretAccessKey"] != "") {
+		check(fmt.Errorf("%w: profile and credentialsFile can't be used together with accessKeyID and secretAccessKey", ErrInvalidConfig))
+	}
+	_, err = webIdentityTokenFile(secret)
+	check(err)
+	check(validateIAM(secret))
+	return errs
+}
+
+// validateIAM checks that useIAM isn't combined with other credentials
+func validateIAM(secret map[string]string) error {
+	if secret["useIAM"] != "true" {
+		if secret["iamEndpoint"] != "" {
+			return fmt.Errorf("%w: iamEndpoint requires useIAM", ErrInvalidConfig)
+		}
+		return nil
+	}
+	var conflicts []string
+	for _, key := range []string{"accessKeyID", "secretAccessKey", "profile", "credentialsFile", "roleArn", "tokenFile"} {
+		if secret[key] != "" {
+			conflicts = append(conflicts, key)
+		}
+	}
+	if secret["anonymous"] == "true" {
+		conflicts = append(conflicts, "anonymous")
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: useIAM can't be used together with %s", ErrInvalidConfig, strings.Join(conflicts, ", "))
+	}
+	if endpoint := secret["iamEndpoint"]; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: iamEndpoint: %q is not an http or https URL", ErrInvalidConfig, endpoint)
+		}
+	}
+	return nil
+}
+
+// secretError combines the problems found by ValidateSecret into one error
+func secretError(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	prefix := ErrInvalidConfig.Error() + ": "
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = strings.TrimPrefix(err.Error(), prefix)
+	}
+	return fmt.Errorf("%w: %d problems: %s", ErrInvalidConfig, len(errs), strings.Join(msgs, "; "))
+}
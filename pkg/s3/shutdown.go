@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// operations tracks the clients with long-running operations in flight, so
+// that Shutdown can wait for them. Like activeEndpoints it is shared by all
+// clients, as a new client is created for every request.
+var operations = struct {
+	sync.Mutex
+	closed  bool
+	clients map[*s3Client]bool
+}{clients: make(map[*s3Client]bool)}
+
+// begin registers a long-running operation of the client, such as removing
+// or copying a prefix, and returns the function to call when it's done. It
+// fails with ErrClosed once the client is closed or the driver shuts down.
+func (client *s3Client) begin(op string) (func(), error) {
+	operations.Lock()
+	defer operations.Unlock()
+	if operations.closed || client.closed {
+		return nil, fmt.Errorf("cannot %s: %w", op, ErrClosed)
+	}
+	client.inFlight.Add(1)
+	client.operations++
+	operations.clients[client] = true
+	return func() {
+		operations.Lock()
+		client.operations--
+		if client.operations == 0 {
+			delete(operations.clients, client)
+		}
+		operations.Unlock()
+		client.inFlight.Done()
+	}, nil
+}
+
+// Close stops the client from starting long-running operations and waits
+// for those in flight to finish. When ctx is done first, their requests are
+// cancelled: removals save their checkpoint and stop, so that the next
+// DeleteVolume resumes them. Close then returns the error of ctx.
+func (client *s3Client) Close(ctx context.Context) error {
+	operations.Lock()
+	client.closed = true
+	operations.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		client.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		client.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+	client.log().Warningf("Cancelling operations still in flight: %v", ctx.Err())
+	client.cancel()
+	<-done
+	return ctx.Err()
+}
+
+// Shutdown closes all clients with long-running operations in flight, see
+// Close, and makes all clients refuse to start new ones. It is called when
+// the driver terminates.
+func Shutdown(ctx context.Context) error {
+	operations.Lock()
+	operations.closed = true
+	clients := make([]*s3Client, 0, len(operations.clients))
+	for client := range operations.clients {
+		clients = append(clients, client)
+	}
+	operations.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(clients))
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *s3Client) {
+			defer wg.Done()
+			errs <- client.Close(ctx)
+		}(client)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
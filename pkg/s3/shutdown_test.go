@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	client, _ := newTestClient(t, "bucket")
+	done, err := client.begin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like a removal, stops once its requests are cancelled
+	go func() {
+		<-client.ctx.Done()
+		done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = client.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() error = %v, want DeadlineExceeded", err)
+	}
+	if client.ctx.Err() == nil {
+		t.Error("operations not cancelled after Close()")
+	}
+	if _, err = client.RemovePrefix("bucket", "volume"); !errors.Is(err, ErrClosed) {
+		t.Errorf("RemovePrefix() after Close() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Cleanup(func() {
+		operations.Lock()
+		operations.closed = false
+		operations.Unlock()
+	})
+	client, _ := newTestClient(t, "bucket")
+	done, err := client.begin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := false
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+		done()
+	}()
+
+	if err = Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if !finished {
+		t.Error("Shutdown() returned before the operation finished")
+	}
+	// Clients created afterwards refuse too
+	other, _ := newTestClient(t, "bucket")
+	if err = other.CopyPrefix("bucket", "a", "bucket", "b"); !errors.Is(err, ErrClosed) {
+		t.Errorf("CopyPrefix() after Shutdown() error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	requestPayerHeader = "X-Amz-Request-Payer"
+	cannedACLHeader    = "X-Amz-Acl"
+	storageClassHeader = "X-Amz-Storage-Class"
+	signV4Algorithm    = "AWS4-HMAC-SHA256"
+)
+
+var requesterPaysWarning sync.Once
+
+type signedHeadersKey struct{}
+
+type signingRegionKey struct{}
+
+// withSignedHeaders is like withHeaders, for x-amz-* headers which have to be
+// signed
+func withSignedHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, signedHeadersKey{}, headers)
+}
+
+// withSigningRegion makes the requests made with the context signed for
+// region, regardless of the region minio signs them for
+func withSigningRegion(ctx context.Context, region string) context.Context {
+	return context.WithValue(ctx, signingRegionKey{}, region)
+}
+
+// signingTransport adds x-amz-* headers which minio-go has no option for to
+// requests: the given headers to every request, and those set with
+// withSignedHeaders to the requests made with that context. These headers
+// have to be signed, so the request is signed again after adding them. It
+// also signs requests again for the region set with withSigningRegion.
+type signingTransport struct {
+	http.RoundTripper
+	creds   *credentials.Credentials
+	headers map[string]string
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctxHeaders, _ := req.Context().Value(signedHeadersKey{}).(map[string]string)
+	ctxRegion, _ := req.Context().Value(signingRegionKey{}).(string)
+	if len(t.headers) == 0 && len(ctxHeaders) == 0 && ctxRegion == "" {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	region, ok := signingRegion(req)
+	if ctxRegion != "" {
+		region = ctxRegion
+	}
+	// Streaming signatures chain the signature of every chunk of the body to
+	// the signature of the request, so these can't be signed again. minio only
+	// uses them for uploads over plain HTTP.
+	if !ok || strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	value, err := t.creds.Get()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range ctxHeaders {
+		req.Header.Set(k, v)
+	}
+	req.Header.Del("Authorization")
+	req = signer.SignV4(*req, value.AccessKeyID, value.SecretAccessKey, value.SessionToken, region)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// signingRegion returns the region a request has been signed for with
+// signature V4, taken from the credential scope of its Authorization header
+func signingRegion(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signV4Algorithm+" Credential=") {
+		return "", false
+	}
+	credential := strings.TrimPrefix(auth, signV4Algorithm+" Credential=")
+	credential = strings.SplitN(credential, ",", 2)[0]
+	// <access key>/<date>/<region>/s3/aws4_request, the access key may contain slashes
+	scope := strings.Split(credential, "/")
+	if len(scope) < 5 {
+		return "", false
+	}
+	return scope[len(scope)-3], true
+}
+
+// warnRequesterPays logs once that requests are billed to the driver's
+// account
+func warnRequesterPays() {
+	requesterPaysWarning.Do(func() {
+		glog.Warningf("Requester pays is enabled: requests and data transfer of all buckets " +
+			"accessed by the driver are billed to the owner of its credentials")
+	})
+}
@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+type recordingTransport struct {
+	req *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestSigningTransport(t *testing.T) {
+	recorder := &recordingTransport{}
+	transport := &signingTransport{
+		RoundTripper: recorder,
+		creds:        credentials.NewStaticV4("key", "secret", ""),
+		headers:      map[string]string{requestPayerHeader: "requester"},
+	}
+
+	ctx := withSignedHeaders(context.Background(), map[string]string{cannedACLHeader: "private"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, "https://s3.eu-west-1.amazonaws.com/bucket", nil)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	req = signer.SignV4(*req, "key", "secret", "", "eu-west-1")
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := recorder.req
+	if got := sent.Header.Get(requestPayerHeader); got != "requester" {
+		t.Errorf("%s = %q, want requester", requestPayerHeader, got)
+	}
+	if got := sent.Header.Get(cannedACLHeader); got != "private" {
+		t.Errorf("%s = %q, want private", cannedACLHeader, got)
+	}
+	auth := sent.Header.Get("Authorization")
+	if !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Authorization = %q, not signed for eu-west-1", auth)
+	}
+	if !strings.Contains(auth, "x-amz-acl") || !strings.Contains(auth, "x-amz-request-payer") {
+		t.Errorf("Authorization = %q, added headers not signed", auth)
+	}
+	if req.Header.Get(cannedACLHeader) != "" {
+		t.Errorf("original request was modified")
+	}
+}
+
+func TestSigningTransportRegion(t *testing.T) {
+	recorder := &recordingTransport{}
+	transport := &signingTransport{
+		RoundTripper: recorder,
+		creds:        credentials.NewStaticV4("key", "secret", ""),
+	}
+
+	ctx := withSigningRegion(context.Background(), "default")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, "https://rgw.example.com/bucket", nil)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	req = signer.SignV4(*req, "key", "secret", "", "us-east-1")
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if auth := recorder.req.Header.Get("Authorization"); !strings.Contains(auth, "/default/s3/aws4_request") {
+		t.Errorf("Authorization = %q, not signed for default", auth)
+	}
+}
@@ -0,0 +1,189 @@
+package s3
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+)
+
+// sizeClasses are the upper bounds of the object size histogram
+var sizeClasses = []int64{
+	1 << 10,  // 1 KiB
+	4 << 10,  // 4 KiB
+	64 << 10, // 64 KiB
+	1 << 20,  // 1 MiB
+	16 << 20, // 16 MiB
+	math.MaxInt64,
+}
+
+// SizeClass counts the objects up to a certain size
+type SizeClass struct {
+	MaxBytes int64
+	Objects  int64
+}
+
+// PrefixStats describes the objects under a prefix
+type PrefixStats struct {
+	Objects   int64
+	Bytes     int64
+	Histogram []SizeClass
+}
+
+// statsParallelism is the number of sub-prefixes listed at the same time by
+// PrefixStats
+const statsParallelism = 8
+
+func newPrefixStats() *PrefixStats {
+	stats := &PrefixStats{Histogram: make([]SizeClass, len(sizeClasses))}
+	for i, max := range sizeClasses {
+		stats.Histogram[i].MaxBytes = max
+	}
+	return stats
+}
+
+func (stats *PrefixStats) addObject(size int64) {
+	stats.Objects++
+	stats.Bytes += size
+	for i, max := range sizeClasses {
+		if size <= max {
+			stats.Histogram[i].Objects++
+			break
+		}
+	}
+}
+
+func (stats *PrefixStats) merge(other *PrefixStats) {
+	stats.Objects += other.Objects
+	stats.Bytes += other.Bytes
+	for i := range stats.Histogram {
+		stats.Histogram[i].Objects += other.Histogram[i].Objects
+	}
+}
+
+// PrefixStats lists all objects under prefix and returns their count, total
+// size and a histogram of their sizes. Many small objects make listing, and
+// with it deleting the volume, slow. The top level of the prefix is listed
+// first, and the prefixes found there are then listed in parallel, which
+// is much faster than a single listing for deep trees.
+func (client *s3Client) PrefixStats(bucketName, prefix string) (*PrefixStats, error) {
+	if prefix != "" {
+		prefix += "/"
+	}
+	stats := newPrefixStats()
+	subPrefixes, err := client.listTopLevel(bucketName, prefix, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	guardCh := make(chan int, statsParallelism)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	for _, subPrefix := range subPrefixes {
+		guardCh <- 1
+		wg.Add(1)
+		go func(subPrefix string) {
+			defer wg.Done()
+			defer func() { <-guardCh }()
+			subStats, err := client.listStats(ctx, bucketName, subPrefix)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			stats.merge(subStats)
+		}(subPrefix)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return stats, nil
+}
+
+// listTopLevel adds the objects directly under prefix to stats and returns
+// the prefixes below it. Flat buckets are fully listed here.
+func (client *s3Client) listTopLevel(bucketName, prefix string, stats *PrefixStats) ([]string, error) {
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	var subPrefixes []string
+	objectsCh, listResult := client.listObjects(ctx, bucketName, client.listOptions(prefix, false))
+	for object := range objectsCh {
+		// Common prefixes are listed as keys ending with the delimiter. The
+		// placeholder of the prefix itself is an object.
+		if strings.HasSuffix(object.Key, "/") && object.Key != prefix {
+			subPrefixes = append(subPrefixes, object.Key)
+			continue
+		}
+		stats.addObject(object.Size)
+	}
+	if err := listResult(); err != nil {
+		return nil, err
+	}
+	return subPrefixes, nil
+}
+
+// listStats lists all objects under prefix and returns their stats
+func (client *s3Client) listStats(ctx context.Context, bucketName, prefix string) (*PrefixStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stats := newPrefixStats()
+	objectsCh, listResult := client.listObjects(ctx, bucketName, client.listOptions(prefix, true))
+	for object := range objectsCh {
+		stats.addObject(object.Size)
+	}
+	if err := listResult(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetBucketUsage returns the total size of the objects of a volume, i.e.
+// under prefix or in the whole bucket if prefix is empty
+func (client *s3Client) GetBucketUsage(bucketName, prefix string) (int64, error) {
+	stats, err := client.PrefixStats(bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return stats.Bytes, nil
+}
+
+// CapacityExceeded reports whether the objects of a volume take more than
+// the capacity in its metadata, along with their total size. S3 itself
+// doesn't limit the size of prefixes or buckets, so this is how the driver
+// enforces capacities. Volumes without a capacity are never exceeded and
+// not listed.
+func (client *s3Client) CapacityExceeded(meta *FSMeta) (bool, int64, error) {
+	if meta.CapacityBytes <= 0 {
+		return false, 0, nil
+	}
+	usage, err := client.GetBucketUsage(meta.BucketName, meta.Prefix)
+	if err != nil {
+		return false, 0, err
+	}
+	return usage > meta.CapacityBytes, usage, nil
+}
+
+// RefreshCapacity sets the capacity stored in the metadata of a volume to
+// its current usage and returns it
+func (client *s3Client) RefreshCapacity(bucketName, prefix string) (int64, error) {
+	usage, err := client.GetBucketUsage(bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+	err = client.UpdateMeta(bucketName, prefix, func(meta *FSMeta) error {
+		meta.CapacityBytes = usage
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return usage, nil
+}
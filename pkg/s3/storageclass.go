@@ -0,0 +1,28 @@
+package s3
+
+import "fmt"
+
+// storageClasses are the storage classes objects of volumes can be written
+// with: those of AWS that can be read without a restore, and the COLD and
+// ICE classes of Yandex Object Storage. GLACIER and DEEP_ARCHIVE objects
+// can't be read back by the driver or the mounters.
+var storageClasses = map[string]bool{
+	"STANDARD":            true,
+	"REDUCED_REDUNDANCY":  true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER_IR":          true,
+	"EXPRESS_ONEZONE":     true,
+	"OUTPOSTS":            true,
+	"COLD":                true,
+	"ICE":                 true,
+}
+
+// ValidateStorageClass checks that class is empty or a known storage class
+func ValidateStorageClass(class string) error {
+	if class != "" && !storageClasses[class] {
+		return fmt.Errorf("%w: unknown storage class %q", ErrInvalidConfig, class)
+	}
+	return nil
+}
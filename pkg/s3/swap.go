@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"fmt"
+)
+
+// SwapMetadata exchanges the metadata of the volumes at bucketName/prefixA
+// and bucketName/prefixB, e.g. to promote a staging prefix to production.
+// The stored bucket and prefix are kept, everything else, like the mounter,
+// its options and the capacity, is swapped.
+//
+// S3 can't write two objects atomically. Both volumes are locked during the
+// swap, so that other callers of the driver wait for it, and both metadata
+// objects are written conditionally, so that changes by anyone else abort it
+// with ErrMetadataConflict. If the second write fails, the first one is
+// rolled back. Only if the rollback fails too, e.g. because the endpoint
+// went down in between, both volumes are left with the same metadata, which
+// the error tells.
+func (client *s3Client) SwapMetadata(bucketName, prefixA, prefixB string) error {
+	if client.Config.Anonymous {
+		return fmt.Errorf("cannot swap metadata of %s/%s and %s: %w", bucketName, prefixA, prefixB, ErrAnonymousAccess)
+	}
+	if client.Config.DisableMetadata {
+		return fmt.Errorf("cannot swap metadata of %s/%s and %s: %w: metadata is disabled", bucketName, prefixA, prefixB, ErrNotFound)
+	}
+	if prefixA == prefixB {
+		return nil
+	}
+	// Always lock in the same order, so that two swaps of the same volumes
+	// can't each hold one lock and wait for the other
+	first, second := prefixA, prefixB
+	if second < first {
+		first, second = second, first
+	}
+	for _, prefix := range []string{first, second} {
+		if err := client.AcquireLock(bucketName, prefix, metadataLockTTL); err != nil {
+			return err
+		}
+		defer func(prefix string) {
+			if err := client.ReleaseLock(bucketName, prefix); err != nil {
+				client.log().Warningf("Failed to release lock of %s/%s: %v", bucketName, prefix, err)
+			}
+		}(prefix)
+	}
+
+	metaA, err := client.ReadMeta(bucketName, prefixA)
+	if err != nil {
+		return err
+	}
+	metaB, err := client.ReadMeta(bucketName, prefixB)
+	if err != nil {
+		return err
+	}
+	swappedA := crossMeta(metaB, bucketName, prefixA, metaA.ETag)
+	swappedB := crossMeta(metaA, bucketName, prefixB, metaB.ETag)
+
+	if err = client.WriteMeta(swappedA); err != nil {
+		return err
+	}
+	if err = client.WriteMeta(swappedB); err != nil {
+		// Only overwrite our own write of A
+		restored := crossMeta(metaA, bucketName, prefixA, swappedA.ETag)
+		if rollbackErr := client.WriteMeta(restored); rollbackErr != nil {
+			return fmt.Errorf("failed to write metadata of %s/%s: %w, and failed to roll back %s/%s, which now has the metadata of %s/%s: %v",
+				bucketName, prefixB, err, bucketName, prefixA, bucketName, prefixB, rollbackErr)
+		}
+		return fmt.Errorf("failed to write metadata of %s/%s, swap rolled back: %w", bucketName, prefixB, err)
+	}
+	client.log().Infof("Swapped metadata of %s/%s and %s/%s", bucketName, prefixA, bucketName, prefixB)
+	return nil
+}
+
+// crossMeta returns a copy of the metadata of another volume to write for the
+// volume at bucketName/prefix, conditional on etag. The bucket and prefix
+// are set from the arguments rather than the stored metadata, so that
+// mismatching metadata can't redirect the write to a third volume.
+func crossMeta(from *FSMeta, bucketName, prefix, etag string) *FSMeta {
+	meta := *from
+	meta.BucketName = bucketName
+	meta.Prefix = prefix
+	meta.ETag = etag
+	return &meta
+}
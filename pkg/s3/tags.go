@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// ParseTags parses tags given as a comma separated list of key=value pairs,
+// the format used in storage class parameters
+func ParseTags(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%w: tag %q, expected key=value", ErrInvalidConfig, pair)
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
+// ValidateObjectTags checks the limits S3 puts on object tags: at most 10
+// tags, keys of up to 128 and values of up to 256 characters
+func ValidateObjectTags(tagMap map[string]string) error {
+	if _, err := tags.MapToObjectTags(tagMap); err != nil {
+		return fmt.Errorf("%w: object tags: %v", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// ValidateBucketTags checks the limits S3 puts on bucket tags: at most 50
+// tags, keys of up to 128 and values of up to 256 characters
+func ValidateBucketTags(tagMap map[string]string) error {
+	if _, err := tags.MapToBucketTags(tagMap); err != nil {
+		return fmt.Errorf("%w: bucket tags: %v", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// SetBucketTags adds tags to a bucket. Existing tags with other keys are kept,
+// so provisioning a volume again doesn't drop tags set earlier or by others.
+func (client *s3Client) SetBucketTags(bucketName string, tagMap map[string]string) error {
+	if err := checkGeneralPurposeBucket(bucketName, "set bucket tags"); err != nil {
+		return err
+	}
+	merged, err := client.GetBucketTags(bucketName)
+	if err != nil {
+		return err
+	}
+	for k, v := range tagMap {
+		merged[k] = v
+	}
+	bucketTags, err := tags.MapToBucketTags(merged)
+	if err != nil {
+		return fmt.Errorf("invalid tags for bucket %s: %v", bucketName, err)
+	}
+	return client.withRetry(bucketName, func(c *minio.Client) error {
+		return c.SetBucketTagging(client.ctx, bucketName, bucketTags)
+	})
+}
+
+// GetBucketTags returns the tags of a bucket
+func (client *s3Client) GetBucketTags(bucketName string) (map[string]string, error) {
+	var bucketTags *tags.Tags
+	err := client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		bucketTags, err = c.GetBucketTagging(client.ctx, bucketName)
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchTagSet" {
+			return make(map[string]string), nil
+		}
+		return nil, fmt.Errorf("failed to get tags of bucket %s: %v", bucketName, err)
+	}
+	return bucketTags.ToMap(), nil
+}
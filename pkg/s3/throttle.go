@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// maxRemoveRetries is how often in a row the removal of throttled
+	// objects may be throttled again before giving up on them
+	maxRemoveRetries = 6
+	maxRemoveDelay   = 30 * time.Second
+	// minRemoveBatch is the number of throttled objects retried at first.
+	// maxRemoveBatch is the most a multi-object delete request takes.
+	minRemoveBatch = 10
+	maxRemoveBatch = 1000
+)
+
+// removeRetryDelay is the initial delay before retrying throttled removals
+var removeRetryDelay = time.Second
+
+// isThrottled reports whether err means the backend asks to slow down
+func isThrottled(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "SlowDown", "Throttling", "ServiceUnavailable", "TooManyRequests":
+		return true
+	}
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// removeBatch removes objects with multi-object deletes. The stats count the
+// objects removed and those that failed, the objects whose removal was
+// throttled are returned to be retried.
+func (client *s3Client) removeBatch(bucketName string, objects []minio.ObjectInfo) (RemoveStats, []minio.ObjectInfo) {
+	var stats RemoveStats
+	// Errors of the backend name the key of an object but not its version
+	pending := make(map[string][]minio.ObjectInfo, len(objects))
+	objectsCh := make(chan minio.ObjectInfo, len(objects))
+	for _, object := range objects {
+		stats.add(RemoveStats{Objects: 1, Bytes: object.Size})
+		pending[object.Key] = append(pending[object.Key], object)
+		objectsCh <- object
+	}
+	close(objectsCh)
+
+	var throttled []minio.ObjectInfo
+	opts := minio.RemoveObjectsOptions{GovernanceBypass: true}
+	// The objects are streamed to the endpoint in use, a failover during the
+	// removal fails the objects left and the next attempt uses the new one
+	for e := range client.bucketClient(bucketName).RemoveObjects(client.ctx, bucketName, objectsCh, opts) {
+		object := minio.ObjectInfo{Key: e.ObjectName, VersionID: e.VersionID}
+		versions := pending[e.ObjectName]
+		for i, version := range versions {
+			if e.VersionID == "" || version.VersionID == e.VersionID {
+				object = version
+				pending[e.ObjectName] = append(versions[:i], versions[i+1:]...)
+				break
+			}
+		}
+		stats.Objects--
+		stats.Bytes -= object.Size
+		if isThrottled(e.Err) {
+			throttled = append(throttled, object)
+			continue
+		}
+		client.log().Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
+		stats.Failed++
+	}
+	return stats, throttled
+}
+
+// retryThrottled removes objects whose removal was throttled again. They are
+// sent in batches starting at minRemoveBatch objects, doubled while the
+// backend keeps up and halved when it throttles again, backing off with
+// jittered, growing delays. It returns the stats of the retried objects.
+func (client *s3Client) retryThrottled(bucketName string, objects []minio.ObjectInfo) RemoveStats {
+	var stats RemoveStats
+	delay, batch := removeRetryDelay, minRemoveBatch
+	for throttles := 1; len(objects) > 0; {
+		if throttles > 0 {
+			if throttles > maxRemoveRetries {
+				client.log().Errorf("Giving up on removing %d throttled objects of bucket %s", len(objects), bucketName)
+				stats.Failed += int64(len(objects))
+				return stats
+			}
+			// Equal jitter, so parallel deletes don't retry in lockstep
+			half := delay / 2
+			wait := half + time.Duration(rand.Int63n(int64(half)+1))
+			client.log().Warningf("Removal of %d objects of bucket %s was throttled, retrying in batches of %d in %v",
+				len(objects), bucketName, batch, wait)
+			select {
+			case <-client.ctx.Done():
+				stats.Failed += int64(len(objects))
+				return stats
+			case <-time.After(wait):
+			}
+			if delay *= 2; delay > maxRemoveDelay {
+				delay = maxRemoveDelay
+			}
+		}
+
+		n := batch
+		if n > len(objects) {
+			n = len(objects)
+		}
+		removed, retry := client.removeBatch(bucketName, objects[:n])
+		stats.add(removed)
+		objects = append(retry, objects[n:]...)
+		if len(retry) > 0 {
+			throttles++
+			if batch /= 2; batch < minRemoveBatch {
+				batch = minRemoveBatch
+			}
+			continue
+		}
+		throttles, delay = 0, removeRetryDelay
+		if batch *= 2; batch > maxRemoveBatch {
+			batch = maxRemoveBatch
+		}
+	}
+	return stats
+}
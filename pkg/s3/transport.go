@@ -0,0 +1,195 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultDialTimeout    = 10 * time.Second
+	// proxyDirect as Config.ProxyURL connects to the endpoint directly,
+	// ignoring the proxy environment variables
+	proxyDirect = "direct"
+)
+
+// requestLimit bounds the number of concurrent requests of all clients, as
+// a new client is created for every CSI call. It is nil when unlimited.
+var requestLimit struct {
+	sync.RWMutex
+	sem *semaphore.Weighted
+}
+
+// SetRequestLimit limits the number of requests to the endpoints that are in
+// flight at the same time, across all clients. Zero or less removes the limit.
+func SetRequestLimit(n int64) {
+	requestLimit.Lock()
+	defer requestLimit.Unlock()
+	if n <= 0 {
+		requestLimit.sem = nil
+		return
+	}
+	requestLimit.sem = semaphore.NewWeighted(n)
+}
+
+func getRequestLimit() *semaphore.Weighted {
+	requestLimit.RLock()
+	defer requestLimit.RUnlock()
+	return requestLimit.sem
+}
+
+type headersKey struct{}
+
+// withHeaders returns a context which makes the transport add the given
+// headers to every request made with it. minio-go has no way to pass
+// arbitrary headers to most calls, so this is used for headers like
+// If-None-Match that don't have to be signed.
+func withHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// headerTransport adds the headers set with withHeaders to outgoing requests
+// and waits for a free slot if the request limit is reached
+type headerTransport struct {
+	http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sem := getRequestLimit(); sem != nil {
+		if err := sem.Acquire(req.Context(), 1); err != nil {
+			return nil, err
+		}
+		defer sem.Release(1)
+	}
+	headers, ok := req.Context().Value(headersKey{}).(map[string]string)
+	if !ok || len(headers) == 0 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// newTransport builds the HTTP transport used by the minio client. It starts
+// from minio's default transport and bounds the time spent dialing and waiting
+// for a response, so an unreachable endpoint can't block a CSI call forever.
+func newTransport(cfg *Config, ssl bool) (http.RoundTripper, error) {
+	tr, err := minio.DefaultTransport(ssl)
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout := cfg.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	requestTimeout := cfg.RequestTimeout
+	if requestTimeout <= 0 {
+		requestTimeout = defaultRequestTimeout
+	}
+	tr.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	tr.Proxy = proxyFunc(cfg.ProxyURL)
+	tr.ResponseHeaderTimeout = requestTimeout
+	if tr.TLSHandshakeTimeout > dialTimeout {
+		tr.TLSHandshakeTimeout = dialTimeout
+	}
+	var rt http.RoundTripper = tr
+	if len(cfg.CustomHeaders) > 0 {
+		rt = &customHeaderTransport{tr, cfg.CustomHeaders}
+	}
+	if path, rawPath := endpointPath(cfg.Endpoint); path != "" {
+		return &pathPrefixTransport{&headerTransport{rt}, path, rawPath}, nil
+	}
+	return &headerTransport{rt}, nil
+}
+
+// pathPrefixTransport sends requests to the sub-path of an endpoint, which
+// minio-go can't address. The path is added after the request has been
+// signed, as gateways serving S3 under a sub-path strip it before passing
+// requests on.
+type pathPrefixTransport struct {
+	http.RoundTripper
+	path, rawPath string
+}
+
+func (t *pathPrefixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Path = t.path + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = t.rawPath + req.URL.RawPath
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// ValidateProxyURL checks a proxy override, which is either "direct" or an
+// http, https or socks5 URL. Empty uses the environment.
+func ValidateProxyURL(proxy string) error {
+	if proxy == "" || proxy == proxyDirect {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("%w: proxyURL: %v", ErrInvalidConfig, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("%w: proxyURL %s: unsupported scheme %q", ErrInvalidConfig, u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: proxyURL %s: no host", ErrInvalidConfig, u.Redacted())
+	}
+	return nil
+}
+
+// proxyFunc returns the proxy selection of the transport: none for "direct",
+// the given proxy for a URL and the one of HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY otherwise. The proxy must have been validated.
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment
+	case proxyDirect:
+		return nil
+	}
+	u, _ := url.Parse(proxy)
+	return http.ProxyURL(u)
+}
+
+// logProxy logs how the endpoint is connected to, as a proxy picked up from
+// the environment is easy to miss
+func logProxy(endpoint, proxy string) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return
+	}
+	var u *url.URL
+	if f := proxyFunc(proxy); f != nil {
+		if u, err = f(req); err != nil {
+			glog.Warningf("Invalid proxy for %s: %v", endpoint, err)
+			return
+		}
+	}
+	source := "the environment"
+	if proxy != "" {
+		source = "proxyURL"
+	}
+	if u == nil {
+		glog.V(2).Infof("Connecting to %s directly, as set by %s", endpoint, source)
+		return
+	}
+	glog.V(2).Infof("Connecting to %s through proxy %s, as set by %s", endpoint, u.Redacted(), source)
+}
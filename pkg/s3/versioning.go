@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// SetBucketVersioning enables or suspends versioning of a bucket. Versioning
+// can't be turned off again once enabled, only suspended.
+func (client *s3Client) SetBucketVersioning(bucketName string, enabled bool) error {
+	if err := checkGeneralPurposeBucket(bucketName, "set bucket versioning"); err != nil {
+		return err
+	}
+	err := client.withRetry(bucketName, func(c *minio.Client) error {
+		if enabled {
+			return c.EnableVersioning(client.ctx, bucketName)
+		}
+		return c.SuspendVersioning(client.ctx, bucketName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set versioning of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// GetBucketVersioning reports whether versioning is enabled for a bucket
+func (client *s3Client) GetBucketVersioning(bucketName string) (bool, error) {
+	config, err := client.bucketVersioning(bucketName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get versioning of bucket %s: %w", bucketName, err)
+	}
+	return config.Status == "Enabled", nil
+}
+
+// hasVersions reports whether a bucket may hold object versions, which is
+// the case when versioning is enabled or has been suspended. Backends not
+// supporting versioning are treated as unversioned.
+func (client *s3Client) hasVersions(bucketName string) bool {
+	config, err := client.bucketVersioning(bucketName)
+	if err != nil {
+		client.log().V(4).Infof("Failed to get versioning of bucket %s, assuming it is not versioned: %v", bucketName, err)
+		return false
+	}
+	return config.Status != ""
+}
+
+func (client *s3Client) bucketVersioning(bucketName string) (config minio.BucketVersioningConfiguration, err error) {
+	err = client.withRetry(bucketName, func(c *minio.Client) (err error) {
+		config, err = c.GetBucketVersioning(client.ctx, bucketName)
+		return err
+	})
+	return config, err
+}
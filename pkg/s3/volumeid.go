@@ -0,0 +1,45 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatVolumeID returns the ID of the volume at bucket/prefix, which is the
+// bucket name for volumes with their own bucket. The prefix is kept as is,
+// slashes included: bucket names can't contain slashes, so the first one
+// always separates the bucket from the prefix and no escaping is needed.
+func FormatVolumeID(bucketName, prefix string) string {
+	if prefix == "" {
+		return bucketName
+	}
+	return bucketName + "/" + prefix
+}
+
+// ParseVolumeID splits a volume ID formatted by FormatVolumeID into the
+// bucket name and prefix. IDs with an empty bucket name or a prefix rejected
+// by ValidatePrefix, e.g. from a leading, trailing or double slash, are
+// rejected with ErrInvalidVolumeID, as the objects of such a prefix can't be
+// told apart from those of its parent by the mounters. So are access point
+// ARNs, which contain slashes themselves.
+func ParseVolumeID(volumeID string) (bucketName, prefix string, err error) {
+	parts := strings.SplitN(volumeID, "/", 2)
+	bucketName = parts[0]
+	if bucketName == "" {
+		return "", "", fmt.Errorf("%w: %q has no bucket name", ErrInvalidVolumeID, volumeID)
+	}
+	if IsAccessPointARN(bucketName) {
+		return "", "", fmt.Errorf("%w: %q names an access point by ARN, use the alias of the access point as bucket", ErrInvalidVolumeID, volumeID)
+	}
+	if len(parts) == 1 {
+		return bucketName, "", nil
+	}
+	prefix = parts[1]
+	if prefix == "" {
+		return "", "", fmt.Errorf("%w: %q has an empty prefix", ErrInvalidVolumeID, volumeID)
+	}
+	if err := ValidatePrefix(prefix); err != nil {
+		return "", "", fmt.Errorf("%w: %q: %v", ErrInvalidVolumeID, volumeID, err)
+	}
+	return bucketName, prefix, nil
+}
@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVolumeID(t *testing.T) {
+	for _, tc := range []struct {
+		id     string
+		bucket string
+		prefix string
+	}{
+		{"bucket", "bucket", ""},
+		{"bucket/pvc-1", "bucket", "pvc-1"},
+		{"bucket/team/app/pvc-1", "bucket", "team/app/pvc-1"},
+		{"my.bucket-1/pvc-1", "my.bucket-1", "pvc-1"},
+		{"data--use1-az4--x-s3/pvc-1", "data--use1-az4--x-s3", "pvc-1"},
+		{"bucket/dir.with.dots/pvc_1", "bucket", "dir.with.dots/pvc_1"},
+	} {
+		bucket, prefix, err := ParseVolumeID(tc.id)
+		if err != nil {
+			t.Errorf("ParseVolumeID(%q) error = %v", tc.id, err)
+			continue
+		}
+		if bucket != tc.bucket || prefix != tc.prefix {
+			t.Errorf("ParseVolumeID(%q) = %q, %q, want %q, %q", tc.id, bucket, prefix, tc.bucket, tc.prefix)
+		}
+		if got := FormatVolumeID(bucket, prefix); got != tc.id {
+			t.Errorf("FormatVolumeID(%q, %q) = %q, want %q", bucket, prefix, got, tc.id)
+		}
+	}
+
+	for _, id := range []string{"", "/pvc-1", "bucket/", "bucket//pvc-1", "bucket/pvc-1/", "bucket/a//b",
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/data/pvc-1"} {
+		if _, _, err := ParseVolumeID(id); !errors.Is(err, ErrInvalidVolumeID) {
+			t.Errorf("ParseVolumeID(%q) error = %v, want ErrInvalidVolumeID", id, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package s3
+
+import "context"
+
+// Warmup lists the objects of the volume at bucket/prefix and discards
+// them, so that gateways which cache listings can serve the first listing of
+// a mounter from their cache. It stops after maxObjects objects, which must
+// be positive, as warming up very large volumes would delay mounting them
+// for too long.
+func (client *s3Client) Warmup(bucketName, prefix string, maxObjects int) error {
+	ctx, cancel := context.WithCancel(client.ctx)
+	defer cancel()
+	listed := 0
+	for object := range client.list(ctx, bucketName, client.listOptions(prefixKey(prefix, ""), true)) {
+		if object.Err != nil {
+			return object.Err
+		}
+		listed++
+		if listed >= maxObjects {
+			break
+		}
+	}
+	client.log().V(4).Infof("Warmed up volume %s by listing %d objects", FormatVolumeID(bucketName, prefix), listed)
+	return nil
+}
@@ -5,4 +5,6 @@ export MINIO_SECRET_KEY=DSG643HGDS
 mkdir -p /tmp/minio
 minio server /tmp/minio &>/dev/null &
 sleep 5
+S3_TEST_ENDPOINT=http://127.0.0.1:9000 S3_TEST_ACCESS_KEY=$MINIO_ACCESS_KEY S3_TEST_SECRET_KEY=$MINIO_SECRET_KEY \
+    go test -tags integration -run Integration ./pkg/s3 && \
 go test ./... -cover -ginkgo.noisySkippings=false -ginkgo.skip="should fail when requesting to create a volume with already existing name and different capacity"